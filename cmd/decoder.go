@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/demonkingswarn/luffy/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	decoderAddr      string
+	decoderCacheTTL  time.Duration
+	decoderCacheSize int
+)
+
+func init() {
+	rootCmd.AddCommand(decoderCmd)
+	decoderCmd.AddCommand(decoderServeCmd)
+	decoderServeCmd.Flags().StringVar(&decoderAddr, "addr", "127.0.0.1:8787", "Address to listen on")
+	decoderServeCmd.Flags().DurationVar(&decoderCacheTTL, "cache-ttl", 10*time.Minute, "How long decoded links are cached (0 disables the cache)")
+	decoderServeCmd.Flags().IntVar(&decoderCacheSize, "cache-size", 1000, "Maximum number of cached links")
+}
+
+var decoderCmd = &cobra.Command{
+	Use:   "decoder",
+	Short: "Run luffy's extractors as a decoder service",
+}
+
+var decoderServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the decoder API over HTTP",
+	Long: `Serve answers GET /?url=<embed-url> with the sources and tracks of the
+embed, in the same JSON the public decoder returns, using luffy's own
+extractors. Point decoder_url in the config of other clients at it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := loadConfig(cmd.Context())
+		// Links no extractor handles are refused rather than passed on
+		core.ConfigureDecoder(false)
+
+		server := &core.DecoderServer{
+			Client:    core.NewClient(),
+			Timeout:   cfg.Timeouts.For(core.StageDecrypt),
+			CacheTTL:  decoderCacheTTL,
+			CacheSize: decoderCacheSize,
+			Log: func(link string, cached bool, d time.Duration, err error) {
+				status := "ok"
+				if err != nil {
+					status = err.Error()
+				} else if cached {
+					status = "cached"
+				}
+				fmt.Printf("%s %s %s (%s)\n", time.Now().Format(time.TimeOnly), link, status, d.Round(time.Millisecond))
+			},
+		}
+
+		ln, err := net.Listen("tcp", decoderAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Decoder listening on http://%s\n", ln.Addr())
+
+		srv := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			<-cmd.Context().Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	},
+}
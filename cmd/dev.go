@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/demonkingswarn/luffy/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	recordOutFlag  string
+	recordTypeFlag string
+)
+
+func init() {
+	rootCmd.AddCommand(devCmd)
+	devCmd.AddCommand(recordCmd)
+	recordCmd.Flags().StringVarP(&recordOutFlag, "out", "o", "", "Fixture file (default core/providers/testdata/<provider>.json)")
+	recordCmd.Flags().StringVarP(&recordTypeFlag, "type", "t", "", "Walk the first result of this type (movie, series)")
+}
+
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Tools for working on luffy",
+}
+
+var recordCmd = &cobra.Command{
+	Use:   "record <provider> <query>",
+	Short: "Record a provider's live responses into a test fixture",
+	Long: `Record walks a provider from the search down to a stream, taking the first
+result, season, episode and server, and saves every HTTP exchange on the way
+as a fixture that the provider tests replay offline.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		loadConfig(cmd.Context())
+		info, ok := core.LookupProvider(args[0])
+		if !ok {
+			return fmt.Errorf("unknown provider %q", args[0])
+		}
+		query := strings.Join(args[1:], " ")
+
+		var mediaType core.MediaType
+		switch strings.ToLower(recordTypeFlag) {
+		case "":
+		case "movie":
+			mediaType = core.Movie
+		case "series", "tv":
+			mediaType = core.Series
+		default:
+			return fmt.Errorf("unknown type %q", recordTypeFlag)
+		}
+
+		client := core.NewClient()
+		recorder := &core.Recorder{Transport: client.Transport}
+		client.Transport = recorder
+
+		chain, err := core.WalkChain(cmd.Context(), info.New(client), query, mediaType)
+		if err != nil {
+			return fmt.Errorf("%s: %w", info.Name, err)
+		}
+
+		out := recordOutFlag
+		if out == "" {
+			out = filepath.Join("core", "providers", "testdata", info.Name+".json")
+		}
+		fixture := &core.Fixture{
+			Provider:  info.Name,
+			Query:     query,
+			Type:      mediaType,
+			Exchanges: recorder.Exchanges(),
+		}
+		if err := fixture.Save(out); err != nil {
+			return err
+		}
+
+		fmt.Printf("Recorded %d exchanges to %s\n", len(fixture.Exchanges), out)
+		fmt.Printf("Result: %s\n", chain.Result.Title)
+		fmt.Printf("Stream: %s\n", chain.Stream.URL())
+		return nil
+	},
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/demonkingswarn/luffy/core"
+	"github.com/spf13/cobra"
+)
+
+var extractorFlag string
+
+func init() {
+	rootCmd.AddCommand(extractCmd)
+	extractCmd.Flags().StringVarP(&extractorFlag, "extractor", "x", "", "Use this extractor instead of the one matching the host")
+}
+
+var extractCmd = &cobra.Command{
+	Use:   "extract <embed-url>",
+	Short: "Resolve a single embed link and print the stream",
+	Long: `Extract runs the extractor registered for the host of an embed link, or the
+remote decoder when there is none, and prints what it found: the variants,
+subtitles and the headers the stream has to be requested with.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := loadConfig(cmd.Context())
+		link := args[0]
+		if u, err := url.Parse(link); err != nil || u.Host == "" {
+			return fmt.Errorf("not a url: %s", link)
+		}
+
+		extractor, ok := core.FindExtractor(link)
+		if extractorFlag != "" {
+			if extractor, ok = core.LookupExtractor(extractorFlag); !ok {
+				return fmt.Errorf("unknown extractor %q", extractorFlag)
+			}
+		}
+
+		ctx, cancel := cfg.Timeouts.WithStage(cmd.Context(), core.StageDecrypt)
+		defer cancel()
+		client := core.NewClient()
+
+		var stream *core.Stream
+		var err error
+		if ok {
+			fmt.Println("Extractor:", extractor.Name())
+			stream, err = extractor.Extract(ctx, link, client)
+		} else {
+			fmt.Println("Extractor: decoder")
+			stream, err = core.DecryptStreamWithDecoder(ctx, link, client)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Container:", stream.Container)
+		for _, v := range stream.Variants {
+			if label := qualityLabel(v); label != "" {
+				fmt.Printf("Variant: %s %s\n", label, v.URL)
+			} else {
+				fmt.Println("Variant:", v.URL)
+			}
+		}
+		if stream.Referer != "" {
+			fmt.Println("Referer:", stream.Referer)
+		}
+		if stream.UserAgent != "" {
+			fmt.Println("User-Agent:", stream.UserAgent)
+		}
+		for _, k := range slices.Sorted(maps.Keys(stream.Headers)) {
+			fmt.Printf("Header: %s: %s\n", k, stream.Headers[k])
+		}
+		for _, s := range stream.Subtitles {
+			fmt.Printf("Subtitle: %s %s\n", strings.TrimSpace(s.Language+" "+s.Label), s.URL)
+		}
+		return nil
+	},
+}
@@ -1,13 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net/url"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/demonkingswarn/luffy/core"
 	"github.com/demonkingswarn/luffy/core/providers"
@@ -22,19 +27,31 @@ var (
 	backendFlag   string
 	cacheFlag     string
 	providerFlag  string
+	serverFlag    string
+	subLangFlag   string
+	qualityFlag   string
+	noValidate    bool
 	debugFlag     bool
+	harFlag       string
+	noCacheFlag   bool
+	refreshFlag   bool
 	updateFlag    bool
 )
 
-const USER_AGENT = "luffy/1.0.14"
-
 func init() {
 	rootCmd.Flags().IntVarP(&seasonFlag, "season", "s", 0, "Specify season number")
 	rootCmd.Flags().StringVarP(&episodeFlag, "episodes", "e", "", "Specify episode or range (e.g. 1, 1-5)")
 	rootCmd.Flags().StringVarP(&actionFlag, "action", "a", "", "Action to perform (play, download)")
 	rootCmd.Flags().BoolVar(&showImageFlag, "show-image", false, "Show poster preview using chafa")
-	rootCmd.Flags().StringVarP(&providerFlag, "provider", "p", "", "Specify provider")
+	rootCmd.Flags().StringVarP(&providerFlag, "provider", "p", "", "Specify provider, a comma-separated list, or \"all\"")
+	rootCmd.Flags().StringVar(&serverFlag, "server", "", "Preferred servers, comma-separated (e.g. upcloud,vidcloud), or \"choose\"")
+	rootCmd.Flags().StringVarP(&qualityFlag, "quality", "q", "", "Quality to pick without asking: best, worst, 1080p, <=720p, max_bitrate or ask")
+	rootCmd.Flags().BoolVar(&noValidate, "no-validate", false, "Hand streams to the player without checking that they load first")
+	rootCmd.Flags().StringVar(&subLangFlag, "sub-lang", "", "Subtitle languages, comma-separated (e.g. es,en), \"all\", \"none\" or \"choose\"")
 	rootCmd.Flags().BoolVarP(&debugFlag, "debug", "d", false, "Enable debug output")
+	rootCmd.PersistentFlags().StringVar(&harFlag, "har", "", "Record all HTTP traffic to a HAR file, for bug reports")
+	rootCmd.PersistentFlags().BoolVar(&noCacheFlag, "no-cache", false, "Neither read nor store cached searches and episode lists")
+	rootCmd.PersistentFlags().BoolVar(&refreshFlag, "refresh", false, "Fetch searches and episode lists again, updating the cache")
 	rootCmd.Flags().BoolVarP(&updateFlag, "update", "u", false, "Update Luffy")
 
 	rootCmd.AddCommand(previewCmd)
@@ -48,67 +65,90 @@ var rootCmd = &cobra.Command{
 	Version: core.Version,
 	Args:    cobra.ArbitraryArgs,
 
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if harFlag != "" {
+			core.StartHAR()
+		}
+	},
+
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if updateFlag {
 			return core.Update()
 		}
 
+		baseCtx := cmd.Context()
 		client := core.NewClient()
 		ctx := &core.Context{
 			Client: client,
 			Debug:  debugFlag,
 		}
 
-		cfg := core.LoadConfig()
-		var providerName string
-		if providerFlag != "" {
-			providerName = providerFlag
-		} else {
-			providerName = cfg.Provider
-		}
-
-		var provider core.Provider
-		if strings.EqualFold(providerName, "sflix") {
-			provider = providers.NewSflix(client)
-		} else if strings.EqualFold(providerName, "hdrezka") {
-			provider = providers.NewHDRezka(client)
-		} else if strings.EqualFold(providerName, "braflix") {
-			provider = providers.NewBraflix(client)
-		} else if strings.EqualFold(providerName, "brocoflix") {
-			provider = providers.NewBrocoflix(client)
-		} else if strings.EqualFold(providerName, "xprime") {
-			provider = providers.NewXPrime(client)
-		} else if strings.EqualFold(providerName, "movies4u") {
-			provider = providers.NewMovies4u(client)
-		} else if strings.EqualFold(providerName, "youtube") {
-			provider = providers.NewYouTube(client)
-		} else {
-			provider = providers.NewFlixHQ(client)
-		}
+		cfg := loadConfig(baseCtx)
+		infos := resolveProviders(providerFlag, cfg)
 
 		if len(args) == 0 {
-			ctx.Query = core.Prompt("Search")
+			query, err := core.Prompt(baseCtx, "Search")
+			if err != nil {
+				return err
+			}
+			ctx.Query = query
 		} else {
 			ctx.Query = strings.Join(args, " ")
 		}
 
-		results, err := provider.Search(ctx.Query)
-		if err != nil {
-			return err
-		}
+		var info core.ProviderInfo
+		var selected core.SearchResult
+		var sources []core.Source
+
+		searchCtx, cancel := cfg.Timeouts.WithStage(baseCtx, core.StageSearch)
+		if len(infos) == 1 {
+			info = infos[0]
+			results, err := info.New(client).Search(searchCtx, ctx.Query)
+			cancel()
+			if err != nil {
+				return err
+			}
+
+			var titles []string
+			for _, r := range results {
+				titles = append(titles, resultLabel(r.Type, r.Title, r.Year))
+			}
 
-		var titles []string
-		for _, r := range results {
-			title := fmt.Sprintf("[%s] %s", r.Type, r.Title)
-			if r.Year != "" {
-				title += fmt.Sprintf(" (%s)", r.Year)
+			var idx int
+			idx = core.Select("Results:", titles)
+			selected = results[idx]
+		} else {
+			merged, failed := core.FederatedSearch(searchCtx, client, infos, ctx.Query)
+			cancel()
+			if ctx.Debug {
+				for _, e := range failed {
+					fmt.Println("Search failed:", e)
+				}
+			}
+			if len(merged) == 0 {
+				return fmt.Errorf("no results from %d providers", len(infos))
+			}
+
+			var titles []string
+			for _, m := range merged {
+				titles = append(titles, resultLabel(m.Type, m.Title, m.Year)+" {"+strings.Join(m.ProviderNames(), ", ")+"}")
 			}
-			titles = append(titles, title)
+
+			idx := core.Select("Results:", titles)
+			result := merged[idx]
+
+			source := result.Sources[0]
+			if len(result.Sources) > 1 {
+				pIdx := core.Select("Provider:", result.ProviderNames())
+				source = result.Sources[pIdx]
+			}
+			info = source.Provider
+			selected = source.Result
+			sources = result.Sources
 		}
 
-		var idx int
-		idx = core.Select("Results:", titles)
-		selected := results[idx]
+		caps := info.Capabilities
+		provider := info.New(client)
 
 		ctx.Title = selected.Title
 		ctx.URL = selected.URL
@@ -116,22 +156,31 @@ var rootCmd = &cobra.Command{
 
 		fmt.Println("Selected:", ctx.Title)
 
-		mediaID, err := provider.GetMediaID(ctx.URL)
+		metaCtx, cancel := cfg.Timeouts.WithStage(baseCtx, core.StageMetadata)
+		media, err := provider.GetMediaID(metaCtx, ctx.URL)
+		cancel()
 		if err != nil {
 			return err
 		}
 
-		// For sflix, append media type to mediaID to help with server detection
-		// Format: "mediaID|type" (e.g., "39506|series" or "39506|movie")
-		// Braflix doesn't need this as it uses the same endpoint for both
-		if strings.EqualFold(providerName, "sflix") {
-			mediaID = mediaID + "|" + string(ctx.ContentType)
+		// Providers such as sflix pick their endpoints by media type
+		if media.Type == "" {
+			media.Type = ctx.ContentType
+		}
+		if ctx.Debug {
+			fmt.Println("Media:", media)
 		}
 
 		var episodesToProcess []core.Episode
+		var seasonNumber int
 
 		if ctx.ContentType == core.Series {
-			seasons, err := provider.GetSeasons(mediaID)
+			if !caps.SupportsSeries {
+				return fmt.Errorf("provider %s does not support series", info.Name)
+			}
+			metaCtx, cancel := cfg.Timeouts.WithStage(baseCtx, core.StageMetadata)
+			seasons, err := provider.GetSeasons(metaCtx, media)
+			cancel()
 			if err != nil {
 				return err
 			}
@@ -139,22 +188,25 @@ var rootCmd = &cobra.Command{
 				return fmt.Errorf("no seasons found")
 			}
 
-			var selectedSeason core.Season
+			var sIdx int
 			if seasonFlag > 0 {
 				if seasonFlag > len(seasons) {
 					return fmt.Errorf("season %d not found (max %d)", seasonFlag, len(seasons))
 				}
-				selectedSeason = seasons[seasonFlag-1]
+				sIdx = seasonFlag - 1
 			} else {
 				var sNames []string
 				for _, s := range seasons {
 					sNames = append(sNames, s.Name)
 				}
-				sIdx := core.Select("Seasons:", sNames)
-				selectedSeason = seasons[sIdx]
+				sIdx = core.Select("Seasons:", sNames)
 			}
+			selectedSeason := seasons[sIdx]
+			seasonNumber = selectedSeason.Ref.Season
 
-			allEpisodes, err := provider.GetEpisodes(selectedSeason.ID, true)
+			metaCtx, cancel = cfg.Timeouts.WithStage(baseCtx, core.StageMetadata)
+			allEpisodes, err := provider.GetEpisodes(metaCtx, selectedSeason.Ref)
+			cancel()
 			if err != nil {
 				return err
 			}
@@ -188,12 +240,6 @@ var rootCmd = &cobra.Command{
 				}
 			}
 
-		} else {
-			servers, err := provider.GetEpisodes(mediaID, false)
-			if err != nil || len(servers) == 0 {
-				return fmt.Errorf("could not find movie info")
-			}
-			episodesToProcess = servers
 		}
 
 		currentAction := actionFlag
@@ -204,104 +250,118 @@ var rootCmd = &cobra.Command{
 		}
 		currentAction = strings.ToLower(currentAction)
 
-		var selectedQualityIndex = -1
-
-		processStream := func(link, name string) error {
-			var streamURL string
-			var subtitles []string
-			var err error
-
-			referer := link
-			if strings.EqualFold(providerName, "hdrezka") {
-				referer = ctx.URL
+		qualityValue := cfg.Quality
+		if qualityFlag != "" {
+			qualityValue = qualityFlag
+		}
+		quality, err := core.ParseQuality(qualityValue)
+		if err != nil {
+			return err
+		}
+		var chosenLabel string
+
+		// pickVariant applies the quality policy. Without one it asks once and
+		// keeps picking the same resolution for the rest of the batch, as
+		// episodes do not always list the same variants.
+		// pickRemembered is pickVariant without asking: -1 when it would ask.
+		pickRemembered := func(variants []core.StreamQuality) int {
+			if idx := quality.Pick(variants); idx >= 0 {
+				return idx
 			}
-
-			if strings.EqualFold(providerName, "hdrezka") {
-				streams := strings.Split(link, ",")
-				var qualities []string
-				var urls []string
-
-				for _, s := range streams {
-					s = strings.TrimSpace(s)
-					if strings.HasPrefix(s, "[") {
-						end := strings.Index(s, "]")
-						if end > 1 {
-							qualityStr := s[1:end]
-							qualities = append(qualities, qualityStr)
-							urls = append(urls, s[end+1:])
-						}
-					} else {
-						qualities = append(qualities, "Default")
-						urls = append(urls, s)
-					}
+			for i, v := range variants {
+				if chosenLabel != "" && qualityLabel(v) == chosenLabel {
+					return i
 				}
+			}
+			return -1
+		}
+		pickVariant := func(variants []core.StreamQuality) core.StreamQuality {
+			if len(variants) == 1 {
+				return variants[0]
+			}
+			if idx := pickRemembered(variants); idx >= 0 {
+				return variants[idx]
+			}
+			var options []string
+			for _, v := range variants {
+				options = append(options, qualityLabel(v))
+			}
+			idx := core.Select("Select Quality:", options)
+			if h := core.VariantHeight(variants[idx]); h > 0 {
+				quality = core.Quality{Mode: core.QUALITY_EXACT, Height: h}
+			} else {
+				chosenLabel = options[idx]
+			}
+			return variants[idx]
+		}
 
-				if len(urls) > 1 {
-					var idx int
-					if selectedQualityIndex != -1 && selectedQualityIndex < len(urls) {
-						idx = selectedQualityIndex
-					} else {
-						idx = core.Select("Select Quality:", qualities)
-						selectedQualityIndex = idx
-					}
-					streamURL = urls[idx]
-				} else if len(urls) == 1 {
-					streamURL = urls[0]
-				} else {
-					streamURL = link
-				}
-				// Fix protocol if needed
-				if !strings.HasPrefix(streamURL, "http") {
-					// Sometimes it might be missing http
-				}
-			} else if strings.EqualFold(providerName, "movies4u") || strings.EqualFold(providerName, "youtube") {
-				streamURL = link
+		subPrefs := cfg.SubtitleLanguages
+		if subLangFlag != "" {
+			subPrefs = core.SplitList(subLangFlag)
+		}
+		chooseSub := false
+		var subLangs []string
+		for _, p := range subPrefs {
+			if strings.EqualFold(p, "choose") {
+				chooseSub = true
 			} else {
-				if ctx.Debug {
-					fmt.Println("Decrypting stream...")
-				}
-				var decryptedReferer string
-				streamURL, subtitles, decryptedReferer, err = core.DecryptStream(link, ctx.Client)
-				if err != nil {
-					fmt.Printf("Decryption failed for %s: %v\n", name, err)
-					return err
+				subLangs = append(subLangs, p)
+			}
+		}
+		// chosenSub is the language picked for the first episode, reused for the rest
+		var chosenSub string
+
+		pickSubtitles := func(subs []core.Subtitle) []core.Subtitle {
+			if !chooseSub || len(subs) == 0 {
+				return core.SelectSubtitles(subs, subLangs)
+			}
+			if chosenSub != "" {
+				return core.SelectSubtitles(subs, []string{chosenSub})
+			}
+			options := []string{"No subtitles"}
+			for _, s := range subs {
+				label := s.Label
+				if label == "" {
+					label = s.URL
 				}
-				if decryptedReferer != "" {
-					referer = decryptedReferer
+				if lang := s.Lang(); lang != "" {
+					label += " [" + lang + "]"
 				}
+				options = append(options, label)
+			}
+			idx := core.Select("Subtitles:", options)
+			if idx == 0 {
+				chosenSub = "none"
+				return nil
+			}
+			// The picked track goes first, followed by others in its language.
+			// Tracks of unknown language can't be matched later, so ask again.
+			picked := subs[idx-1]
+			chosenSub = picked.Lang()
+			if chosenSub == "" {
+				return []core.Subtitle{picked}
+			}
+			rest := core.SelectSubtitles(slices.Delete(slices.Clone(subs), idx-1, idx), []string{chosenSub})
+			return append([]core.Subtitle{picked}, rest...)
+		}
 
-				if strings.EqualFold(providerName, "sflix") || strings.EqualFold(providerName, "braflix") {
-					// Use the main URL of the embed link as referrer
-					if parsedURL, err := url.Parse(link); err == nil {
-						referer = fmt.Sprintf("%s://%s/", parsedURL.Scheme, parsedURL.Host)
-					} else {
-						referer = link
-					}
-				}
+		processStream := func(stream *core.Stream, caps core.Capabilities, name string) error {
+			if stream.UserAgent == "" {
+				stream.UserAgent = core.UserAgent()
 			}
+			stream.Subtitles = pickSubtitles(stream.Subtitles)
 
-			if strings.Contains(strings.ToLower(streamURL), ".m3u8") {
+			stream = stream.WithVariant(pickVariant(stream.Variants))
+
+			if !caps.DirectLinks && stream.Container == core.ContainerHLS {
 				if ctx.Debug {
 					fmt.Println("Checking for available qualities...")
 				}
-				streams, err := core.GetM3U8Streams(streamURL, ctx.Client)
-				if err == nil && len(streams) > 0 {
-					var options []string
-					for _, s := range streams {
-						res := s.Resolution
-						if s.Bandwidth > 0 {
-							res += fmt.Sprintf(" (%dkbps)", s.Bandwidth/1000)
-						}
-						options = append(options, res)
-					}
-					var idx int
-					if selectedQualityIndex != -1 && selectedQualityIndex < len(streams) {
-						idx = selectedQualityIndex
-					} else {
-						idx = core.Select("Select Quality:", options)
-						selectedQualityIndex = idx
-					}
-					streamURL = streams[idx].URL
+				probeCtx, cancel := cfg.Timeouts.WithStage(baseCtx, core.StageProbe)
+				variants, err := core.GetM3U8Streams(probeCtx, stream, ctx.Client)
+				cancel()
+				if err == nil && len(variants) > 0 {
+					stream = stream.WithVariant(pickVariant(variants))
 				} else if ctx.Debug {
 					fmt.Printf("Failed to parse m3u8 or no variants found: %v\n", err)
 				}
@@ -310,10 +370,9 @@ var rootCmd = &cobra.Command{
 			switch currentAction {
 			case "play":
 				if ctx.Debug {
-					fmt.Printf("Stream URL: %s\n", streamURL)
+					fmt.Printf("Stream URL: %s\n", stream.URL())
 				}
-				err = core.Play(streamURL, name, referer, USER_AGENT, subtitles, ctx.Debug)
-				if err != nil {
+				if err := core.Play(stream, name, ctx.Debug); err != nil {
 					fmt.Println("Error playing:", err)
 					return err
 				}
@@ -323,15 +382,20 @@ var rootCmd = &cobra.Command{
 				if dlPath == "" {
 					dlPath = homeDir
 				}
-				err = core.Download(homeDir, dlPath, name, streamURL, referer, USER_AGENT, subtitles, ctx.Debug)
-				if err != nil {
+				if err := core.Download(baseCtx, homeDir, dlPath, name, stream, pickRemembered, ctx.Debug); err != nil {
 					fmt.Println("Error downloading:", err)
 					return err
 				}
 			case "extract link", "extract", "copy":
-				fmt.Printf("\nStream URL [%s]: %s\n", name, streamURL)
-				if len(subtitles) > 0 {
-					fmt.Printf("Subtitles: %s\n", strings.Join(subtitles, ", "))
+				fmt.Printf("\nStream URL [%s]: %s\n", name, stream.URL())
+				if stream.Referer != "" {
+					fmt.Printf("Referer: %s\n", stream.Referer)
+				}
+				if subs := stream.SubtitleURLs(); len(subs) > 0 {
+					fmt.Printf("Subtitles: %s\n", strings.Join(subs, ", "))
+				}
+				if !stream.Expires.IsZero() {
+					fmt.Printf("Expires: %s\n", stream.Expires.Format(time.RFC1123))
 				}
 			default:
 				fmt.Println("Unknown action:", currentAction)
@@ -339,68 +403,107 @@ var rootCmd = &cobra.Command{
 			return nil
 		}
 
-		if ctx.ContentType == core.Movie {
-			fmt.Printf("\nProcessing: %s\n", ctx.Title)
-
-			var selectedServer core.Episode // abusing Episode struct for Server info
-			if len(episodesToProcess) > 0 {
-				selectedServer = episodesToProcess[0]
+		serverPrefs := cfg.ServerPreference
+		if serverFlag != "" {
+			serverPrefs = core.SplitList(serverFlag)
+		}
+		chooseServer := false
+		var prefs []string
+		for _, p := range serverPrefs {
+			if strings.EqualFold(p, "choose") {
+				chooseServer = true
+			} else {
+				prefs = append(prefs, p)
 			}
+		}
 
-			for _, s := range episodesToProcess {
-				if strings.EqualFold(providerName, "hdrezka") {
-					selectedServer = s
-					break
+		resolver := &core.Resolver{
+			Client:           client,
+			Timeouts:         cfg.Timeouts,
+			Failover:         cfg.Failover,
+			ServerPreference: prefs,
+			Quality:          quality,
+			Report: func(a core.Attempt) {
+				if a.Err != nil {
+					fmt.Println("Failed:", a)
+				} else if ctx.Debug {
+					fmt.Println("Resolved:", a)
+				}
+			},
+		}
+		if !cfg.Validation.Disabled && !noValidate {
+			// Dead links, error pages and slow hosts count as failed servers
+			// The variant checked is the one the quality policy will pick
+			validator := &core.Validator{Client: client, Config: cfg.Validation, Pick: pickRemembered}
+			if ctx.Debug {
+				validator.Report = func(c core.StreamCheck, err error) {
+					if err != nil {
+						fmt.Printf("Stream check of %s failed: %v (%s)\n", c.URL, err, c)
+					} else {
+						fmt.Printf("Stream check of %s: %s\n", c.URL, c)
+					}
+				}
+			}
+			resolver.Validate = validator.Validate
+		}
+		if chooseServer {
+			// Ask once and keep using the same server name for the rest of the batch
+			var chosen string
+			resolver.Choose = func(provider string, servers []core.Server) int {
+				if chosen != "" {
+					for i, s := range servers {
+						if s.Name == chosen {
+							return i
+						}
+					}
 				}
-				if strings.Contains(strings.ToLower(s.Name), "vidcloud") {
-					selectedServer = s
-					break
+				var names []string
+				for _, s := range servers {
+					names = append(names, s.Name)
 				}
+				idx := core.Select("Server:", names)
+				chosen = servers[idx].Name
+				return idx
 			}
+		}
+		target := core.Target{
+			Title:   ctx.Title,
+			Year:    selected.Year,
+			Type:    ctx.ContentType,
+			TMDB:    selected.TMDB,
+			Sources: sources,
+		}
 
-			link, err := provider.GetLink(selectedServer.ID)
+		resolve := func(ref core.MediaRef, name string) error {
+			t := target
+			t.Season = seasonNumber
+			t.Episode = ref.Episode
+			res, err := resolver.Resolve(baseCtx, core.Candidate{Info: info, Provider: provider, Ref: ref, PageURL: ctx.URL}, t)
 			if err != nil {
-				return fmt.Errorf("error getting link: %v", err)
+				fmt.Printf("Could not resolve %s: %v\n", name, err)
+				return err
 			}
+			if res.Info.Name != info.Name {
+				fmt.Printf("Using %s (%s)\n", res.Info.Name, res.Server.Name)
+			}
+			return processStream(res.Stream, res.Info.Capabilities, name)
+		}
 
-			if err := processStream(link, ctx.Title); err != nil {
+		if ctx.ContentType == core.Movie {
+			fmt.Printf("\nProcessing: %s\n", ctx.Title)
+			if err := resolve(media, ctx.Title); err != nil {
 				return err
 			}
-
 		} else {
 			// Series Processing
 			for _, ep := range episodesToProcess {
 				fmt.Printf("\nProcessing: %s\n", ep.Name)
 
-				servers, err := provider.GetServers(ep.ID)
-				if err != nil {
-					fmt.Println("Error fetching servers:", err)
-					continue
-				}
-				if len(servers) == 0 {
-					fmt.Println("No servers found")
-					continue
-				}
-
-				selectedServer := servers[0]
-				if !strings.EqualFold(providerName, "hdrezka") {
-					for _, s := range servers {
-						if strings.Contains(strings.ToLower(s.Name), "vidcloud") {
-							selectedServer = s
-							break
-						}
-					}
-				}
-
-				link, err := provider.GetLink(selectedServer.ID)
-				if err != nil {
-					fmt.Println("Error getting link:", err)
-					continue
+				if baseCtx.Err() != nil {
+					return baseCtx.Err()
 				}
 
-				if err := processStream(link, ctx.Title+" - "+ep.Name); err != nil {
-					continue
-				}
+				resolve(ep.Ref, ctx.Title+" - "+ep.Name)
 			}
 		}
 
@@ -408,10 +511,138 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// loadConfig reads the config file, applies its site overrides and loads
+// provider definitions and plugins.
+func loadConfig(ctx context.Context) *core.Config {
+	cfg := core.LoadConfig()
+	if cfg.DecoderURL != "" {
+		if cfg.Sites == nil {
+			cfg.Sites = map[string]core.Site{}
+		}
+		site := cfg.Sites["decoder"]
+		site.BaseURL = cfg.DecoderURL
+		cfg.Sites["decoder"] = site
+	}
+	core.ConfigureSites(cfg.Sites)
+	if noCacheFlag {
+		cfg.HTTP.Cache.Disabled = true
+	}
+	cfg.HTTP.Cache.Refresh = refreshFlag
+	if err := core.ConfigureHTTP(cfg.HTTP); err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring http config: %v\n", err)
+	}
+	core.ConfigureDecoder(cfg.RemoteDecoder || cfg.DecoderURL != "")
+	if err := core.ConfigureDownloader(cfg.Downloader); err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring downloader config: %v\n", err)
+	}
+	if dir := core.ConfigDir(); dir != "" {
+		// Definitions in ~/.config/luffy/providers replace built-in providers of the same name
+		for _, err := range providers.LoadScrapers(filepath.Join(dir, "providers")) {
+			fmt.Fprintf(os.Stderr, "Skipping provider definition %v\n", err)
+		}
+	}
+
+	// Plugin stderr is only shown with --debug
+	var pluginLog io.Writer
+	if debugFlag {
+		pluginLog = os.Stderr
+	}
+	for _, err := range providers.LoadPlugins(ctx, providers.FindPlugins(cfg.Plugins), pluginLog) {
+		fmt.Fprintf(os.Stderr, "Skipping plugin %v\n", err)
+	}
+	return cfg
+}
+
+// resolveProviders turns --provider and the config into the providers to search.
+// "all" means the providers listed in the config, or every registered provider.
+func resolveProviders(flag string, cfg *core.Config) []core.ProviderInfo {
+	var names []string
+	switch {
+	case strings.EqualFold(flag, "all"):
+		if len(cfg.Providers) == 0 {
+			return core.RegisteredProviders()
+		}
+		names = cfg.Providers
+	case flag != "":
+		names = core.SplitList(flag)
+	case len(cfg.Providers) > 0:
+		names = cfg.Providers
+	default:
+		names = []string{cfg.Provider}
+	}
+
+	var infos []core.ProviderInfo
+	seen := map[string]bool{}
+	for _, name := range names {
+		info, ok := core.LookupProvider(strings.TrimSpace(name))
+		if !ok {
+			if len(names) > 1 {
+				fmt.Printf("Unknown provider %q, skipping\n", name)
+			}
+			continue
+		}
+		if !seen[info.Name] {
+			seen[info.Name] = true
+			infos = append(infos, info)
+		}
+	}
+
+	if len(infos) == 0 {
+		info, _ := core.LookupProvider("flixhq")
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func resultLabel(mediaType core.MediaType, title, year string) string {
+	label := fmt.Sprintf("[%s] %s", mediaType, title)
+	if year != "" {
+		label += fmt.Sprintf(" (%s)", year)
+	}
+	return label
+}
+
+func qualityLabel(s core.StreamQuality) string {
+	label := s.Label
+	if label == "" {
+		label = s.Resolution
+	}
+	if s.FrameRate > 30 {
+		label += fmt.Sprintf(" %.0ffps", s.FrameRate)
+	}
+	if s.VideoRange == "PQ" || s.VideoRange == "HLG" {
+		label += " HDR"
+	}
+	if s.Bandwidth > 0 {
+		label += fmt.Sprintf(" (%dkbps)", s.Bandwidth/1000)
+	}
+	return label
+}
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Ctrl-C cancels in-flight requests instead of killing luffy mid-request
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		// Only the first Ctrl-C is caught; a second one kills luffy as usual,
+		// even while it waits on a prompt or picker
+		<-ctx.Done()
+		stop()
+	}()
+
+	defer providers.ClosePlugins()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
 		fmt.Println(err)
 	}
+
+	if har := core.StopHAR(); har != nil {
+		if err := har.WriteFile(harFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not write HAR:", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "Traffic recorded to", harFlag)
+		}
+	}
 }
 
 var previewCmd = &cobra.Command{
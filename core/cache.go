@@ -0,0 +1,316 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CACHE_ENTRY_LIMIT is the largest response body kept in the cache.
+const CACHE_ENTRY_LIMIT = 4 << 20
+
+// CacheConfig is the cache section of the http config.
+type CacheConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Dir defaults to ~/.cache/luffy/http.
+	Dir string `yaml:"dir"`
+	// MaxSizeMB bounds the cache; the least recently used entries go first.
+	MaxSizeMB int64 `yaml:"max_size_mb"`
+	// TTL is how long each kind of response is kept: "metadata" (TMDB
+	// details and seasons), "search" and "episodes" (season and episode
+	// lists). 0 turns caching off for that kind.
+	TTL map[string]time.Duration `yaml:"ttl"`
+	// Refresh skips cached responses but stores the new ones, for --refresh.
+	Refresh bool `yaml:"-"`
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		MaxSizeMB: 100,
+		TTL: map[string]time.Duration{
+			"metadata": 24 * time.Hour,
+			"search":   30 * time.Minute,
+			"episodes": 6 * time.Hour,
+		},
+	}
+}
+
+// cacheRules pick the responses worth caching by site and by the path after
+// its base URL. Anything else, stream sources and server lists above all,
+// always goes to the network.
+var cacheRules = []struct {
+	site    string // "" for any provider
+	pattern *regexp.Regexp
+	kind    string
+}{
+	{"tmdb", regexp.MustCompile(`^/search/`), "search"},
+	{"tmdb", regexp.MustCompile(`^/(tv|movie)/`), "metadata"},
+	{"", regexp.MustCompile(`^/ajax/season/(list|episodes)/`), "episodes"},
+	{"", regexp.MustCompile(`(^|/)search(/|\?|$)|[?&](s|q|query|keyword|search_query)=`), "search"},
+}
+
+// cacheKind returns which kind of cached response req is, or "".
+func cacheKind(req *http.Request) string {
+	if req.Method != "" && req.Method != http.MethodGet {
+		return ""
+	}
+	name, _, rest, ok := siteForURL(req.URL.String())
+	if !ok || name == "decoder" {
+		return ""
+	}
+	for _, rule := range cacheRules {
+		if rule.site != "" && rule.site != name {
+			continue
+		}
+		if rule.site == "" && name == "tmdb" {
+			continue
+		}
+		if rule.pattern.MatchString(rest) {
+			return rule.kind
+		}
+	}
+	return ""
+}
+
+// cacheEntry is the first line of a cache file; the body follows it.
+type cacheEntry struct {
+	URL     string      `json:"url"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Expires time.Time   `json:"expires"`
+}
+
+// cacheTransport answers catalogue lookups (searches, TMDB details, season
+// and episode lists) from disk while they are fresh, and stores the 200s it
+// fetches.
+type cacheTransport struct {
+	base    http.RoundTripper
+	dir     string
+	maxSize int64
+	ttl     map[string]time.Duration
+	refresh bool
+
+	mu   sync.Mutex
+	size int64 // -1 until the directory has been measured
+}
+
+func newCacheTransport(base http.RoundTripper, cfg CacheConfig) *cacheTransport {
+	return &cacheTransport{
+		base:    base,
+		dir:     cfg.Dir,
+		maxSize: cfg.MaxSizeMB << 20,
+		ttl:     cfg.TTL,
+		refresh: cfg.Refresh,
+		size:    -1,
+	}
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ttl := t.ttl[cacheKind(req)]
+	if ttl <= 0 || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+	path := t.path(req)
+	if !t.refresh {
+		if resp := t.load(req, path); resp != nil {
+			return resp, nil
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, CACHE_ENTRY_LIMIT+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > CACHE_ENTRY_LIMIT {
+		// Too big to keep, hand it on as it is
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	t.store(path, cacheEntry{
+		URL:     redactSecrets(req.URL.String()),
+		Status:  resp.StatusCode,
+		Header:  cacheHeader(resp.Header),
+		Expires: time.Now().Add(ttl),
+	}, body)
+	return resp, nil
+}
+
+func (t *cacheTransport) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String() + "\n" + req.Header.Get("Accept-Language")))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(t.dir, key[:2], key)
+}
+
+func (t *cacheTransport) load(req *http.Request, path string) *http.Response {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if json.Unmarshal(line, &entry) != nil || time.Now().After(entry.Expires) {
+		f.Close()
+		t.remove(path)
+		return nil
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	// The modification time orders entries for eviction
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return &http.Response{
+		Status:        http.StatusText(entry.Status),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        entry.Header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func (t *cacheTransport) store(path string, entry cacheEntry, body []byte) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return
+	}
+	f.Write(append(line, '\n'))
+	f.Write(body)
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	old, _ := os.Stat(path)
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.size < 0 {
+		t.size = t.measure()
+		return
+	}
+	t.size += int64(len(line) + 1 + len(body))
+	if old != nil {
+		t.size -= old.Size()
+	}
+	if t.maxSize > 0 && t.size > t.maxSize {
+		t.size = t.evict()
+	}
+}
+
+func (t *cacheTransport) remove(path string) {
+	info, err := os.Stat(path)
+	if err != nil || os.Remove(path) != nil {
+		return
+	}
+	t.mu.Lock()
+	if t.size >= 0 {
+		t.size -= info.Size()
+	}
+	t.mu.Unlock()
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (t *cacheTransport) files() []cacheFile {
+	var files []cacheFile
+	filepath.WalkDir(t.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, cacheFile{path, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+	return files
+}
+
+// measure returns the size of the cache, evicting if it is over the limit.
+func (t *cacheTransport) measure() int64 {
+	var size int64
+	for _, f := range t.files() {
+		size += f.size
+	}
+	if t.maxSize > 0 && size > t.maxSize {
+		return t.evict()
+	}
+	return size
+}
+
+// evict removes the least recently used entries until the cache is down to
+// three quarters of its limit, so it is not swept on every store.
+func (t *cacheTransport) evict() int64 {
+	files := t.files()
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	for _, f := range files {
+		if size <= t.maxSize*3/4 {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			size -= f.size
+		}
+	}
+	return size
+}
+
+// cacheHeader drops the headers that must not be replayed from the cache.
+func cacheHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range []string{"Set-Cookie", "Date", "Age", "Connection", "Transfer-Encoding"} {
+		h.Del(name)
+	}
+	return h
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
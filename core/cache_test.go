@@ -0,0 +1,122 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheTransport(t *testing.T) {
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer srv.Close()
+	RegisterSite("cachetest", srv.URL)
+
+	dir := t.TempDir()
+	cfg := HTTPConfig{CookieFile: "none", Cache: CacheConfig{Dir: dir}}
+	if err := ConfigureHTTP(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureHTTP(HTTPConfig{CookieFile: "none"})
+
+	get := func(path string) string {
+		t.Helper()
+		resp, err := NewClient().Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	for range 2 {
+		if body := get("/search/dune"); body != "/search/dune" {
+			t.Fatalf("search body = %q", body)
+		}
+		get("/ajax/season/list/42")
+		get("/ajax/episode/sources/7")
+	}
+	if hits["/search/dune"] != 1 || hits["/ajax/season/list/42"] != 1 {
+		t.Errorf("cached lookups hit the server: %v", hits)
+	}
+	if hits["/ajax/episode/sources/7"] != 2 {
+		t.Errorf("stream sources were cached: %v", hits)
+	}
+
+	// --refresh fetches again and stores the new response
+	cfg.Cache.Refresh = true
+	ConfigureHTTP(cfg)
+	get("/search/dune")
+	cfg.Cache.Refresh = false
+	ConfigureHTTP(cfg)
+	get("/search/dune")
+	if hits["/search/dune"] != 2 {
+		t.Errorf("search fetched %d times with --refresh", hits["/search/dune"])
+	}
+
+	// --no-cache leaves the cache alone
+	cfg.Cache.Disabled = true
+	ConfigureHTTP(cfg)
+	get("/search/dune")
+	if hits["/search/dune"] != 3 {
+		t.Errorf("search fetched %d times with --no-cache", hits["/search/dune"])
+	}
+}
+
+func TestCacheKind(t *testing.T) {
+	RegisterSite("kindtest", "https://kind.example")
+	for link, want := range map[string]string{
+		TMDB_BASE_URL + "/search/multi?query=dune":        "search",
+		TMDB_BASE_URL + "/tv/1399/season/1?api_key=x":     "metadata",
+		"https://kind.example/search/the-office":          "search",
+		"https://kind.example/?s=dune":                    "search",
+		"https://kind.example/ajax/season/episodes/123":   "episodes",
+		"https://kind.example/ajax/episode/servers/123":   "",
+		"https://kind.example/ajax/episode/sources/123":   "",
+		"https://kind.example/movie/watch-dune-12345":     "",
+		"https://unregistered.example/search/the-office":  "",
+		SiteURL("decoder") + "/?url=https://kind.example": "",
+	} {
+		req, _ := http.NewRequest("GET", link, nil)
+		if got := cacheKind(req); got != want {
+			t.Errorf("cacheKind(%s) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	c := newCacheTransport(nil, CacheConfig{Dir: dir})
+	c.maxSize = 4000
+
+	old := time.Now().Add(-time.Hour)
+	for i, name := range []string{"aa/old", "bb/mid", "cc/new"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		os.WriteFile(path, []byte(strings.Repeat("x", 1500)), 0600)
+		at := old.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, at, at)
+	}
+	if size := c.measure(); size != 3000 {
+		t.Fatalf("size = %d", size)
+	}
+
+	c.maxSize = 2000
+	if size := c.evict(); size != 1500 {
+		t.Errorf("size after eviction = %d", size)
+	}
+	for name, kept := range map[string]bool{"aa/old": false, "bb/mid": false, "cc/new": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%s kept = %v", name, err == nil)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Chain is what a provider returned at every step from a search down to a stream.
+type Chain struct {
+	Result   SearchResult
+	Results  []SearchResult
+	Media    MediaRef
+	Seasons  []Season
+	Episodes []Episode
+	Servers  []Server
+	Stream   *Stream
+}
+
+// WalkChain follows the first search result of the given type (any type when
+// empty) down to a stream, taking the first season, episode and server on the
+// way. It returns what it got so far along with the error of a failed step.
+func WalkChain(ctx context.Context, p Provider, query string, mediaType MediaType) (*Chain, error) {
+	c := &Chain{}
+
+	results, err := p.Search(ctx, query)
+	if err != nil {
+		return c, fmt.Errorf("search: %w", err)
+	}
+	c.Results = results
+
+	found := false
+	for _, r := range results {
+		if mediaType == "" || r.Type == mediaType {
+			c.Result = r
+			found = true
+			break
+		}
+	}
+	if !found {
+		return c, errors.New("search: no matching result")
+	}
+
+	media, err := p.GetMediaID(ctx, c.Result.URL)
+	if err != nil {
+		return c, fmt.Errorf("get media id: %w", err)
+	}
+	if media.Type == "" {
+		media.Type = c.Result.Type
+	}
+	c.Media = media
+
+	if media.Type == Series {
+		c.Seasons, err = p.GetSeasons(ctx, media)
+		if err != nil {
+			return c, fmt.Errorf("get seasons: %w", err)
+		}
+		if len(c.Seasons) == 0 {
+			return c, errors.New("get seasons: no seasons")
+		}
+
+		c.Episodes, err = p.GetEpisodes(ctx, c.Seasons[0].Ref)
+		if err != nil {
+			return c, fmt.Errorf("get episodes: %w", err)
+		}
+		if len(c.Episodes) == 0 {
+			return c, errors.New("get episodes: no episodes")
+		}
+
+		c.Servers, err = p.GetServers(ctx, c.Episodes[0].Ref)
+		if err != nil {
+			return c, fmt.Errorf("get servers: %w", err)
+		}
+	} else {
+		// Movies list their servers from GetEpisodes
+		c.Servers, err = ListServers(ctx, p, media)
+		if err != nil {
+			return c, fmt.Errorf("get episodes: %w", err)
+		}
+	}
+	if len(c.Servers) == 0 {
+		return c, errors.New("get servers: no servers")
+	}
+
+	c.Stream, err = p.GetLink(ctx, c.Servers[0].Ref)
+	if err != nil {
+		return c, fmt.Errorf("get link: %w", err)
+	}
+	return c, nil
+}
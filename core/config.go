@@ -1,52 +1,121 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	FzfPath      string `yaml:"fzf_path"`
-	Player       string `yaml:"player"`
-	ImageBackend string `yaml:"image_backend"`
-	Provider     string `yaml:"provider"`
-	DlPath       string `yaml:"dl_path"`
+	FzfPath      string   `yaml:"fzf_path"`
+	Player       string   `yaml:"player"`
+	ImageBackend string   `yaml:"image_backend"`
+	Provider     string   `yaml:"provider"`
+	Providers    []string `yaml:"providers"`
+	DlPath       string   `yaml:"dl_path"`
+	Timeouts     Timeouts `yaml:"timeouts"`
+	Failover     Failover `yaml:"failover"`
+	// HTTP configures the connection timeouts, retries, proxy, cookies and
+	// user agent of every request luffy makes.
+	HTTP HTTPConfig `yaml:"http"`
+	// Validation checks that streams load before they are played or downloaded.
+	Validation ValidationConfig `yaml:"validation"`
+	// PlayerProxy is "auto", "always" or "never": whether to play streams
+	// through a localhost proxy that sends the headers their host expects.
+	// Auto does so for players that cannot send them, mpc-be and Android VLC.
+	PlayerProxy string `yaml:"player_proxy"`
+	// Quality picks variants without asking: best, worst, 1080p, <=720p or
+	// max_bitrate. Empty or "ask" asks.
+	Quality string `yaml:"quality"`
+	// Downloader is "auto", "native" or "yt-dlp", see ConfigureDownloader.
+	Downloader string `yaml:"downloader"`
+	// ServerPreference ranks servers by name, e.g. [upcloud, vidcloud] or hdrezka translators.
+	// The entry "choose" asks which server to use instead.
+	ServerPreference []string `yaml:"server_preference"`
+	// SubtitleLanguages lists the subtitle languages to keep, most wanted first,
+	// as codes or names. "all" keeps the rest too, "none" turns subtitles off
+	// and "choose" asks which track to use.
+	SubtitleLanguages []string `yaml:"subtitle_languages"`
+	// Sites overrides the base URL of providers and services and adds mirrors, keyed by name.
+	Sites map[string]Site `yaml:"sites"`
+	// RemoteDecoder sends embed links no built-in extractor handles to the remote decoder.
+	RemoteDecoder bool `yaml:"remote_decoder"`
+	// DecoderURL points the remote decoder at another server, such as a
+	// "luffy decoder serve", and turns it on.
+	DecoderURL string `yaml:"decoder_url"`
+	// Plugins lists provider executables to load besides the luffy-provider-* ones on PATH.
+	Plugins []string `yaml:"plugins"`
 }
 
-func LoadConfig() *Config {
-	config := &Config{
+func defaultConfig() *Config {
+	return &Config{
 		FzfPath:      "fzf",    // Default
 		Player:       "mpc-be", // Default player
 		ImageBackend: "sixel",  // Default image backend
 		Provider:     "flixhq", // Default provider
 		DlPath:       "",       // Default: use home directory
+		Downloader:   "auto",
+		PlayerProxy:  PLAYER_PROXY_AUTO,
+		Timeouts:     DefaultTimeouts(),
+		HTTP:         DefaultHTTPConfig(),
+		Failover:     DefaultFailover(),
+		Validation:   DefaultValidationConfig(),
+
+		// English first, then every other track, as players got before there was a choice
+		SubtitleLanguages: []string{"en", "all"},
 	}
+}
+
+// SplitList splits a comma-separated flag value such as --server or
+// --sub-lang, trimming spaces and dropping empty entries.
+func SplitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
 
+// ConfigDir returns ~/.config/luffy, or "" when the home directory is unknown.
+func ConfigDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "luffy")
+}
+
+func LoadConfig() *Config {
+	config := defaultConfig()
+
+	dir := ConfigDir()
+	if dir == "" {
 		return config
 	}
 
-	configPath := filepath.Join(home, ".config", "luffy", "config.yaml")
+	configPath := filepath.Join(dir, "config.yaml")
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		// Config file doesn't exist or can't be read, use defaults
+		// No config file means the defaults; one that cannot be read is worth a word
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Ignoring %s, using the defaults: %v\n", configPath, err)
+		}
 		return config
 	}
 
 	// Parse YAML into config struct
 	err = yaml.Unmarshal(data, config)
 	if err != nil {
-		// YAML parsing failed, return defaults
-		return &Config{
-			FzfPath:      "fzf",
-			Player:       "mpc-be",
-			ImageBackend: "sixel",
-			Provider:     "flixhq",
-			DlPath:       "",
-		}
+		// A half-read config is worse than none, so fall back to the defaults, but say so
+		fmt.Fprintf(os.Stderr, "Ignoring %s, using the defaults: %v\n", configPath, err)
+		return defaultConfig()
 	}
 
 	return config
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieJar is a cookie jar that keeps persistent cookies in a file, so
+// sessions and challenge cookies survive between runs.
+type CookieJar struct {
+	jar  *cookiejar.Jar
+	path string
+
+	mu     sync.Mutex
+	stored map[string]storedCookie
+}
+
+type storedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// NewCookieJar loads the jar from path, or returns an in-memory jar when path
+// is empty. A missing file is not an error.
+func NewCookieJar(path string) (*CookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	j := &CookieJar{jar: jar, path: path, stored: map[string]storedCookie{}}
+	if path == "" {
+		return j, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stored []storedCookie
+	if err := json.Unmarshal(data, &stored); err != nil {
+		// A broken jar only costs logins, start over
+		return j, nil
+	}
+	now := time.Now()
+	for _, s := range stored {
+		u, err := url.Parse(s.URL)
+		if err != nil || s.Cookie == nil || !s.Cookie.Expires.After(now) {
+			continue
+		}
+		j.jar.SetCookies(u, []*http.Cookie{s.Cookie})
+		j.stored[cookieKey(u, s.Cookie)] = s
+	}
+	return j, nil
+}
+
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	if j.path == "" {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	changed := false
+	now := time.Now()
+	for _, c := range cookies {
+		c := *c
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+			c.MaxAge = 0
+		}
+		key := cookieKey(u, &c)
+		switch {
+		case c.MaxAge < 0 || (!c.Expires.IsZero() && !c.Expires.After(now)):
+			// Deleted
+		case !c.Expires.IsZero():
+			j.stored[key] = storedCookie{URL: u.Scheme + "://" + u.Host + u.EscapedPath(), Cookie: &c}
+			changed = true
+			continue
+		default:
+			// Session cookies are not kept between runs
+		}
+		if _, ok := j.stored[key]; ok {
+			delete(j.stored, key)
+			changed = true
+		}
+	}
+	if changed {
+		j.save(now)
+	}
+}
+
+func (j *CookieJar) save(now time.Time) {
+	list := make([]storedCookie, 0, len(j.stored))
+	for key, s := range j.stored {
+		if s.Cookie.Expires.After(now) {
+			list = append(list, s)
+		} else {
+			delete(j.stored, key)
+		}
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return
+	}
+	// Cookies are credentials, keep them private
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, j.path)
+}
+
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := c.Domain
+	if domain == "" {
+		domain = u.Hostname()
+	}
+	return domain + ";" + c.Path + ";" + c.Name
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DecoderServer answers the same ?url= requests as the public decoder with
+// luffy's own extractors, so a team can share one decoder on their network.
+type DecoderServer struct {
+	Client *http.Client
+	// Timeout bounds each extraction; zero means no limit.
+	Timeout time.Duration
+	// CacheTTL is how long a decoded link is served from the cache.
+	// Streams that expire earlier are kept until they expire.
+	CacheTTL time.Duration
+	// CacheSize caps the number of cached links.
+	CacheSize int
+	// Log is called once per request when it is set.
+	Log func(link string, cached bool, d time.Duration, err error)
+
+	mu    sync.Mutex
+	cache map[string]decoderEntry
+}
+
+type decoderEntry struct {
+	resp    DecryptResponse
+	expires time.Time
+}
+
+func (s *DecoderServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		decoderError(w, http.StatusMethodNotAllowed, errors.New("only GET is supported"))
+		return
+	}
+	link := r.URL.Query().Get("url")
+	u, err := url.Parse(link)
+	if link == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		decoderError(w, http.StatusBadRequest, errors.New("url must be an http(s) link"))
+		return
+	}
+
+	start := time.Now()
+	resp, cached, err := s.decode(r.Context(), link)
+	if s.Log != nil {
+		s.Log(link, cached, time.Since(start), err)
+	}
+	switch {
+	case errors.Is(err, ErrNoExtractor):
+		decoderError(w, http.StatusUnprocessableEntity, err)
+	case err != nil:
+		decoderError(w, http.StatusBadGateway, err)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// ErrNoExtractor is returned by the decoder server for hosts it has no extractor for.
+var ErrNoExtractor = errors.New("no extractor for this host")
+
+func (s *DecoderServer) decode(ctx context.Context, link string) (DecryptResponse, bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	if e, ok := s.cache[link]; ok && now.Before(e.expires) {
+		s.mu.Unlock()
+		return e.resp, true, nil
+	}
+	s.mu.Unlock()
+
+	// Only local extractors run here: the remote decoder may well be this server
+	extractor, ok := FindExtractor(link)
+	if !ok {
+		return DecryptResponse{}, false, ErrNoExtractor
+	}
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	stream, err := extractor.Extract(ctx, link, s.Client)
+	if err != nil {
+		return DecryptResponse{}, false, err
+	}
+	resp := decryptResponse(stream)
+
+	expires := now.Add(s.CacheTTL)
+	if !stream.Expires.IsZero() && stream.Expires.Before(expires) {
+		expires = stream.Expires
+	}
+	if s.CacheTTL > 0 && expires.After(now) {
+		s.store(link, decoderEntry{resp: resp, expires: expires}, now)
+	}
+	return resp, false, nil
+}
+
+func (s *DecoderServer) store(link string, e decoderEntry, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cache == nil {
+		s.cache = map[string]decoderEntry{}
+	}
+	if s.CacheSize > 0 && len(s.cache) >= s.CacheSize {
+		// Drop what has expired, then the entry closest to expiring
+		var oldest string
+		for k, v := range s.cache {
+			if !now.Before(v.expires) {
+				delete(s.cache, k)
+			} else if oldest == "" || v.expires.Before(s.cache[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(s.cache) >= s.CacheSize {
+			delete(s.cache, oldest)
+		}
+	}
+	s.cache[link] = e
+}
+
+func decryptResponse(stream *Stream) DecryptResponse {
+	resp := DecryptResponse{
+		Sources: []DecryptedSource{},
+		Tracks:  []DecryptedTrack{},
+		Referer: stream.Referer,
+		Headers: stream.Headers,
+	}
+	for _, v := range stream.Variants {
+		typ := string(ContainerFromURL(v.URL))
+		if typ == "" {
+			typ = string(stream.Container)
+		}
+		resp.Sources = append(resp.Sources, DecryptedSource{File: v.URL, Type: typ, Label: v.Label})
+	}
+	for _, sub := range stream.Subtitles {
+		label := sub.Label
+		if label == "" {
+			label = sub.Language
+		}
+		resp.Tracks = append(resp.Tracks, DecryptedTrack{File: sub.URL, Kind: "captions", Label: label})
+	}
+	return resp
+}
+
+func decoderError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDecoderServer(t *testing.T) {
+	calls := 0
+	RegisterExtractor(&HostExtractor{
+		ExtractorName: "decodertest",
+		HostPatterns:  []string{"decoder.test"},
+		Func: func(ctx context.Context, link string, client *http.Client) (*Stream, error) {
+			calls++
+			return &Stream{
+				Variants:  []StreamQuality{{URL: "https://cdn.decoder.test/master.m3u8", Label: "auto"}},
+				Subtitles: []Subtitle{{URL: "https://cdn.decoder.test/en.vtt", Language: "en", Label: "English"}},
+				Referer:   "https://decoder.test/",
+				Container: ContainerHLS,
+			}, nil
+		},
+	})
+	srv := httptest.NewServer(&DecoderServer{Client: http.DefaultClient, CacheTTL: time.Minute, CacheSize: 10})
+	defer srv.Close()
+
+	get := func(link string) int {
+		resp, err := http.Get(srv.URL + "/?url=" + url.QueryEscape(link))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get("not a link"); code != http.StatusBadRequest {
+		t.Errorf("bad url: status %d", code)
+	}
+	if code := get("https://unknown.example/e/1"); code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown host: status %d", code)
+	}
+
+	// The client side of the contract reads what the server writes
+	ConfigureSites(map[string]Site{"decoder": {BaseURL: srv.URL}})
+	defer ConfigureSites(nil)
+	ConfigureDecoder(true)
+	defer ConfigureDecoder(false)
+
+	for range 2 {
+		stream, err := DecryptStreamWithDecoder(context.Background(), "https://decoder.test/e/1", http.DefaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stream.URL() != "https://cdn.decoder.test/master.m3u8" || stream.Referer != "https://decoder.test/" {
+			t.Errorf("stream = %q, referer %q", stream.URL(), stream.Referer)
+		}
+		if len(stream.Subtitles) != 1 || stream.Subtitles[0].URL != "https://cdn.decoder.test/en.vtt" {
+			t.Errorf("subtitles = %+v", stream.Subtitles)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("extractor ran %d times, want 1 with the cache", calls)
+	}
+}
@@ -1,13 +1,16 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync/atomic"
 )
 
 type DecryptedSource struct {
@@ -25,39 +28,25 @@ type DecryptedTrack struct {
 type DecryptResponse struct {
 	Sources []DecryptedSource `json:"sources"`
 	Tracks  []DecryptedTrack  `json:"tracks"`
+	// Referer and Headers are only sent by luffy's own decoder server.
+	Referer string            `json:"referer,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
-func DecryptStream(embedLink string, client *http.Client) (string, []string, string, error) {
-	if strings.Contains(embedLink, "vidsrc.xyz") ||
-		strings.Contains(embedLink, "vidsrc.me") ||
-		strings.Contains(embedLink, "vidsrc.to") ||
-		strings.Contains(embedLink, "vidsrc.in") ||
-		strings.Contains(embedLink, "vidsrc.pm") ||
-		strings.Contains(embedLink, "vidsrc.net") {
-		return DecryptVidsrc(embedLink, client)
+// DecryptStream extracts the stream behind an embed link with the extractor
+// registered for its host, or the remote decoder when there is none.
+func DecryptStream(ctx context.Context, embedLink string, client *http.Client) (*Stream, error) {
+	if e, ok := FindExtractor(embedLink); ok {
+		return e.Extract(ctx, embedLink, client)
 	}
-
-	if strings.Contains(embedLink, "vidlink.pro") {
-		return DecryptVidlink(embedLink, client)
-	}
-
-	if strings.Contains(embedLink, "embed.su") {
-		return DecryptEmbedSu(embedLink, client)
-	}
-
-	if strings.Contains(embedLink, "multiembed.mov") {
-		return DecryptStreamWithDecoder(embedLink, client)
-	}
-
-	return DecryptStreamWithDecoder(embedLink, client)
+	return DecryptStreamWithDecoder(ctx, embedLink, client)
 }
 
-func DecryptVidsrc(urlStr string, client *http.Client) (string, []string, string, error) {
-	req, _ := http.NewRequest("GET", urlStr, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+func DecryptVidsrc(ctx context.Context, urlStr string, client *http.Client) (*Stream, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", nil, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
@@ -65,17 +54,16 @@ func DecryptVidsrc(urlStr string, client *http.Client) (string, []string, string
 	reCloud := regexp.MustCompile(`src="//cloudnestra\.com/rcp/([^"]+)"`)
 	match := reCloud.FindSubmatch(body)
 	if len(match) < 2 {
-		return "", nil, "", fmt.Errorf("could not find cloudnestra iframe")
+		return nil, fmt.Errorf("could not find cloudnestra iframe")
 	}
 	hash := string(match[1])
 	cloudUrl := "https://cloudnestra.com/rcp/" + hash
 
-	req, _ = http.NewRequest("GET", cloudUrl, nil)
+	req, _ = http.NewRequestWithContext(ctx, "GET", cloudUrl, nil)
 	req.Header.Set("Referer", urlStr)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	resp, err = client.Do(req)
 	if err != nil {
-		return "", nil, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	body, _ = io.ReadAll(resp.Body)
@@ -83,16 +71,15 @@ func DecryptVidsrc(urlStr string, client *http.Client) (string, []string, string
 	rePro := regexp.MustCompile(`src:\s*'/prorcp/([^']+)'`)
 	match = rePro.FindSubmatch(body)
 	if len(match) < 2 {
-		return "", nil, "", fmt.Errorf("could not find prorcp iframe")
+		return nil, fmt.Errorf("could not find prorcp iframe")
 	}
 	proUrl := "https://cloudnestra.com/prorcp/" + string(match[1])
 
-	req, _ = http.NewRequest("GET", proUrl, nil)
+	req, _ = http.NewRequestWithContext(ctx, "GET", proUrl, nil)
 	req.Header.Set("Referer", "https://cloudnestra.com/")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	resp, err = client.Do(req)
 	if err != nil {
-		return "", nil, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	body, _ = io.ReadAll(resp.Body)
@@ -100,7 +87,7 @@ func DecryptVidsrc(urlStr string, client *http.Client) (string, []string, string
 	reFile := regexp.MustCompile(`file:\s*"(https://[^"]+)"`)
 	match = reFile.FindSubmatch(body)
 	if len(match) < 2 {
-		return "", nil, "", fmt.Errorf("could not find m3u8 file")
+		return nil, fmt.Errorf("could not find m3u8 file")
 	}
 	rawM3u8 := string(match[1])
 
@@ -115,75 +102,92 @@ func DecryptVidsrc(urlStr string, client *http.Client) (string, []string, string
 	}
 
 	if !strings.HasSuffix(finalUrl, ".m3u8") {
-		return "", nil, "", fmt.Errorf("extracted url is not m3u8: %s", finalUrl)
+		return nil, fmt.Errorf("extracted url is not m3u8: %s", finalUrl)
 	}
 
-	var subs []string
+	var subs []Subtitle
 	parsedUrl, _ := url.Parse(urlStr)
 	subUrl := fmt.Sprintf("%s://%s/ajax/embed/episode/%s/subtitles", parsedUrl.Scheme, parsedUrl.Host, hash)
-	subReq, _ := http.NewRequest("GET", subUrl, nil)
-	subReq.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	subReq, _ := http.NewRequestWithContext(ctx, "GET", subUrl, nil)
 	subReq.Header.Set("Referer", urlStr)
 	subReq.Header.Set("X-Requested-With", "XMLHttpRequest")
 
-	subResp, err := client.Do(subReq)
-	if err == nil && subResp.StatusCode == 200 {
-		var tracks []DecryptedTrack
-		if err := json.NewDecoder(subResp.Body).Decode(&tracks); err == nil {
-			for _, track := range tracks {
-				label := strings.ToLower(track.Label)
-				if strings.Contains(label, "english") || strings.Contains(label, " eng") || label == "eng" {
-					subs = append(subs, track.File)
-				}
+	if subResp, err := client.Do(subReq); err == nil {
+		defer subResp.Body.Close()
+		if subResp.StatusCode == 200 {
+			var tracks []DecryptedTrack
+			if err := json.NewDecoder(subResp.Body).Decode(&tracks); err == nil {
+				subs = subtitlesFromTracks(tracks)
 			}
 		}
-		subResp.Body.Close()
 	}
 
-	return finalUrl, subs, "https://cloudnestra.com/", nil
+	return &Stream{
+		Variants:  []StreamQuality{{URL: finalUrl}},
+		Referer:   "https://cloudnestra.com/",
+		Subtitles: subs,
+		Container: ContainerHLS,
+	}, nil
 }
 
-func DecryptVidlink(urlStr string, client *http.Client) (string, []string, string, error) {
+func DecryptVidlink(ctx context.Context, urlStr string, client *http.Client) (*Stream, error) {
 	re := regexp.MustCompile(`/(movie|tv)/([^/?#]+)`)
 	matches := re.FindStringSubmatch(urlStr)
 	if len(matches) < 3 {
-		return "", nil, "", fmt.Errorf("could not parse vidlink url")
+		return nil, fmt.Errorf("could not parse vidlink url")
+	}
+
+	stream, err := DecryptStreamWithDecoder(ctx, urlStr, client)
+	if err != nil {
+		return nil, err
 	}
 
 	tmdbID := matches[2]
 	subUrl := fmt.Sprintf("https://vidlink.pro/api/subtitles/%s", tmdbID)
 
-	req, _ := http.NewRequest("GET", subUrl, nil)
-	resp, err := client.Do(req)
-
-	var subs []string
-	if err == nil && resp.StatusCode == 200 {
-		var tracks []struct {
-			URL   string `json:"url"`
-			Label string `json:"label"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&tracks); err == nil {
-			for _, t := range tracks {
-				label := strings.ToLower(t.Label)
-				if strings.Contains(label, "english") || strings.Contains(label, " eng") || label == "eng" {
-					subs = append(subs, t.URL)
+	req, _ := http.NewRequestWithContext(ctx, "GET", subUrl, nil)
+	var subs []Subtitle
+	if resp, err := client.Do(req); err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == 200 {
+			var tracks []struct {
+				URL   string `json:"url"`
+				Label string `json:"label"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&tracks); err == nil {
+				for _, t := range tracks {
+					subs = append(subs, Subtitle{URL: t.URL, Language: SubtitleLanguage(t.Label), Label: t.Label})
 				}
 			}
 		}
-		resp.Body.Close()
 	}
 
-	videoLink, _, referer, err := DecryptStreamWithDecoder(urlStr, client)
-	return videoLink, subs, referer, err
+	stream.Subtitles = subs
+	return stream, nil
 }
 
-func DecryptEmbedSu(urlStr string, client *http.Client) (string, []string, string, error) {
-	videoLink, subs, referer, err := DecryptStreamWithDecoder(urlStr, client)
-	return videoLink, subs, referer, err
+func DecryptEmbedSu(ctx context.Context, urlStr string, client *http.Client) (*Stream, error) {
+	return DecryptStreamWithDecoder(ctx, urlStr, client)
+}
+
+var remoteDecoder atomic.Bool
+
+// ConfigureDecoder turns the remote decoder on or off. It is off by default,
+// as it sees every link luffy plays.
+func ConfigureDecoder(enabled bool) {
+	remoteDecoder.Store(enabled)
 }
 
-func DecryptStreamWithDecoder(embedLink string, client *http.Client) (string, []string, string, error) {
-	req, _ := http.NewRequest("GET", DECODER, nil)
+// ErrDecoderDisabled is returned for links only the remote decoder can resolve
+// while it is turned off.
+var ErrDecoderDisabled = errors.New("no extractor for this host and the remote decoder is off (set remote_decoder: true to use it)")
+
+// DecryptStreamWithDecoder resolves an embed link through the remote decoder.
+func DecryptStreamWithDecoder(ctx context.Context, embedLink string, client *http.Client) (*Stream, error) {
+	if !remoteDecoder.Load() {
+		return nil, ErrDecoderDisabled
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", SiteURL("decoder"), nil)
 	q := req.URL.Query()
 	q.Add("url", embedLink)
 	req.URL.RawQuery = q.Encode()
@@ -192,17 +196,17 @@ func DecryptStreamWithDecoder(embedLink string, client *http.Client) (string, []
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", nil, "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return "", nil, "", fmt.Errorf("decoder returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("decoder returned status %d", resp.StatusCode)
 	}
 
 	var data DecryptResponse
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return "", nil, "", err
+		return nil, err
 	}
 
 	var videoLink string
@@ -214,18 +218,14 @@ func DecryptStreamWithDecoder(embedLink string, client *http.Client) (string, []
 	}
 
 	if videoLink == "" {
-		return "", nil, "", fmt.Errorf("no m3u8 source found")
-	}
-
-	var subs []string
-	for _, track := range data.Tracks {
-		if track.Kind == "captions" || track.Kind == "subtitles" {
-			label := strings.ToLower(track.Label)
-			if strings.Contains(label, "english") || strings.Contains(label, " eng") || label == "eng" {
-				subs = append(subs, track.File)
-			}
-		}
+		return nil, fmt.Errorf("no m3u8 source found")
 	}
 
-	return videoLink, subs, "", nil
+	return &Stream{
+		Variants:  []StreamQuality{{URL: videoLink}},
+		Subtitles: subtitlesFromTracks(data.Tracks),
+		Container: ContainerHLS,
+		Referer:   data.Referer,
+		Headers:   data.Headers,
+	}, nil
 }
@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -11,7 +12,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,6 +37,7 @@ func getDownloadMetadata(url, referer, userAgent string) (*DownloadMetadata, err
 		"--user-agent", userAgent,
 		"--no-warnings",
 	}
+	args = append(args, proxyArgs()...)
 
 	cmd := exec.Command("yt-dlp", args...)
 	var out bytes.Buffer
@@ -72,6 +76,69 @@ func getDownloadMetadata(url, referer, userAgent string) (*DownloadMetadata, err
 	}, nil
 }
 
+// probeDownload reads the duration, resolution and size of an HLS or MP4
+// stream from its playlists or headers, describing the variant pick picks.
+// Sizes of HLS streams are estimated from their bandwidth.
+func probeDownload(ctx context.Context, client *http.Client, stream *Stream, pick func([]StreamQuality) int, title string) (*DownloadMetadata, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	meta := &DownloadMetadata{Title: title, Format: string(stream.Container)}
+
+	if stream.Container == ContainerMP4 {
+		req, err := stream.NewRequest(probeCtx, "HEAD", stream.URL())
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("stream returned status %d", resp.StatusCode)
+		}
+		meta.Filesize = resp.ContentLength
+		meta.Format = cmp.Or(resp.Header.Get("Content-Type"), meta.Format)
+		return meta, nil
+	}
+
+	req, err := stream.NewRequest(probeCtx, "GET", stream.URL())
+	if err != nil {
+		return nil, err
+	}
+	master, media, err := fetchM3U8(req, client)
+	if err != nil {
+		return nil, err
+	}
+	bandwidth := 0
+	if master != nil {
+		if len(master.Variants) == 0 {
+			return nil, fmt.Errorf("no variants in master playlist")
+		}
+		q := pickOrBest(pick, masterQualities(master))
+		bandwidth = q.Bandwidth
+		meta.Resolution = q.Resolution
+		if req, err = stream.NewRequest(probeCtx, "GET", q.URL); err != nil {
+			return nil, err
+		}
+		if _, media, err = fetchM3U8(req, client); err != nil {
+			return nil, err
+		}
+		if media == nil {
+			return nil, fmt.Errorf("variant is not a media playlist")
+		}
+	}
+
+	seconds := media.Duration().Seconds()
+	total := int64(seconds)
+	meta.Duration = fmt.Sprintf("%d:%02d", total/60, total%60)
+	if total/3600 > 0 {
+		meta.Duration = fmt.Sprintf("%d:%02d:%02d", total/3600, (total%3600)/60, total%60)
+	}
+	meta.Filesize = int64(float64(bandwidth) / 8 * seconds)
+	return meta, nil
+}
+
 func getTerminalWidth() int {
 	if runtime.GOOS == "windows" {
 		return 80
@@ -154,7 +221,48 @@ func formatSize(bytes int64) string {
 	}
 }
 
-func Download(basePath, dlPath, name, url, referer, userAgent string, subtitles []string, debug bool) error {
+// Downloaders ConfigureDownloader accepts: "auto" uses yt-dlp when it is
+// installed and the built-in downloader otherwise, "native" always uses the
+// built-in one for HLS and MP4 streams.
+var Downloaders = []string{"auto", "native", "yt-dlp"}
+
+var downloaderMode atomic.Value
+
+// ConfigureDownloader picks what Download saves streams with.
+func ConfigureDownloader(mode string) error {
+	if mode == "" {
+		mode = "auto"
+	}
+	if !slices.Contains(Downloaders, mode) {
+		return fmt.Errorf("unknown downloader %q (use %s)", mode, strings.Join(Downloaders, ", "))
+	}
+	downloaderMode.Store(mode)
+	return nil
+}
+
+// nativeDownload tells whether stream is saved without yt-dlp.
+func nativeDownload(stream *Stream) bool {
+	if stream.Container != ContainerHLS && stream.Container != ContainerMP4 {
+		return false
+	}
+	mode, _ := downloaderMode.Load().(string)
+	switch mode {
+	case "native":
+		return true
+	case "yt-dlp":
+		return false
+	}
+	_, err := exec.LookPath("yt-dlp")
+	return err != nil
+}
+
+// Download saves the stream under dlPath. pick picks the variant of an HLS
+// master playlist, as Validator.Pick does; nil takes the best one.
+func Download(ctx context.Context, basePath, dlPath, name string, stream *Stream, pick func([]StreamQuality) int, debug bool) error {
+	url := stream.URL()
+	referer := stream.Referer
+	userAgent := stream.UserAgent
+
 	if dlPath == "" {
 		dlPath = filepath.Join(basePath, "Downloads", "luffy")
 	} else {
@@ -167,10 +275,29 @@ func Download(basePath, dlPath, name, url, referer, userAgent string, subtitles
 	cleanName := strings.ReplaceAll(name, " ", "-")
 	cleanName = strings.ReplaceAll(cleanName, "\"", "")
 
-	outputTemplate := filepath.Join(dlPath, cleanName+".mp4")
+	outputBase := filepath.Join(dlPath, cleanName)
+	outputTemplate := outputBase + ".mp4"
+	native := nativeDownload(stream)
+	var hlsDownloader *HLSDownloader
+	client := NewClient()
+	if native && stream.Container == ContainerHLS {
+		hlsDownloader = NewHLSDownloader(client, stream)
+		hlsDownloader.Pick = pick
+		if hlsDownloader.FFmpeg == "" {
+			outputTemplate = outputBase + ".ts"
+		}
+	}
 
 	fmt.Println("[download] Fetching metadata...")
-	meta, err := getDownloadMetadata(url, referer, userAgent)
+	var meta *DownloadMetadata
+	var err error
+	switch stream.Container {
+	case ContainerHLS, ContainerMP4:
+		// Probed in-process, so the requests show up in --har captures
+		meta, err = probeDownload(ctx, client, stream, pick, name)
+	default:
+		meta, err = getDownloadMetadata(url, referer, userAgent)
+	}
 	if err != nil {
 		fmt.Printf("[warning] Could not fetch metadata: %v\n", err)
 	} else {
@@ -178,21 +305,70 @@ func Download(basePath, dlPath, name, url, referer, userAgent string, subtitles
 		displayDownloadTable(meta)
 	}
 
+	if debug {
+		fmt.Printf("Downloading to %s...\n", outputTemplate)
+	}
+	switch {
+	case hlsDownloader != nil:
+		hlsDownloader.Progress = func(p HLSProgress) {
+			fmt.Printf("\r[download] %d/%d segments (%d%%), %s", p.Segments, p.Total, p.Segments*100/p.Total, formatSize(p.Bytes))
+		}
+		_, err := hlsDownloader.Download(ctx, outputBase)
+		fmt.Println()
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("download interrupted, run it again to resume: %w", ctx.Err())
+			}
+			return fmt.Errorf("download failed: %w", err)
+		}
+	case native:
+		if err := downloadFile(ctx, client, stream, url, outputTemplate); err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+	default:
+		if err := ytDlpDownload(ctx, stream, outputTemplate); err != nil {
+			return err
+		}
+	}
+
+	perLanguage := map[string]int{}
+	for _, sub := range stream.Subtitles {
+		if sub.URL == "" {
+			continue
+		}
+		perLanguage[sub.Lang()]++
+		subPath := SubtitleFilename(filepath.Join(dlPath, cleanName), sub, perLanguage[sub.Lang()])
+
+		if debug {
+			fmt.Printf("Downloading subtitle to %s...\n", subPath)
+		}
+		if err := downloadFile(ctx, client, stream, sub.URL, subPath); err != nil {
+			if debug {
+				fmt.Printf("Failed to download subtitle: %v\n", err)
+			}
+		}
+	}
+
+	fmt.Println("Download complete!")
+	return nil
+}
+
+func ytDlpDownload(ctx context.Context, stream *Stream, output string) error {
 	args := []string{
-		url,
+		stream.URL(),
 		"--no-skip-unavailable-fragments",
 		"--fragment-retries", "infinite",
 		"-N", "16",
-		"-o", outputTemplate,
-		"--referer", referer,
-		"--user-agent", userAgent,
+		"-o", output,
+		"--referer", stream.Referer,
+		"--user-agent", stream.UserAgent,
 	}
-
-	if debug {
-		fmt.Printf("Downloading to %s...\n", outputTemplate)
+	for k, v := range stream.Headers {
+		args = append(args, "--add-header", k+":"+v)
 	}
+	args = append(args, proxyArgs()...)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
@@ -205,39 +381,26 @@ func Download(basePath, dlPath, name, url, referer, userAgent string, subtitles
 		}
 		return fmt.Errorf("yt-dlp failed: %w", err)
 	}
+	return nil
+}
 
-	if len(subtitles) > 0 {
-		for i, subURL := range subtitles {
-			ext := ".vtt"
-			if strings.HasSuffix(subURL, ".srt") {
-				ext = ".srt"
-			}
-
-			subPath := filepath.Join(dlPath, cleanName)
-			if i > 0 {
-				subPath += fmt.Sprintf(".eng%d%s", i, ext)
-			} else {
-				subPath += ".eng" + ext
-			}
-
-			if debug {
-				fmt.Printf("Downloading subtitle to %s...\n", subPath)
-			}
-			if err := downloadFile(subURL, subPath); err != nil {
-				if debug {
-					fmt.Printf("Failed to download subtitle: %v\n", err)
-				}
-			}
-		}
+// proxyArgs passes the configured proxy on to yt-dlp.
+func proxyArgs() []string {
+	if proxy := ProxyURL(); proxy != "" {
+		return []string{"--proxy", proxy}
 	}
-
-	fmt.Println("Download complete!")
 	return nil
 }
 
-func downloadFile(url, filepath string) error {
-	client := NewClient()
-	resp, err := client.Get(url)
+// downloadFile saves a link of the stream, with the referer, user agent and
+// headers its host expects, to name. The data goes to name.part first, so an
+// interrupted download never looks complete.
+func downloadFile(ctx context.Context, client *http.Client, stream *Stream, link, name string) error {
+	req, err := stream.NewRequest(ctx, "GET", link)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -247,12 +410,18 @@ func downloadFile(url, filepath string) error {
 		return fmt.Errorf("bad status: %s", resp.Status)
 	}
 
-	out, err := os.Create(filepath)
+	part := name + ".part"
+	out, err := os.Create(part)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
 	_, err = io.Copy(out, resp.Body)
-	return err
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Rename(part, name)
 }
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Extractor turns the embed page of a video host into a playable stream,
+// with its subtitles and the headers the host wants.
+type Extractor interface {
+	Name() string
+	// Hosts lists the hosts the extractor handles. A pattern matches the host
+	// and its subdomains; "vidsrc.*" matches vidsrc under any top-level domain.
+	Hosts() []string
+	Extract(ctx context.Context, link string, client *http.Client) (*Stream, error)
+}
+
+// HostExtractor is an Extractor made of a name, host patterns and a function.
+type HostExtractor struct {
+	ExtractorName string
+	HostPatterns  []string
+	Func          func(ctx context.Context, link string, client *http.Client) (*Stream, error)
+}
+
+func (e *HostExtractor) Name() string    { return e.ExtractorName }
+func (e *HostExtractor) Hosts() []string { return e.HostPatterns }
+func (e *HostExtractor) Extract(ctx context.Context, link string, client *http.Client) (*Stream, error) {
+	return e.Func(ctx, link, client)
+}
+
+func init() {
+	RegisterExtractor(&HostExtractor{
+		ExtractorName: "vidsrc",
+		HostPatterns:  []string{"vidsrc.xyz", "vidsrc.me", "vidsrc.to", "vidsrc.in", "vidsrc.pm", "vidsrc.net"},
+		Func:          DecryptVidsrc,
+	})
+	RegisterExtractor(&HostExtractor{
+		ExtractorName: "vidlink",
+		HostPatterns:  []string{"vidlink.pro"},
+		Func:          DecryptVidlink,
+	})
+	RegisterExtractor(&HostExtractor{
+		ExtractorName: "embedsu",
+		HostPatterns:  []string{"embed.su"},
+		Func:          DecryptEmbedSu,
+	})
+	RegisterExtractor(&HostExtractor{
+		ExtractorName: "multiembed",
+		HostPatterns:  []string{"multiembed.mov"},
+		Func:          DecryptStreamWithDecoder,
+	})
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   []Extractor
+)
+
+// RegisterExtractor adds an extractor. Extractors registered later are tried
+// first, so providers and plugins can take over hosts from the built-in ones;
+// registering a name again replaces the earlier extractor.
+func RegisterExtractor(e Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	for i, old := range extractors {
+		if strings.EqualFold(old.Name(), e.Name()) {
+			extractors = append(extractors[:i], extractors[i+1:]...)
+			break
+		}
+	}
+	extractors = append(extractors, e)
+}
+
+// LookupExtractor finds a registered extractor by name, ignoring case.
+func LookupExtractor(name string) (Extractor, bool) {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	for _, e := range extractors {
+		if strings.EqualFold(e.Name(), name) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// FindExtractor returns the extractor that handles the host of link.
+func FindExtractor(link string) (Extractor, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	for i := len(extractors) - 1; i >= 0; i-- {
+		for _, pattern := range extractors[i].Hosts() {
+			if MatchHost(pattern, host) {
+				return extractors[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// MatchHost reports whether host is pattern or one of its subdomains. A
+// trailing ".*" in pattern stands for any top-level domain.
+func MatchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if name, ok := strings.CutSuffix(pattern, ".*"); ok {
+		i := strings.LastIndex(host, ".")
+		if i <= 0 {
+			return false
+		}
+		pattern, host = name, host[:i]
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
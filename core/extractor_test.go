@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestMatchHost(t *testing.T) {
+	cases := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"vidsrc.xyz", "vidsrc.xyz", true},
+		{"vidsrc.xyz", "www.vidsrc.xyz", true},
+		{"vidsrc.xyz", "notvidsrc.xyz", false},
+		{"vidsrc.*", "vidsrc.net", true},
+		{"vidsrc.*", "player.vidsrc.to", true},
+		{"vidsrc.*", "vidsrc", false},
+		{"embed.su", "embed.su.evil.com", false},
+	}
+	for _, c := range cases {
+		if got := MatchHost(c.pattern, c.host); got != c.want {
+			t.Errorf("MatchHost(%q, %q) = %v, want %v", c.pattern, c.host, got, c.want)
+		}
+	}
+}
+
+func TestFindExtractor(t *testing.T) {
+	if e, ok := FindExtractor("https://vidsrc.me/embed/movie?tmdb=1"); !ok || e.Name() != "vidsrc" {
+		t.Errorf("vidsrc.me: got %v, %v", e, ok)
+	}
+	if _, ok := FindExtractor("https://unknown.example/e/1"); ok {
+		t.Error("unknown host matched an extractor")
+	}
+
+	// A later registration takes the host over from the built-in extractor
+	RegisterExtractor(&HostExtractor{
+		ExtractorName: "custom-vidlink",
+		HostPatterns:  []string{"vidlink.*"},
+		Func: func(ctx context.Context, link string, client *http.Client) (*Stream, error) {
+			return DirectStream(link + "/index.m3u8"), nil
+		},
+	})
+	stream, err := DecryptStream(context.Background(), "https://vidlink.pro/movie/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.URL() != "https://vidlink.pro/movie/1/index.m3u8" {
+		t.Errorf("stream = %q", stream.URL())
+	}
+}
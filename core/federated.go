@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Source is one provider's hit for a merged search result.
+type Source struct {
+	Provider ProviderInfo
+	Result   SearchResult
+}
+
+// MergedResult groups the hits of several providers that refer to the same title.
+type MergedResult struct {
+	Title   string
+	Year    string
+	Type    MediaType
+	TMDB    string
+	Sources []Source
+}
+
+func (m *MergedResult) ProviderNames() []string {
+	names := make([]string, 0, len(m.Sources))
+	for _, s := range m.Sources {
+		names = append(names, s.Provider.Name)
+	}
+	return names
+}
+
+// SearchError records a provider that failed during a federated search.
+type SearchError struct {
+	Provider string
+	Err      error
+}
+
+func (e *SearchError) Error() string {
+	return e.Provider + ": " + e.Err.Error()
+}
+
+func (e *SearchError) Unwrap() error {
+	return e.Err
+}
+
+// FederatedSearch queries all given providers concurrently and merges their results.
+// Results are interleaved by rank so that every provider's best hits come first.
+func FederatedSearch(ctx context.Context, client *http.Client, infos []ProviderInfo, query string) ([]MergedResult, []*SearchError) {
+	perProvider := make([][]SearchResult, len(infos))
+	errs := make([]*SearchError, len(infos))
+
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		wg.Add(1)
+		go func(i int, info ProviderInfo) {
+			defer wg.Done()
+			results, err := info.New(client).Search(ctx, query)
+			if err != nil {
+				errs[i] = &SearchError{Provider: info.Name, Err: err}
+				return
+			}
+			perProvider[i] = results
+		}(i, info)
+	}
+	wg.Wait()
+
+	var failed []*SearchError
+	for _, e := range errs {
+		if e != nil {
+			failed = append(failed, e)
+		}
+	}
+
+	var merged []MergedResult
+	for rank := 0; ; rank++ {
+		more := false
+		for i, results := range perProvider {
+			if rank >= len(results) {
+				continue
+			}
+			more = true
+			merged = mergeResult(merged, Source{Provider: infos[i], Result: results[rank]})
+		}
+		if !more {
+			break
+		}
+	}
+	return merged, failed
+}
+
+func mergeResult(merged []MergedResult, src Source) []MergedResult {
+	r := src.Result
+	title := normalizeTitle(r.Title)
+	year := normalizeYear(r.Year)
+
+	for i := range merged {
+		m := &merged[i]
+		if m.Type != r.Type || normalizeTitle(m.Title) != title {
+			continue
+		}
+		if m.TMDB != "" && r.TMDB != "" && m.TMDB != r.TMDB {
+			continue
+		}
+		if m.Year != "" && year != "" && m.Year != year {
+			continue
+		}
+		if hasProvider(m, src.Provider.Name) {
+			continue
+		}
+		if m.Year == "" {
+			m.Year = year
+		}
+		if m.TMDB == "" {
+			m.TMDB = r.TMDB
+		}
+		m.Sources = append(m.Sources, src)
+		return merged
+	}
+
+	return append(merged, MergedResult{
+		Title:   r.Title,
+		Year:    year,
+		Type:    r.Type,
+		TMDB:    r.TMDB,
+		Sources: []Source{src},
+	})
+}
+
+func hasProvider(m *MergedResult, name string) bool {
+	for _, s := range m.Sources {
+		if s.Provider.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var yearRe = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+
+func normalizeYear(year string) string {
+	return yearRe.FindString(year)
+}
+
+// normalizeTitle lowercases a title and drops punctuation and a leading article,
+// so "The Office" and "office" compare equal.
+func normalizeTitle(title string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case r == '&':
+			space = true
+			if b.Len() > 0 {
+				b.WriteString(" and")
+			}
+		default:
+			space = true
+		}
+	}
+	return strings.TrimPrefix(b.String(), "the ")
+}
@@ -0,0 +1,39 @@
+package core
+
+import "testing"
+
+func TestMergeResult(t *testing.T) {
+	src := func(provider, title, year string, typ MediaType, tmdb string) Source {
+		return Source{Provider: ProviderInfo{Name: provider}, Result: SearchResult{Title: title, Year: year, Type: typ, TMDB: tmdb}}
+	}
+	for _, tc := range []struct {
+		name   string
+		a, b   Source
+		merged bool
+	}{
+		{"same title", src("flixhq", "Dark", "2017", Series, ""), src("sflix", "Dark", "2017", Series, ""), true},
+		{"leading article and case", src("flixhq", "The Office", "2005", Series, ""), src("sflix", "office", "2005", Series, ""), true},
+		{"ampersand", src("flixhq", "Love & Death", "2023", Series, ""), src("sflix", "Love and Death", "2023", Series, ""), true},
+		{"punctuation", src("flixhq", "Spider-Man: No Way Home", "2021", Movie, ""), src("sflix", "Spider Man No Way Home", "2021", Movie, ""), true},
+		{"year with extra text", src("flixhq", "Dune", "2021", Movie, ""), src("sflix", "Dune", "Released: 2021-10-22", Movie, ""), true},
+		{"one year missing", src("flixhq", "Dune", "", Movie, ""), src("sflix", "Dune", "2021", Movie, ""), true},
+		{"different year", src("flixhq", "Dune", "1984", Movie, ""), src("sflix", "Dune", "2021", Movie, ""), false},
+		{"different type", src("flixhq", "Fargo", "1996", Movie, ""), src("sflix", "Fargo", "1996", Series, ""), false},
+		{"different title", src("flixhq", "Dark", "2017", Series, ""), src("sflix", "Dark Matter", "2017", Series, ""), false},
+		{"same TMDB id", src("brocoflix", "Dark", "2017", Series, "70523"), src("xprime", "Dark", "2017", Series, "70523"), true},
+		{"one TMDB id missing", src("flixhq", "Dark", "2017", Series, ""), src("xprime", "Dark", "2017", Series, "70523"), true},
+		{"different TMDB ids", src("brocoflix", "Dark", "", Series, "70523"), src("xprime", "Dark", "", Series, "99999"), false},
+		{"same provider twice", src("flixhq", "Dune", "2021", Movie, ""), src("flixhq", "Dune", "2021", Movie, ""), false},
+	} {
+		merged := mergeResult(mergeResult(nil, tc.a), tc.b)
+		if got := len(merged) == 1; got != tc.merged {
+			t.Errorf("%s: merged = %v, want %v", tc.name, got, tc.merged)
+		}
+	}
+
+	// Merging fills in the year and TMDB id the first hit was missing
+	merged := mergeResult(mergeResult(nil, src("flixhq", "Dune", "", Movie, "")), src("xprime", "Dune", "2021", Movie, "438631"))
+	if m := merged[0]; m.Year != "2021" || m.TMDB != "438631" || len(m.Sources) != 2 || m.Title != "Dune" {
+		t.Errorf("merged = %+v", m)
+	}
+}
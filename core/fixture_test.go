@@ -0,0 +1,67 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new?x=1", http.StatusFound)
+		case "/new":
+			io.WriteString(w, "new:"+r.URL.Query().Get("x"))
+		case "/post":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"echo":"`+string(body)+`"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	recorder := &Recorder{Transport: live.Client().Transport}
+	client := &http.Client{Transport: recorder}
+	get(t, client, "GET", live.URL+"/old", "")
+	get(t, client, "POST", live.URL+"/post", "a=1")
+	live.Close()
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := (&Fixture{Provider: "test", Exchanges: recorder.Exchanges()}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixture.Exchanges) != 3 {
+		t.Fatalf("recorded %d exchanges, want 3", len(fixture.Exchanges))
+	}
+
+	if ex := fixture.Exchanges[0]; ex.Status != http.StatusFound || ex.Location != "/new?x=1" {
+		t.Errorf("redirect recorded as %d to %q", ex.Status, ex.Location)
+	}
+	if ex := fixture.Exchanges[2]; ex.Method != "POST" || ex.RequestBody != "a=1" || ex.ContentType != "application/json" || ex.Body != `{"echo":"a=1"}` {
+		t.Errorf("post recorded as %+v", ex)
+	}
+}
+
+func get(t *testing.T, client *http.Client, method, url, body string) string {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return string(data)
+}
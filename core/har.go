@@ -0,0 +1,418 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HAR_BODY_LIMIT is how much of each request and response body a HAR keeps.
+const HAR_BODY_LIMIT = 64 << 10
+
+const HAR_REDACTED = "[redacted]"
+
+// Headers and query or form fields whose values never go into a HAR.
+var (
+	harSecretHeaders = []string{"authorization", "proxy-authorization", "cookie", "set-cookie", "x-api-key", "x-auth-token"}
+	harSecretParams  = []string{"api_key", "apikey", "key", "token", "access_token", "auth", "password", "secret", "session"}
+)
+
+// HARRecorder collects every request luffy makes, to be written out as an
+// HTTP Archive for bug reports.
+type HARRecorder struct {
+	mu      sync.Mutex
+	entries []*harEntry
+}
+
+var harRecorder atomic.Pointer[HARRecorder]
+
+// StartHAR records all traffic of the shared HTTP client from now on.
+func StartHAR() *HARRecorder {
+	r := &HARRecorder{}
+	harRecorder.Store(r)
+	return r
+}
+
+// StopHAR stops recording and returns what was recorded, or nil when no
+// recording was running.
+func StopHAR() *HARRecorder {
+	return harRecorder.Swap(nil)
+}
+
+// WriteFile writes the recorded traffic as a HAR 1.2 file.
+func (r *HARRecorder) WriteFile(path string) error {
+	r.mu.Lock()
+	entries := make([]harEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, *e)
+	}
+	r.mu.Unlock()
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartedDateTime.Before(entries[j].StartedDateTime) })
+
+	har := map[string]any{"log": map[string]any{
+		"version": "1.2",
+		"creator": map[string]string{"name": "luffy", "version": Version},
+		"pages":   []any{},
+		"entries": entries,
+	}}
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (r *HARRecorder) add(e *harEntry) {
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Timings are in milliseconds, -1 when they do not apply.
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harTransport records requests while a HAR recording is running. It sits
+// below the mirror and retry layers, so every attempt shows up.
+type harTransport struct {
+	base http.RoundTripper
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := harRecorder.Load()
+	if rec == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	e := &harEntry{StartedDateTime: time.Now(), Request: harRequestOf(req), Timings: harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(io.LimitReader(body, HAR_BODY_LIMIT+1))
+			body.Close()
+			e.Request.PostData = harPostDataOf(req.Header.Get("Content-Type"), data)
+			e.Request.BodySize = req.ContentLength
+		}
+	}
+
+	var dnsStart, connStart, tlsStart, gotConn, wrote, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { e.Timings.DNS = ms(time.Since(dnsStart)) },
+		ConnectStart:      func(string, string) { connStart = time.Now() },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { e.Timings.SSL = ms(time.Since(tlsStart)) },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				e.Timings.Connect = ms(time.Since(connStart))
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			gotConn = time.Now()
+			if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+				e.ServerIPAddress = host
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := t.base.RoundTrip(req)
+	if wrote.IsZero() {
+		wrote = time.Now()
+	}
+	if !gotConn.IsZero() {
+		e.Timings.Send = ms(wrote.Sub(gotConn))
+	}
+	if err != nil {
+		e.Time = ms(time.Since(e.StartedDateTime))
+		e.Timings.Wait = ms(time.Since(wrote))
+		e.Response = harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1}
+		e.Comment = err.Error()
+		rec.add(e)
+		return nil, err
+	}
+	if firstByte.IsZero() {
+		firstByte = time.Now()
+	}
+	e.Timings.Wait = ms(firstByte.Sub(wrote))
+	e.Time = ms(firstByte.Sub(e.StartedDateTime))
+	e.Response = harResponseOf(resp)
+	rec.add(e)
+
+	// The entry is completed once the body has been read
+	resp.Body = &harBody{ReadCloser: resp.Body, rec: rec, entry: e, firstByte: firstByte}
+	return resp, nil
+}
+
+type harBody struct {
+	io.ReadCloser
+	rec       *HARRecorder
+	entry     *harEntry
+	firstByte time.Time
+	buf       bytes.Buffer
+	size      int64
+	once      sync.Once
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if room := HAR_BODY_LIMIT - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(n, room)])
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *harBody) finish() {
+	b.once.Do(func() {
+		b.rec.mu.Lock()
+		defer b.rec.mu.Unlock()
+		e := b.entry
+		e.Timings.Receive = ms(time.Since(b.firstByte))
+		e.Time = ms(time.Since(e.StartedDateTime))
+		e.Response.BodySize = b.size
+		e.Response.Content.Size = b.size
+		if harTextual(e.Response.Content.MimeType) {
+			e.Response.Content.Text = redactSecrets(b.buf.String())
+		} else if b.size > 0 {
+			e.Response.Content.Comment = "binary body not recorded"
+		}
+		if b.size > HAR_BODY_LIMIT && e.Response.Content.Text != "" {
+			e.Response.Content.Comment = "truncated to 64KiB"
+		}
+	})
+}
+
+func harRequestOf(req *http.Request) harRequest {
+	u := *req.URL
+	q := u.Query()
+	var query []harNameValue
+	for _, name := range sortedKeys(q) {
+		for _, v := range q[name] {
+			query = append(query, harNameValue{name, redactParam(name, v)})
+		}
+	}
+	if len(q) > 0 {
+		u.RawQuery = redactQuery(q).Encode()
+	}
+	if query == nil {
+		query = []harNameValue{}
+	}
+	headers := harHeaders(req.Header)
+	if req.Host != "" && req.Host != u.Host {
+		headers = append(headers, harNameValue{"Host", req.Host})
+	}
+	return harRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: cmp.Or(req.Proto, "HTTP/1.1"),
+		Cookies:     []harNameValue{},
+		Headers:     headers,
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+}
+
+func harResponseOf(resp *http.Response) harResponse {
+	mimeType := resp.Header.Get("Content-Type")
+	return harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{MimeType: mimeType},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+}
+
+func harPostDataOf(contentType string, data []byte) *harPostData {
+	pd := &harPostData{MimeType: contentType}
+	if len(data) > HAR_BODY_LIMIT {
+		data = data[:HAR_BODY_LIMIT]
+		pd.Comment = "truncated to 64KiB"
+	}
+	if mt, _, _ := mime.ParseMediaType(contentType); mt == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(string(data)); err == nil {
+			pd.Text = redactQuery(form).Encode()
+			return pd
+		}
+	}
+	if harTextual(contentType) || contentType == "" {
+		pd.Text = redactSecrets(string(data))
+	} else {
+		pd.Comment = "binary body not recorded"
+	}
+	return pd
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	list := []harNameValue{}
+	for _, name := range sortedKeys(h) {
+		for _, v := range h[name] {
+			for _, secret := range harSecretHeaders {
+				if strings.EqualFold(name, secret) {
+					v = HAR_REDACTED
+				}
+			}
+			list = append(list, harNameValue{name, v})
+		}
+	}
+	return list
+}
+
+func redactParam(name, value string) string {
+	for _, secret := range harSecretParams {
+		if strings.EqualFold(name, secret) {
+			return HAR_REDACTED
+		}
+	}
+	return value
+}
+
+func redactQuery(q url.Values) url.Values {
+	out := url.Values{}
+	for name, values := range q {
+		for _, v := range values {
+			out.Add(name, redactParam(name, v))
+		}
+	}
+	return out
+}
+
+// redactSecrets blanks api keys that show up inside bodies, such as links
+// in a JSON response that carry an api_key parameter.
+func redactSecrets(text string) string {
+	for _, name := range harSecretParams {
+		for _, sep := range []string{"?", "&"} {
+			prefix := sep + name + "="
+			for i := 0; ; {
+				j := strings.Index(text[i:], prefix)
+				if j < 0 {
+					break
+				}
+				start := i + j + len(prefix)
+				end := start
+				for end < len(text) && !strings.ContainsRune("&\"' \n<>#", rune(text[end])) {
+					end++
+				}
+				text = text[:start] + HAR_REDACTED + text[end:]
+				i = start + len(HAR_REDACTED)
+			}
+		}
+	}
+	return text
+}
+
+func harTextual(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.Contains(mt, "json"),
+		strings.Contains(mt, "xml"),
+		strings.Contains(mt, "javascript"),
+		strings.Contains(mt, "mpegurl"),
+		mt == "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
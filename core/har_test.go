@@ -0,0 +1,88 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHARCapture(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cret"})
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"poster": "https://img.example/p.jpg?api_key=abc123&w=500"}`)
+		case "/segment.ts":
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write(make([]byte, 1024))
+		}
+	}))
+	defer srv.Close()
+
+	if err := ConfigureHTTP(HTTPConfig{CookieFile: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	rec := StartHAR()
+	client := NewClient()
+	for _, path := range []string{"/search?query=dune&api_key=abc123", "/segment.ts", "/search?query=dune&api_key=abc123"} {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer abc123")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if StopHAR() != rec {
+		t.Fatal("StopHAR returned another recording")
+	}
+
+	path := filepath.Join(t.TempDir(), "luffy.har")
+	if err := rec.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "abc123") || strings.Contains(string(data), "s3cret") {
+		t.Errorf("secrets leaked into the HAR:\n%s", data)
+	}
+
+	var har struct {
+		Log struct {
+			Version string
+			Entries []harEntry
+		}
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 3 {
+		t.Fatalf("version %q with %d entries", har.Log.Version, len(har.Log.Entries))
+	}
+	search, segment := har.Log.Entries[0], har.Log.Entries[1]
+	if search.Response.Status != 200 || !strings.Contains(search.Response.Content.Text, "w=500") {
+		t.Errorf("search response = %+v", search.Response)
+	}
+	if len(search.Request.QueryString) != 2 || search.Request.QueryString[0].Value != HAR_REDACTED {
+		t.Errorf("query string = %+v", search.Request.QueryString)
+	}
+	if segment.Response.Content.Text != "" || segment.Response.Content.Size != 1024 {
+		t.Errorf("segment content = %+v", segment.Response.Content)
+	}
+
+	// Once stopped nothing is recorded
+	resp, err := client.Get(srv.URL + "/segment.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(rec.entries) != 3 {
+		t.Errorf("%d entries after StopHAR", len(rec.entries))
+	}
+}
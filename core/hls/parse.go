@@ -0,0 +1,380 @@
+package hls
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotPlaylist is returned for input that does not start with #EXTM3U.
+var ErrNotPlaylist = errors.New("not an m3u8 playlist")
+
+// Attributes is a parsed attribute list, keyed by attribute name. Quoted
+// strings are stored without their quotes.
+type Attributes map[string]string
+
+// ParseAttributes reads an attribute list such as
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720.
+func ParseAttributes(s string) (Attributes, error) {
+	attrs := Attributes{}
+	for i := 0; i < len(s); {
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return attrs, fmt.Errorf("attribute %q has no value", s[i:])
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+
+		var value string
+		if i < len(s) && s[i] == '"' {
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return attrs, fmt.Errorf("attribute %s: unterminated quoted string", name)
+			}
+			value = s[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			end := strings.IndexByte(s[i:], ',')
+			if end < 0 {
+				end = len(s) - i
+			}
+			value = strings.TrimSpace(s[i : i+end])
+			i += end
+		}
+		if name != "" {
+			attrs[name] = value
+		}
+
+		// Skip the comma, and anything stray before it
+		if end := strings.IndexByte(s[i:], ','); end >= 0 {
+			i += end + 1
+		} else {
+			break
+		}
+	}
+	return attrs, nil
+}
+
+func (a Attributes) Int(name string) int {
+	n, _ := strconv.Atoi(a[name])
+	return n
+}
+
+func (a Attributes) Float(name string) float64 {
+	f, _ := strconv.ParseFloat(a[name], 64)
+	return f
+}
+
+// Bool reads an enumerated YES/NO attribute.
+func (a Attributes) Bool(name string) bool {
+	return strings.EqualFold(a[name], "YES")
+}
+
+// List splits a comma-separated quoted string such as CODECS.
+func (a Attributes) List(name string) []string {
+	var list []string
+	for _, v := range strings.Split(a[name], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Resolution reads a decimal-resolution such as 1920x1080.
+func (a Attributes) Resolution(name string) (width, height int) {
+	w, h, ok := strings.Cut(strings.ToLower(a[name]), "x")
+	if !ok {
+		return 0, 0
+	}
+	width, _ = strconv.Atoi(w)
+	height, _ = strconv.Atoi(h)
+	return width, height
+}
+
+// Parse reads a playlist, resolving its URIs against base (which may be "").
+// Exactly one of master and media is returned: a playlist with variants or
+// renditions is a master playlist, anything else a media playlist.
+func Parse(r io.Reader, base string) (master *Master, media *Media, err error) {
+	p := &parser{master: &Master{}, media: &Media{}}
+	if base != "" {
+		if p.base, err = url.Parse(base); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	first := true
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			if line == "" {
+				continue
+			}
+			if !strings.HasPrefix(line, "#EXTM3U") {
+				return nil, nil, ErrNotPlaylist
+			}
+			first = false
+			continue
+		}
+		if err := p.line(line); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if first {
+		return nil, nil, ErrNotPlaylist
+	}
+	if p.variant != nil {
+		return nil, nil, fmt.Errorf("EXT-X-STREAM-INF without a URI")
+	}
+
+	if len(p.master.Variants) > 0 || len(p.master.IFrames) > 0 || len(p.master.Renditions) > 0 {
+		p.master.Version = p.version
+		p.master.IndependentSegments = p.independent
+		return p.master, nil, nil
+	}
+	p.media.Version = p.version
+	p.media.IndependentSegments = p.independent
+	return nil, p.media, nil
+}
+
+type parser struct {
+	base        *url.URL
+	master      *Master
+	media       *Media
+	version     int
+	independent bool
+
+	// Waiting for its URI line
+	variant *Variant
+	segment *Segment
+	// Carried over to the following segments
+	key      *Key
+	initMap  *Map
+	sequence int
+	// Where the next byte range without an offset starts
+	lastRangeURI string
+	lastRangeEnd int64
+}
+
+func (p *parser) line(line string) error {
+	if line == "" {
+		return nil
+	}
+	if !strings.HasPrefix(line, "#") {
+		return p.uri(line)
+	}
+	if !strings.HasPrefix(line, "#EXT") {
+		// A comment
+		return nil
+	}
+	tag, value, _ := strings.Cut(line, ":")
+
+	switch tag {
+	case "#EXT-X-VERSION":
+		p.version, _ = strconv.Atoi(value)
+	case "#EXT-X-INDEPENDENT-SEGMENTS":
+		p.independent = true
+
+	case "#EXT-X-STREAM-INF", "#EXT-X-I-FRAME-STREAM-INF":
+		attrs, err := ParseAttributes(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", tag[1:], err)
+		}
+		v := newVariant(attrs)
+		if tag == "#EXT-X-STREAM-INF" {
+			p.variant = v
+			return nil
+		}
+		v.IFrame = true
+		v.URI = p.resolve(attrs["URI"])
+		p.master.IFrames = append(p.master.IFrames, v)
+	case "#EXT-X-MEDIA":
+		attrs, err := ParseAttributes(value)
+		if err != nil {
+			return fmt.Errorf("EXT-X-MEDIA: %w", err)
+		}
+		p.master.Renditions = append(p.master.Renditions, &Rendition{
+			Type:            attrs["TYPE"],
+			GroupID:         attrs["GROUP-ID"],
+			Name:            attrs["NAME"],
+			Language:        attrs["LANGUAGE"],
+			AssocLanguage:   attrs["ASSOC-LANGUAGE"],
+			URI:             p.resolve(attrs["URI"]),
+			Default:         attrs.Bool("DEFAULT"),
+			Autoselect:      attrs.Bool("AUTOSELECT"),
+			Forced:          attrs.Bool("FORCED"),
+			InstreamID:      attrs["INSTREAM-ID"],
+			Characteristics: attrs.List("CHARACTERISTICS"),
+			Channels:        attrs["CHANNELS"],
+			Attributes:      attrs,
+		})
+
+	case "#EXT-X-TARGETDURATION":
+		secs, _ := strconv.Atoi(value)
+		p.media.TargetDuration = time.Duration(secs) * time.Second
+	case "#EXT-X-MEDIA-SEQUENCE":
+		p.media.MediaSequence, _ = strconv.Atoi(value)
+		p.sequence = p.media.MediaSequence
+	case "#EXT-X-DISCONTINUITY-SEQUENCE":
+		p.media.DiscontinuitySequence, _ = strconv.Atoi(value)
+	case "#EXT-X-PLAYLIST-TYPE":
+		p.media.PlaylistType = value
+	case "#EXT-X-ENDLIST":
+		p.media.EndList = true
+	case "#EXT-X-I-FRAMES-ONLY":
+		p.media.IFramesOnly = true
+
+	case "#EXTINF":
+		duration, title, _ := strings.Cut(value, ",")
+		secs, err := strconv.ParseFloat(strings.TrimSpace(duration), 64)
+		if err != nil {
+			return fmt.Errorf("EXTINF: bad duration %q", duration)
+		}
+		p.pending().Duration = time.Duration(math.Round(secs * float64(time.Second)))
+		p.pending().Title = title
+	case "#EXT-X-BYTERANGE":
+		br, err := byteRange(value)
+		if err != nil {
+			return err
+		}
+		p.pending().ByteRange = br
+	case "#EXT-X-DISCONTINUITY":
+		p.pending().Discontinuity = true
+	case "#EXT-X-GAP":
+		p.pending().Gap = true
+	case "#EXT-X-PROGRAM-DATE-TIME":
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			p.pending().ProgramDateTime = t
+		}
+	case "#EXT-X-KEY":
+		attrs, err := ParseAttributes(value)
+		if err != nil {
+			return fmt.Errorf("EXT-X-KEY: %w", err)
+		}
+		if attrs["METHOD"] == "NONE" {
+			p.key = nil
+			return nil
+		}
+		p.key = &Key{
+			Method:            attrs["METHOD"],
+			URI:               p.resolve(attrs["URI"]),
+			IV:                attrs["IV"],
+			KeyFormat:         attrs["KEYFORMAT"],
+			KeyFormatVersions: attrs["KEYFORMATVERSIONS"],
+		}
+	case "#EXT-X-MAP":
+		attrs, err := ParseAttributes(value)
+		if err != nil {
+			return fmt.Errorf("EXT-X-MAP: %w", err)
+		}
+		m := &Map{URI: p.resolve(attrs["URI"])}
+		if v, ok := attrs["BYTERANGE"]; ok {
+			// The offset of a map byte range is never implied
+			if !strings.Contains(v, "@") {
+				v += "@0"
+			}
+			if m.ByteRange, err = byteRange(v); err != nil {
+				return err
+			}
+		}
+		p.initMap = m
+	}
+	return nil
+}
+
+func newVariant(attrs Attributes) *Variant {
+	v := &Variant{
+		Bandwidth:        attrs.Int("BANDWIDTH"),
+		AverageBandwidth: attrs.Int("AVERAGE-BANDWIDTH"),
+		Codecs:           attrs.List("CODECS"),
+		FrameRate:        attrs.Float("FRAME-RATE"),
+		VideoRange:       attrs["VIDEO-RANGE"],
+		HDCPLevel:        attrs["HDCP-LEVEL"],
+		Audio:            attrs["AUDIO"],
+		Video:            attrs["VIDEO"],
+		Subtitles:        attrs["SUBTITLES"],
+		Attributes:       attrs,
+	}
+	v.Width, v.Height = attrs.Resolution("RESOLUTION")
+	if cc := attrs["CLOSED-CAPTIONS"]; cc != "NONE" {
+		v.ClosedCaptions = cc
+	}
+	return v
+}
+
+// pending returns the segment the tags before the next URI line apply to.
+func (p *parser) pending() *Segment {
+	if p.segment == nil {
+		p.segment = &Segment{}
+	}
+	return p.segment
+}
+
+func (p *parser) uri(line string) error {
+	if p.variant != nil {
+		p.variant.URI = p.resolve(line)
+		p.master.Variants = append(p.master.Variants, p.variant)
+		p.variant = nil
+		return nil
+	}
+
+	s := p.pending()
+	p.segment = nil
+	s.URI = p.resolve(line)
+	s.Sequence = p.sequence
+	s.Key = p.key
+	s.Map = p.initMap
+	p.sequence++
+	if s.ByteRange != nil {
+		if s.ByteRange.Offset < 0 {
+			// No offset: the range follows the previous one in the same resource
+			if p.lastRangeURI != s.URI {
+				return fmt.Errorf("EXT-X-BYTERANGE without an offset for %s", line)
+			}
+			s.ByteRange.Offset = p.lastRangeEnd
+		}
+		p.lastRangeURI = s.URI
+		p.lastRangeEnd = s.ByteRange.Offset + s.ByteRange.Length
+	}
+	p.media.Segments = append(p.media.Segments, s)
+	return nil
+}
+
+// byteRange reads "length[@offset]"; a missing offset is left at -1.
+func byteRange(value string) (*ByteRange, error) {
+	length, offset, hasOffset := strings.Cut(value, "@")
+	br := &ByteRange{Offset: -1}
+	var err error
+	if br.Length, err = strconv.ParseInt(strings.TrimSpace(length), 10, 64); err != nil {
+		return nil, fmt.Errorf("bad byte range %q", value)
+	}
+	if hasOffset {
+		if br.Offset, err = strconv.ParseInt(strings.TrimSpace(offset), 10, 64); err != nil {
+			return nil, fmt.Errorf("bad byte range %q", value)
+		}
+	}
+	return br, nil
+}
+
+func (p *parser) resolve(ref string) string {
+	if ref == "" || p.base == nil {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return p.base.ResolveReference(u).String()
+}
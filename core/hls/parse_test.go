@@ -0,0 +1,182 @@
+package hls
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const masterPlaylist = `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",NAME="English",AUTOSELECT=YES,DEFAULT=NO,CHANNELS="2",URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="es",NAME="Español, Latino",AUTOSELECT=YES,DEFAULT=YES,CHANNELS="6",URI="audio/es.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="en",NAME="English",FORCED=NO,CHARACTERISTICS="public.accessibility.transcribes-spoken-dialog,public.easy-to-read",URI="https://subs.example/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AVERAGE-BANDWIDTH=1000000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720,FRAME-RATE=29.970,AUDIO="aac",SUBTITLES="subs",CLOSED-CAPTIONS=NONE
+720p/index.m3u8
+# A comment between the tag and its URI
+#EXT-X-STREAM-INF:BANDWIDTH=8000000,CODECS="hvc1.2.4.L150.B0,ec-3",RESOLUTION=3840x2160,FRAME-RATE=59.940,VIDEO-RANGE=PQ,HDCP-LEVEL=TYPE-1,AUDIO="aac"
+
+/video/2160p.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2"
+1080p/index.m3u8?token=abc
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,CODECS="avc1.4d401f",RESOLUTION=1280x720,URI="720p/iframes.m3u8"
+`
+
+func TestParseMaster(t *testing.T) {
+	master, media, err := Parse(strings.NewReader(masterPlaylist), "https://cdn.example/movie/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media != nil || master == nil {
+		t.Fatal("master playlist parsed as a media playlist")
+	}
+	if master.Version != 6 || !master.IndependentSegments {
+		t.Errorf("version %d, independent segments %v", master.Version, master.IndependentSegments)
+	}
+	if len(master.Variants) != 3 || len(master.IFrames) != 1 || len(master.Renditions) != 3 {
+		t.Fatalf("%d variants, %d I-frame playlists, %d renditions", len(master.Variants), len(master.IFrames), len(master.Renditions))
+	}
+
+	hd := master.Variants[0]
+	if hd.URI != "https://cdn.example/movie/720p/index.m3u8" {
+		t.Errorf("URI = %q", hd.URI)
+	}
+	if strings.Join(hd.Codecs, " ") != "avc1.4d401f mp4a.40.2" || hd.VideoCodec() != "avc1.4d401f" || hd.AudioCodec() != "mp4a.40.2" {
+		t.Errorf("codecs = %q", hd.Codecs)
+	}
+	if hd.Bandwidth != 1280000 || hd.AverageBandwidth != 1000000 || hd.Resolution() != "1280x720" || hd.FrameRate != 29.97 {
+		t.Errorf("variant = %+v", hd)
+	}
+	if hd.Audio != "aac" || hd.Subtitles != "subs" || hd.ClosedCaptions != "" {
+		t.Errorf("groups = %q %q %q", hd.Audio, hd.Subtitles, hd.ClosedCaptions)
+	}
+
+	uhd := master.Variants[1]
+	if uhd.URI != "https://cdn.example/video/2160p.m3u8" || !uhd.HDR() || uhd.HDCPLevel != "TYPE-1" {
+		t.Errorf("2160p variant = %+v", uhd)
+	}
+	if best := master.Best(); best != uhd {
+		t.Errorf("best = %s", best.URI)
+	}
+	if master.Variants[2].URI != "https://cdn.example/movie/1080p/index.m3u8?token=abc" {
+		t.Errorf("1080p URI = %q", master.Variants[2].URI)
+	}
+
+	iframe := master.IFrames[0]
+	if !iframe.IFrame || iframe.URI != "https://cdn.example/movie/720p/iframes.m3u8" || iframe.Height != 720 {
+		t.Errorf("I-frame playlist = %+v", iframe)
+	}
+
+	audio := master.Group(AUDIO, "aac")
+	if len(audio) != 2 || audio[0].Language != "es" || audio[0].Name != "Español, Latino" || audio[0].Channels != "6" {
+		t.Errorf("audio group = %+v", audio)
+	}
+	subs := master.Group(SUBTITLES, "subs")
+	if len(subs) != 1 || subs[0].URI != "https://subs.example/en.m3u8" || len(subs[0].Characteristics) != 2 {
+		t.Errorf("subtitle group = %+v", subs)
+	}
+}
+
+const mediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@0"
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example/k?id=1",IV=0x00000000000000000000000000000001
+#EXT-X-PROGRAM-DATE-TIME:2026-01-02T03:04:05.500Z
+#EXTINF:5.005,Intro
+#EXT-X-BYTERANGE:1000@720
+video.mp4
+#EXTINF:6.0,
+#EXT-X-BYTERANGE:2000
+video.mp4
+#EXT-X-KEY:METHOD=NONE
+#EXT-X-DISCONTINUITY
+#EXTINF:4.5,
+ad/seg1.ts
+#EXT-X-GAP
+#EXTINF:6,
+ad/seg2.ts
+#EXT-X-ENDLIST
+`
+
+func TestParseMedia(t *testing.T) {
+	master, media, err := Parse(strings.NewReader(strings.ReplaceAll(mediaPlaylist, "\n", "\r\n")), "https://cdn.example/v/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master != nil || media == nil {
+		t.Fatal("media playlist parsed as a master playlist")
+	}
+	if media.TargetDuration != 6*time.Second || media.MediaSequence != 100 || media.PlaylistType != "VOD" || !media.EndList {
+		t.Errorf("playlist = %+v", media)
+	}
+	if len(media.Segments) != 4 {
+		t.Fatalf("%d segments", len(media.Segments))
+	}
+	if d := media.Duration(); d != 21505*time.Millisecond {
+		t.Errorf("duration = %v", d)
+	}
+
+	first, second, ad, gap := media.Segments[0], media.Segments[1], media.Segments[2], media.Segments[3]
+	if first.URI != "https://cdn.example/v/video.mp4" || first.Title != "Intro" || first.Sequence != 100 {
+		t.Errorf("first segment = %+v", first)
+	}
+	if first.Map == nil || first.Map.URI != "https://cdn.example/v/init.mp4" || *first.Map.ByteRange != (ByteRange{720, 0}) {
+		t.Errorf("map = %+v", first.Map)
+	}
+	if first.Key == nil || first.Key.Method != "AES-128" || first.Key.URI != "https://keys.example/k?id=1" || first.Key.IV != "0x00000000000000000000000000000001" {
+		t.Errorf("key = %+v", first.Key)
+	}
+	if first.ProgramDateTime.IsZero() {
+		t.Error("program date time not read")
+	}
+	if *first.ByteRange != (ByteRange{1000, 720}) || *second.ByteRange != (ByteRange{2000, 1720}) {
+		t.Errorf("byte ranges = %+v %+v", first.ByteRange, second.ByteRange)
+	}
+	if second.Key != first.Key || ad.Key != nil || !ad.Discontinuity || second.Discontinuity {
+		t.Errorf("keys and discontinuities carried over wrongly")
+	}
+	if !gap.Gap || gap.Sequence != 103 || gap.Map != first.Map {
+		t.Errorf("last segment = %+v", gap)
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	attrs, err := ParseAttributes(`BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",NAME="a=b",RESOLUTION=1280x720`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Int("BANDWIDTH") != 1280000 || attrs["CODECS"] != "avc1.4d401f,mp4a.40.2" || attrs["NAME"] != "a=b" {
+		t.Errorf("attributes = %v", attrs)
+	}
+	if w, h := attrs.Resolution("RESOLUTION"); w != 1280 || h != 720 {
+		t.Errorf("resolution = %dx%d", w, h)
+	}
+	if _, err := ParseAttributes(`NAME="unterminated`); err == nil {
+		t.Error("unterminated quoted string was accepted")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, input := range map[string]string{
+		"html":       "<html><body>403 Forbidden</body></html>",
+		"empty":      "",
+		"no URI":     "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n",
+		"bad EXTINF": "#EXTM3U\n#EXTINF:abc,\nseg.ts\n",
+		"bad range":  "#EXTM3U\n#EXTINF:1,\n#EXT-X-BYTERANGE:100\nseg.ts\n",
+	} {
+		if _, _, err := Parse(strings.NewReader(input), ""); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if _, _, err := Parse(strings.NewReader("\ufeff#EXTM3U\n#EXTINF:1,\nseg.ts\n"), ""); err != nil {
+		t.Errorf("byte order mark: %v", err)
+	}
+	if _, _, err := Parse(strings.NewReader("not a playlist"), ""); !errors.Is(err, ErrNotPlaylist) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+// Package hls reads HLS master and media playlists as described in RFC 8216.
+package hls
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rendition types of EXT-X-MEDIA.
+const (
+	AUDIO           = "AUDIO"
+	VIDEO           = "VIDEO"
+	SUBTITLES       = "SUBTITLES"
+	CLOSED_CAPTIONS = "CLOSED-CAPTIONS"
+)
+
+// Master is a master playlist: the variants of a stream, their alternative
+// renditions and I-frame playlists.
+type Master struct {
+	Version             int
+	IndependentSegments bool
+	Variants            []*Variant
+	// IFrames are the EXT-X-I-FRAME-STREAM-INF playlists, for trick play.
+	IFrames []*Variant
+	// Renditions are the EXT-X-MEDIA audio, video, subtitle and caption tracks.
+	Renditions []*Rendition
+}
+
+// Variant is an EXT-X-STREAM-INF or EXT-X-I-FRAME-STREAM-INF entry.
+type Variant struct {
+	// URI is resolved against the playlist URL.
+	URI              string
+	Bandwidth        int
+	AverageBandwidth int
+	// Codecs lists the RFC 6381 codecs, e.g. avc1.640028 and mp4a.40.2.
+	Codecs    []string
+	Width     int
+	Height    int
+	FrameRate float64
+	// VideoRange is SDR, HLG or PQ.
+	VideoRange string
+	HDCPLevel  string
+	// Audio, Video, Subtitles and ClosedCaptions name the rendition groups
+	// the variant plays with.
+	Audio          string
+	Video          string
+	Subtitles      string
+	ClosedCaptions string
+	IFrame         bool
+	// Attributes holds every attribute as written, including unknown ones.
+	Attributes Attributes
+}
+
+// Rendition is an EXT-X-MEDIA entry.
+type Rendition struct {
+	Type    string
+	GroupID string
+	Name    string
+	// Language is an RFC 5646 tag, e.g. en or pt-BR.
+	Language        string
+	AssocLanguage   string
+	URI             string
+	Default         bool
+	Autoselect      bool
+	Forced          bool
+	InstreamID      string
+	Characteristics []string
+	// Channels is the audio channel count, e.g. "2" or "6".
+	Channels   string
+	Attributes Attributes
+}
+
+// Media is a media playlist: the segments of one rendition.
+type Media struct {
+	Version               int
+	TargetDuration        time.Duration
+	MediaSequence         int
+	DiscontinuitySequence int
+	// PlaylistType is VOD, EVENT or "" for live playlists.
+	PlaylistType        string
+	EndList             bool
+	IFramesOnly         bool
+	IndependentSegments bool
+	Segments            []*Segment
+}
+
+// Segment is a media segment with the tags that apply to it.
+type Segment struct {
+	URI      string
+	Duration time.Duration
+	Title    string
+	Sequence int
+	// ByteRange is nil when the segment is the whole resource.
+	ByteRange       *ByteRange
+	Discontinuity   bool
+	Key             *Key
+	Map             *Map
+	ProgramDateTime time.Time
+	Gap             bool
+}
+
+type ByteRange struct {
+	Length int64
+	Offset int64
+}
+
+// Key is an EXT-X-KEY; segments without encryption have no key.
+type Key struct {
+	// Method is AES-128 or SAMPLE-AES.
+	Method string
+	URI    string
+	// IV is the hexadecimal initialisation vector, "" to use the sequence number.
+	IV                string
+	KeyFormat         string
+	KeyFormatVersions string
+}
+
+// Map is an EXT-X-MAP, the initialisation section of fMP4 segments.
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+}
+
+// Resolution returns "WIDTHxHEIGHT", or "" when the variant has none.
+func (v *Variant) Resolution() string {
+	if v.Width == 0 && v.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", v.Width, v.Height)
+}
+
+// HDR tells whether the variant uses a high dynamic range transfer function.
+func (v *Variant) HDR() bool {
+	return v.VideoRange == "PQ" || v.VideoRange == "HLG"
+}
+
+var (
+	videoCodecs = []string{"avc1", "avc3", "hvc1", "hev1", "dvh1", "dvhe", "av01", "vp09", "vp8", "vp9", "mp4v"}
+	audioCodecs = []string{"mp4a", "ac-3", "ec-3", "ac-4", "opus", "flac", "alac", "mp3"}
+)
+
+// VideoCodec returns the first video codec of the variant, or "".
+func (v *Variant) VideoCodec() string {
+	return findCodec(v.Codecs, videoCodecs)
+}
+
+// AudioCodec returns the first audio codec of the variant, or "".
+func (v *Variant) AudioCodec() string {
+	return findCodec(v.Codecs, audioCodecs)
+}
+
+func findCodec(codecs, families []string) string {
+	for _, c := range codecs {
+		family, _, _ := strings.Cut(strings.ToLower(c), ".")
+		for _, f := range families {
+			if family == f {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+// Group returns the renditions of the given type in a group, the default one first.
+func (m *Master) Group(typ, groupID string) []*Rendition {
+	var group []*Rendition
+	for _, r := range m.Renditions {
+		if r.Type == typ && r.GroupID == groupID {
+			group = append(group, r)
+		}
+	}
+	sort.SliceStable(group, func(i, j int) bool { return group[i].Default && !group[j].Default })
+	return group
+}
+
+// SortVariants orders variants best first: by height, then by bandwidth.
+func SortVariants(variants []*Variant) {
+	sort.SliceStable(variants, func(i, j int) bool {
+		if variants[i].Height != variants[j].Height {
+			return variants[i].Height > variants[j].Height
+		}
+		return variants[i].Bandwidth > variants[j].Bandwidth
+	})
+}
+
+// Best returns the variant with the highest resolution, then bandwidth, or
+// nil when the playlist has none.
+func (m *Master) Best() *Variant {
+	if len(m.Variants) == 0 {
+		return nil
+	}
+	variants := append([]*Variant(nil), m.Variants...)
+	SortVariants(variants)
+	return variants[0]
+}
+
+// Duration is the sum of the segment durations.
+func (m *Media) Duration() time.Duration {
+	var d time.Duration
+	for _, s := range m.Segments {
+		d += s.Duration
+	}
+	return d
+}
@@ -0,0 +1,74 @@
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// Rewrite copies a playlist from r to w, replacing every URI in it, both the
+// segment and variant lines and the URI attributes of tags such as EXT-X-KEY,
+// EXT-X-MAP and EXT-X-MEDIA, by fn of the URI resolved against base. Every
+// other line is copied as is.
+func Rewrite(w io.Writer, r io.Reader, base string, fn func(uri string) string) error {
+	var baseURL *url.URL
+	if base != "" {
+		var err error
+		if baseURL, err = url.Parse(base); err != nil {
+			return err
+		}
+	}
+	resolve := func(ref string) string {
+		u, err := url.Parse(ref)
+		if err != nil || baseURL == nil {
+			return fn(ref)
+		}
+		return fn(baseURL.ResolveReference(u).String())
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if first {
+			trimmed = strings.TrimPrefix(trimmed, "\ufeff")
+			if trimmed == "" {
+				continue
+			}
+			if !strings.HasPrefix(trimmed, "#EXTM3U") {
+				return ErrNotPlaylist
+			}
+			first = false
+			line = trimmed
+		}
+
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#EXT"):
+			line = uriAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+				ref := uriAttribute.FindStringSubmatch(attr)[1]
+				return `URI="` + resolve(ref) + `"`
+			})
+		case strings.HasPrefix(trimmed, "#"):
+		default:
+			line = resolve(trimmed)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if first {
+		return ErrNotPlaylist
+	}
+	return nil
+}
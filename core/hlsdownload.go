@@ -0,0 +1,521 @@
+package core
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demonkingswarn/luffy/core/hls"
+)
+
+const (
+	// HLS_WORKERS is how many segments are fetched at once.
+	HLS_WORKERS = 8
+	// HLS_SEGMENT_RETRIES is how many more times a segment is tried after a
+	// truncated or failed download, on top of the client's own retries.
+	HLS_SEGMENT_RETRIES = 5
+)
+
+// HLSProgress is reported each time a segment has been fetched.
+type HLSProgress struct {
+	Segments int
+	Total    int
+	Bytes    int64
+}
+
+// HLSDownloader saves an HLS stream without yt-dlp. Segments are fetched
+// concurrently into a .parts directory next to the output, so an interrupted
+// download picks up where it stopped, then joined into a .ts, or remuxed into
+// an .mp4 when ffmpeg is installed.
+type HLSDownloader struct {
+	Client  *http.Client
+	Stream  *Stream
+	Workers int
+	Retries int
+	// FFmpeg is the ffmpeg binary to remux with, "" to keep the .ts.
+	FFmpeg string
+	// Pick picks the variant of a master playlist to download, as
+	// Validator.Pick does; the best one is taken when it is nil or returns -1.
+	Pick     func(variants []StreamQuality) int
+	Progress func(HLSProgress)
+}
+
+func NewHLSDownloader(client *http.Client, stream *Stream) *HLSDownloader {
+	ffmpeg, _ := exec.LookPath("ffmpeg")
+	return &HLSDownloader{
+		Client:  client,
+		Stream:  stream,
+		Workers: HLS_WORKERS,
+		Retries: HLS_SEGMENT_RETRIES,
+		FFmpeg:  ffmpeg,
+	}
+}
+
+// hlsTrack is a media playlist being downloaded: the video, or an audio
+// rendition the video variant plays with.
+type hlsTrack struct {
+	name  string
+	media *hls.Media
+	// inits holds the file of each initialisation section
+	inits map[*hls.Map]string
+}
+
+type hlsJob struct {
+	segment *hls.Segment
+	path    string
+}
+
+// Download saves the stream to base plus the extension of what was written,
+// which it returns.
+func (d *HLSDownloader) Download(ctx context.Context, base string) (string, error) {
+	video, audio, err := d.playlists(ctx)
+	if err != nil {
+		return "", err
+	}
+	tracks := []*hlsTrack{{name: "video", media: video}}
+	if audio != nil {
+		tracks = append(tracks, &hlsTrack{name: "audio", media: audio})
+	}
+
+	// Segments left by an interrupted download are only reused for the same
+	// playlist, which is told apart by its length as the URLs may be signed
+	work := base + ".parts"
+	stamp := ""
+	for _, t := range tracks {
+		stamp += fmt.Sprintf("%s: %d segments, %v\n", t.name, len(t.media.Segments), t.media.Duration())
+	}
+	stampPath := filepath.Join(work, "playlist")
+	if old, err := os.ReadFile(stampPath); err == nil && string(old) != stamp {
+		os.RemoveAll(work)
+	}
+	if err := os.MkdirAll(work, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(stampPath, []byte(stamp), 0644); err != nil {
+		return "", err
+	}
+	var jobs []hlsJob
+	for _, t := range tracks {
+		t.inits = map[*hls.Map]string{}
+		for i, seg := range t.media.Segments {
+			if seg.Map != nil && t.inits[seg.Map] == "" {
+				// The initialisation section is fetched like a segment, with its key
+				p := filepath.Join(work, fmt.Sprintf("%s-init-%d", t.name, len(t.inits)))
+				t.inits[seg.Map] = p
+				jobs = append(jobs, hlsJob{&hls.Segment{URI: seg.Map.URI, ByteRange: seg.Map.ByteRange, Key: seg.Key, Sequence: seg.Sequence}, p})
+			}
+			if !seg.Gap {
+				jobs = append(jobs, hlsJob{seg, segmentPath(work, t.name, i)})
+			}
+		}
+	}
+	if err := d.fetchAll(ctx, jobs); err != nil {
+		return "", err
+	}
+
+	out, err := d.assemble(ctx, tracks, work, base)
+	if err != nil {
+		return "", err
+	}
+	os.RemoveAll(work)
+	return out, nil
+}
+
+func segmentPath(work, track string, i int) string {
+	return filepath.Join(work, fmt.Sprintf("%s-%05d", track, i))
+}
+
+// playlists fetches the media playlist to download, taking the variant of a
+// master playlist that Pick picks, and the audio rendition that goes with it.
+func (d *HLSDownloader) playlists(ctx context.Context) (video, audio *hls.Media, err error) {
+	if len(d.Stream.Variants) == 0 {
+		return nil, nil, fmt.Errorf("stream has no URL")
+	}
+	videoURL, audioURL := d.Stream.URL(), d.Stream.Variants[0].AudioURL
+	video, master, err := d.media(ctx, videoURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if master != nil {
+		q := pickOrBest(d.Pick, masterQualities(master))
+		audioURL = q.AudioURL
+		if video, _, err = d.media(ctx, q.URL); err != nil {
+			return nil, nil, err
+		}
+		if video == nil {
+			return nil, nil, fmt.Errorf("variant %s is not a media playlist", q.URL)
+		}
+	}
+	if audioURL != "" {
+		if audio, _, err = d.media(ctx, audioURL); err != nil {
+			return nil, nil, fmt.Errorf("audio: %w", err)
+		}
+	}
+
+	for _, m := range []*hls.Media{video, audio} {
+		if m == nil {
+			continue
+		}
+		if len(m.Segments) == 0 {
+			return nil, nil, fmt.Errorf("playlist has no segments")
+		}
+		for _, seg := range m.Segments {
+			if seg.Key != nil && seg.Key.Method != "AES-128" {
+				return nil, nil, fmt.Errorf("segments are encrypted with %s, which is not supported", seg.Key.Method)
+			}
+		}
+	}
+	return video, audio, nil
+}
+
+func (d *HLSDownloader) media(ctx context.Context, link string) (*hls.Media, *hls.Master, error) {
+	req, err := d.Stream.NewRequest(ctx, "GET", link)
+	if err != nil {
+		return nil, nil, err
+	}
+	master, media, err := fetchM3U8(req, d.Client)
+	if err == nil && master != nil && len(master.Variants) == 0 {
+		return nil, nil, fmt.Errorf("master playlist has no variants")
+	}
+	return media, master, err
+}
+
+// fetchAll downloads the jobs with d.Workers at a time, stopping at the first
+// segment that still fails after its retries.
+func (d *HLSDownloader) fetchAll(ctx context.Context, jobs []hlsJob) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	keys := &hlsKeys{d: d, keys: map[string][]byte{}}
+	queue := make(chan hlsJob)
+	var mu sync.Mutex
+	progress := HLSProgress{Total: len(jobs)}
+
+	var wg sync.WaitGroup
+	for range max(d.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				n, err := d.fetchSegment(ctx, keys, job)
+				if err != nil {
+					cancel(err)
+					continue
+				}
+				mu.Lock()
+				progress.Segments++
+				progress.Bytes += n
+				if d.Progress != nil {
+					d.Progress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+func (d *HLSDownloader) fetchSegment(ctx context.Context, keys *hlsKeys, job hlsJob) (int64, error) {
+	// Left over from an interrupted download
+	if info, err := os.Stat(job.path); err == nil {
+		return info.Size(), nil
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= d.Retries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(min(500*time.Millisecond<<(attempt-1), 10*time.Second))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return 0, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		data, err := d.get(ctx, job.segment.URI, job.segment.ByteRange)
+		if err == nil && job.segment.Key != nil {
+			data, err = keys.decrypt(ctx, job.segment, data)
+		}
+		if err == nil {
+			return int64(len(data)), writeFileAtomic(job.path, data)
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		lastErr = err
+	}
+	return 0, fmt.Errorf("segment %d: %w", job.segment.Sequence, lastErr)
+}
+
+func (d *HLSDownloader) get(ctx context.Context, link string, br *hls.ByteRange) ([]byte, error) {
+	req, err := d.Stream.NewRequest(ctx, "GET", link)
+	if err != nil {
+		return nil, err
+	}
+	if br != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", br.Offset, br.Offset+br.Length-1))
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	// A short body fails with io.ErrUnexpectedEOF and is tried again
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if br != nil && resp.StatusCode == http.StatusOK {
+		// The server ignored the range and sent the whole resource
+		if int64(len(data)) < br.Offset+br.Length {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data = data[br.Offset : br.Offset+br.Length]
+	}
+	return data, nil
+}
+
+// hlsKeys fetches each AES-128 key once.
+type hlsKeys struct {
+	d    *HLSDownloader
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (k *hlsKeys) key(ctx context.Context, uri string) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.keys[uri]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := k.d.get(ctx, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("key is %d bytes, want 16", len(key))
+	}
+	k.mu.Lock()
+	k.keys[uri] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+// decrypt undoes AES-128 CBC encryption with PKCS7 padding. Without an IV
+// in the playlist, the media sequence number is the IV.
+func (k *hlsKeys) decrypt(ctx context.Context, seg *hls.Segment, data []byte) ([]byte, error) {
+	key, err := k.key(ctx, seg.Key.URI)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if seg.Key.IV != "" {
+		raw := strings.TrimPrefix(strings.TrimPrefix(seg.Key.IV, "0x"), "0X")
+		b, err := hex.DecodeString(raw)
+		if err != nil || len(b) > aes.BlockSize {
+			return nil, fmt.Errorf("bad IV %q", seg.Key.IV)
+		}
+		copy(iv[aes.BlockSize-len(b):], b)
+	} else {
+		binary.BigEndian.PutUint64(iv[8:], uint64(seg.Sequence))
+	}
+
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment is %d bytes, not a whole number of blocks", len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	pad := int(data[len(data)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(data) {
+		return nil, fmt.Errorf("bad padding, wrong key?")
+	}
+	return data[:len(data)-pad], nil
+}
+
+// assemble joins the segments of each track, one file per discontinuity so
+// ffmpeg can line their timestamps up, and remuxes them into an .mp4. Without
+// ffmpeg, or if it fails, the files are joined as they are.
+func (d *HLSDownloader) assemble(ctx context.Context, tracks []*hlsTrack, work, base string) (string, error) {
+	parts := make([][]string, len(tracks))
+	for i, t := range tracks {
+		var err error
+		if parts[i], err = t.join(work); err != nil {
+			return "", err
+		}
+	}
+
+	if d.FFmpeg != "" {
+		out := base + ".mp4"
+		err := d.remux(ctx, parts, work, out)
+		if err == nil {
+			return out, nil
+		}
+		fmt.Fprintf(os.Stderr, "[warning] ffmpeg could not remux the download, keeping the segments as they are: %v\n", err)
+	}
+
+	var out string
+	for i, t := range tracks {
+		name := base + filepath.Ext(parts[i][0])
+		if i > 0 {
+			// Without ffmpeg the separate audio is kept next to the video
+			name = base + "." + t.name + filepath.Ext(parts[i][0])
+		}
+		if err := concatFiles(name, parts[i]); err != nil {
+			return "", err
+		}
+		if i == 0 {
+			out = name
+		}
+	}
+	return out, nil
+}
+
+// join writes the segments of the track into one file per discontinuity,
+// with the initialisation section in front of the segments that use it.
+func (t *hlsTrack) join(work string) ([]string, error) {
+	ext := ".ts"
+	if first := t.media.Segments[0]; first.Map != nil {
+		ext = ".mp4"
+	} else if e := strings.ToLower(path.Ext(strings.SplitN(first.URI, "?", 2)[0])); e == ".aac" || e == ".ac3" || e == ".ec3" || e == ".mp3" {
+		ext = e
+	}
+
+	var parts []string
+	var out *os.File
+	var lastMap *hls.Map
+	closeOut := func() error {
+		if out == nil {
+			return nil
+		}
+		err := out.Close()
+		out = nil
+		return err
+	}
+	for i, seg := range t.media.Segments {
+		if seg.Gap {
+			continue
+		}
+		if out == nil || seg.Discontinuity {
+			if err := closeOut(); err != nil {
+				return nil, err
+			}
+			p := filepath.Join(work, fmt.Sprintf("%s.part%d%s", t.name, len(parts), ext))
+			f, err := os.Create(p)
+			if err != nil {
+				return nil, err
+			}
+			out, lastMap = f, nil
+			parts = append(parts, p)
+		}
+		if seg.Map != nil && seg.Map != lastMap {
+			if err := appendFile(out, t.inits[seg.Map]); err != nil {
+				closeOut()
+				return nil, err
+			}
+			lastMap = seg.Map
+		}
+		if err := appendFile(out, segmentPath(work, t.name, i)); err != nil {
+			closeOut()
+			return nil, err
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%s playlist only has gaps", t.name)
+	}
+	return parts, closeOut()
+}
+
+func (d *HLSDownloader) remux(ctx context.Context, parts [][]string, work, out string) error {
+	args := []string{"-y", "-loglevel", "error"}
+	for i, files := range parts {
+		if len(files) == 1 {
+			args = append(args, "-i", files[0])
+			continue
+		}
+		list := filepath.Join(work, fmt.Sprintf("concat%d.txt", i))
+		var b strings.Builder
+		for _, f := range files {
+			fmt.Fprintf(&b, "file '%s'\n", strings.ReplaceAll(f, "'", `'\''`))
+		}
+		if err := os.WriteFile(list, []byte(b.String()), 0644); err != nil {
+			return err
+		}
+		args = append(args, "-f", "concat", "-safe", "0", "-i", list)
+	}
+	// Timed metadata and other data streams do not fit in an .mp4
+	if len(parts) > 1 {
+		args = append(args, "-map", "0:v?", "-map", "1:a")
+	} else {
+		args = append(args, "-map", "0:v?", "-map", "0:a?")
+	}
+	args = append(args, "-c", "copy", "-movflags", "+faststart", out)
+
+	cmd := exec.CommandContext(ctx, d.FFmpeg, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(out)
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func appendFile(out *os.File, name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func concatFiles(name string, files []string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := appendFile(out, f); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
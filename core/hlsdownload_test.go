@@ -0,0 +1,210 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func encryptSegment(t *testing.T, key, iv, data []byte) []byte {
+	t.Helper()
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(bytes.Clone(data), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+func TestHLSDownloader(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	// The second segment has no IV in the playlist, so its sequence number is used
+	seqIV := make([]byte, 16)
+	seqIV[15] = 11
+	explicitIV := bytes.Repeat([]byte{0xab}, 16)
+	segments := map[string][]byte{
+		"/v/seg10.ts": encryptSegment(t, key, explicitIV, []byte("first segment|")),
+		"/v/seg11.ts": encryptSegment(t, key, seqIV, []byte("second segment|")),
+		"/v/all.ts":   []byte("xxxxthird|fourth|"),
+	}
+
+	var mu sync.Mutex
+	truncated := false
+	var referers []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		referers = append(referers, r.Referer())
+		switch r.URL.Path {
+		case "/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=640x360\nlow/index.m3u8\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS=\"avc1.64001f,mp4a.40.2\"\nv/index.m3u8\n")
+		case "/v/index.m3u8":
+			fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:10\n"+
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"/key\",IV=0x%x\n#EXTINF:6,\nseg10.ts\n"+
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"/key\"\n#EXTINF:6,\nseg11.ts\n"+
+				"#EXT-X-KEY:METHOD=NONE\n#EXT-X-DISCONTINUITY\n#EXTINF:3,\n#EXT-X-BYTERANGE:6@4\nall.ts\n"+
+				"#EXTINF:3,\n#EXT-X-BYTERANGE:7\nall.ts\n#EXT-X-ENDLIST\n", explicitIV)
+		case "/key":
+			w.Write(key)
+		default:
+			data, ok := segments[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if r.URL.Path == "/v/seg11.ts" && !truncated {
+				// The connection drops halfway through the segment once
+				truncated = true
+				w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+				w.Write(data[:len(data)/2])
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		}
+	}))
+	defer srv.Close()
+
+	stream := &Stream{Variants: []StreamQuality{{URL: srv.URL + "/master.m3u8"}}, Referer: "https://embed.example/", Container: ContainerHLS}
+	d := NewHLSDownloader(NewClient(), stream)
+	d.FFmpeg = ""
+	d.Retries = 2
+	var last HLSProgress
+	d.Progress = func(p HLSProgress) { last = p }
+
+	base := filepath.Join(t.TempDir(), "movie")
+	out, err := d.Download(context.Background(), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != base+".ts" {
+		t.Errorf("output = %q", out)
+	}
+	data, _ := os.ReadFile(out)
+	if string(data) != "first segment|second segment|third|fourth|" {
+		t.Errorf("output = %q", data)
+	}
+	if last.Segments != 4 || last.Total != 4 {
+		t.Errorf("progress = %+v", last)
+	}
+	if !truncated {
+		t.Error("truncated segment was not retried")
+	}
+	for _, r := range referers {
+		if r != "https://embed.example/" {
+			t.Errorf("request without the stream referer: %q", r)
+			break
+		}
+	}
+	if _, err := os.Stat(base + ".parts"); !os.IsNotExist(err) {
+		t.Error("segment directory left behind")
+	}
+}
+
+func TestHLSDownloaderPick(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nlow/index.m3u8\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720\nhigh/index.m3u8\n")
+		case "/low/index.m3u8", "/high/index.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:10,\nseg.ts\n#EXT-X-ENDLIST\n")
+		case "/low/seg.ts", "/high/seg.ts":
+			fmt.Fprint(w, r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	stream := &Stream{Variants: []StreamQuality{{URL: srv.URL + "/master.m3u8"}}, Container: ContainerHLS}
+	q, _ := ParseQuality("<=480p")
+	meta, err := probeDownload(context.Background(), NewClient(), stream, q.Pick, "Movie")
+	if err != nil || meta.Resolution != "640x360" || meta.Filesize != 800000/8*10 {
+		t.Errorf("probe = %+v, %v", meta, err)
+	}
+
+	d := NewHLSDownloader(NewClient(), stream)
+	d.FFmpeg = ""
+	d.Pick = q.Pick
+	out, err := d.Download(context.Background(), filepath.Join(t.TempDir(), "movie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "/low/seg.ts" {
+		t.Errorf("downloaded %q", data)
+	}
+
+	// Without a policy the best variant is taken
+	d.Pick = nil
+	if out, err = d.Download(context.Background(), filepath.Join(t.TempDir(), "movie")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "/high/seg.ts" {
+		t.Errorf("downloaded %q", data)
+	}
+}
+
+func TestHLSDownloaderRejectsSampleAES(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"skd://key\"\n#EXTINF:6,\nseg.ts\n#EXT-X-ENDLIST\n")
+	}))
+	defer srv.Close()
+
+	stream := &Stream{Variants: []StreamQuality{{URL: srv.URL + "/index.m3u8"}}, Container: ContainerHLS}
+	_, err := NewHLSDownloader(NewClient(), stream).Download(context.Background(), filepath.Join(t.TempDir(), "movie"))
+	if err == nil || !strings.Contains(err.Error(), "SAMPLE-AES") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "https://embed.example" || r.UserAgent() != "test-agent" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/cut.mp4" {
+			// The connection drops halfway through the file
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("half"))
+			return
+		}
+		w.Write([]byte("movie"))
+	}))
+	defer srv.Close()
+
+	stream := &Stream{UserAgent: "test-agent", Headers: map[string]string{"Origin": "https://embed.example"}}
+	dir := t.TempDir()
+	name := filepath.Join(dir, "movie.mp4")
+	if err := downloadFile(context.Background(), NewClient(), stream, srv.URL+"/movie.mp4", name); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(name); string(data) != "movie" {
+		t.Errorf("file = %q", data)
+	}
+
+	cut := filepath.Join(dir, "cut.mp4")
+	if err := downloadFile(context.Background(), NewClient(), stream, srv.URL+"/cut.mp4", cut); err == nil {
+		t.Error("truncated download succeeded")
+	}
+	for _, f := range []string{cut, cut + ".part"} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s left behind", filepath.Base(f))
+		}
+	}
+}
@@ -1,17 +1,359 @@
 package core
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"gopkg.in/yaml.v3"
+)
+
+// BROWSER_USER_AGENT is sent with every request that does not set its own.
+const BROWSER_USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36"
+
+// HTTPConfig is the http section of the config.
+type HTTPConfig struct {
+	// ConnectTimeout bounds the TCP connect and TLS handshake.
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// ReadTimeout bounds the wait for response headers once a request is sent.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// Retries is how many times a request failing with a 5xx, a 429 or a
+	// dropped connection is retried, waiting RetryBackoff, then twice as long.
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// Proxy is an http://, https:// or socks5:// URL. Empty uses HTTP_PROXY
+	// and HTTPS_PROXY from the environment.
+	Proxy string `yaml:"proxy"`
+	// CookieFile is where cookies are kept between runs; "none" keeps them
+	// in memory only. Default: ~/.cache/luffy/cookies.json
+	CookieFile string `yaml:"cookie_file"`
+	UserAgent  string `yaml:"user_agent"`
+	// Limits caps the request rate and concurrency per provider or host,
+	// see limitTransport. "default" applies to hosts not listed.
+	Limits map[string]HostLimit `yaml:"limits"`
+	// Cache keeps catalogue lookups on disk, see cacheTransport.
+	Cache CacheConfig `yaml:"cache"`
+}
+
+func DefaultHTTPConfig() HTTPConfig {
+	return HTTPConfig{
+		ConnectTimeout: 10 * time.Second,
+		ReadTimeout:    30 * time.Second,
+		Retries:        2,
+		RetryBackoff:   500 * time.Millisecond,
+		Limits: map[string]HostLimit{
+			"default": {Rate: 10, Burst: 20, Concurrency: 8},
+			"decoder": {Rate: 2, Burst: 4, Concurrency: 2},
+		},
+		Cache: DefaultCacheConfig(),
+	}
+}
+
+// UnmarshalYAML reads connect_timeout, read_timeout and retry_backoff the way
+// the timeouts section reads its deadlines, a bare number being seconds
+// rather than nanoseconds.
+func (c *HTTPConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			switch key, value := node.Content[i].Value, node.Content[i+1]; key {
+			case "connect_timeout", "read_timeout", "retry_backoff":
+				d, err := parseSeconds(value.Value)
+				if err != nil {
+					return fmt.Errorf("line %d: http.%s: %w", value.Line, key, err)
+				}
+				value.Value, value.Tag = d.String(), "!!str"
+			}
+		}
+	}
+	type plain HTTPConfig
+	return node.Decode((*plain)(c))
+}
+
+var (
+	httpMu        sync.RWMutex
+	httpConfig    = DefaultHTTPConfig()
+	httpProxy     *url.URL
+	httpTransport http.RoundTripper
+	httpJar       http.CookieJar
+)
+
+// ConfigureHTTP applies the http section of the config to every client
+// NewClient returns from then on.
+func ConfigureHTTP(cfg HTTPConfig) error {
+	def := DefaultHTTPConfig()
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = def.ConnectTimeout
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = def.ReadTimeout
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = def.RetryBackoff
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.Limits == nil {
+		cfg.Limits = def.Limits
+	}
+	if cfg.Cache.MaxSizeMB <= 0 {
+		cfg.Cache.MaxSizeMB = def.Cache.MaxSizeMB
+	}
+	// Kinds left out of the config keep their default TTL
+	ttl := def.Cache.TTL
+	for kind, d := range cfg.Cache.TTL {
+		ttl[kind] = d
+	}
+	cfg.Cache.TTL = ttl
+	if cfg.Cache.Dir == "" {
+		if dir, err := GetCacheDir(); err == nil {
+			cfg.Cache.Dir = filepath.Join(dir, "http")
+		} else {
+			cfg.Cache.Disabled = true
+		}
+	} else {
+		cfg.Cache.Dir = expandHome(cfg.Cache.Dir)
+	}
+
+	var proxy *url.URL
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("proxy: unsupported scheme %q (use http, https or socks5)", u.Scheme)
+		}
+		proxy = u
+	}
+
+	var jar http.CookieJar
+	var err error
+	switch cfg.CookieFile {
+	case "none":
+		jar, err = NewCookieJar("")
+	case "":
+		path := ""
+		if dir, dirErr := GetCacheDir(); dirErr == nil {
+			path = filepath.Join(dir, "cookies.json")
+		}
+		jar, err = NewCookieJar(path)
+	default:
+		jar, err = NewCookieJar(expandHome(cfg.CookieFile))
+	}
+	if err != nil {
+		return fmt.Errorf("cookies: %w", err)
+	}
+
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	httpConfig, httpProxy, httpJar = cfg, proxy, jar
+	httpTransport = nil
+	return nil
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// UserAgent is the user agent luffy sends, and hands to players and
+// downloaders for streams that do not need a particular one.
+func UserAgent() string {
+	httpMu.RLock()
+	defer httpMu.RUnlock()
+	if httpConfig.UserAgent != "" {
+		return httpConfig.UserAgent
+	}
+	return BROWSER_USER_AGENT
+}
+
+// ProxyURL returns the configured proxy, or "" when the environment decides.
+func ProxyURL() string {
+	httpMu.RLock()
+	defer httpMu.RUnlock()
+	if httpProxy == nil {
+		return ""
+	}
+	return httpProxy.String()
+}
+
+// NewClient returns a client on the shared transport: it gives up on stalled
+// connections, retries failed requests with backoff, moves to a site's
+// mirrors when its host is down (see LookupSite), keeps cookies, sends the
+// browser user agent and answers catalogue lookups from the disk cache.
+// Overall request deadlines come from the context passed to each request.
 func NewClient() *http.Client {
-	return &http.Client{}
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	if httpTransport == nil {
+		httpTransport = newTransport(httpConfig, httpProxy)
+	}
+	if httpJar == nil {
+		httpJar, _ = NewCookieJar("")
+	}
+	return &http.Client{Transport: httpTransport, Jar: httpJar}
+}
+
+func newTransport(cfg HTTPConfig, proxy *url.URL) http.RoundTripper {
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != nil {
+		proxyFunc = http.ProxyURL(proxy)
+	}
+	base := &http.Transport{
+		Proxy: proxyFunc,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   cfg.ConnectTimeout,
+		ResponseHeaderTimeout: cfg.ReadTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	limits := newLimitTransport(&userAgentTransport{base: &mirrorTransport{base: &harTransport{base: base}}}, cfg.Limits)
+	var transport http.RoundTripper = &retryTransport{
+		base:    limits,
+		limits:  limits,
+		retries: cfg.Retries,
+		backoff: cfg.RetryBackoff,
+	}
+	if !cfg.Cache.Disabled {
+		transport = newCacheTransport(transport, cfg.Cache)
+	}
+	return transport
+}
+
+// userAgentTransport fills in the user agent of requests that have none.
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", UserAgent())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// retryTransport retries requests that failed with a 5xx, a 429 or a dropped
+// connection, backing off exponentially. Requests other than GET and HEAD are
+// only retried when the server said it did not process them (429, 503).
+// A 429 or 503 holds back every request to the host for the Retry-After the
+// server asked for, or the backoff.
+type retryTransport struct {
+	base    http.RoundTripper
+	limits  *limitTransport
+	retries int
+	backoff time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.GetBody == nil {
+		return t.base.RoundTrip(req)
+	}
+	idempotent := req.Method == "" || req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if attempt >= t.retries || !retryable(req, idempotent, resp, err) {
+			return resp, err
+		}
+
+		// Jitter the backoff so parallel requests do not retry in lockstep
+		wait := t.backoff << attempt
+		wait = wait/2 + rand.N(wait/2+1)
+		if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+			if after, ok := retryAfter(resp, time.Now()); ok {
+				if after > MAX_RETRY_AFTER {
+					return resp, nil
+				}
+				wait = max(wait, after)
+			}
+			if t.limits != nil {
+				t.limits.pause(req, time.Now().Add(wait))
+			}
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryable(req *http.Request, idempotent bool, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return idempotent && transientNetError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// transientNetError tells dropped connections and timeouts apart from
+// errors that will not go away on their own, like a host that does not exist.
+func transientNetError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
 }
 
-func NewRequest(method, url string) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, nil)
+func NewRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "luffy/1.0")
 	req.Header.Set("X-Requested-With", "XMLHttpRequest")
 	return req, nil
 }
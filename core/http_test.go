@@ -0,0 +1,115 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	if err := ConfigureHTTP(HTTPConfig{Retries: 2, RetryBackoff: time.Millisecond, CookieFile: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureHTTP(HTTPConfig{CookieFile: "none"})
+
+	hits := map[string]int{}
+	var agents []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.Method+" "+r.URL.Path]++
+		agents = append(agents, r.UserAgent())
+		switch {
+		case r.URL.Path == "/flaky" && hits["GET /flaky"] < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	client := NewClient()
+
+	resp, err := client.Get(srv.URL + "/flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || hits["GET /flaky"] != 3 {
+		t.Errorf("flaky: status %d after %d requests", resp.StatusCode, hits["GET /flaky"])
+	}
+	if agents[0] != BROWSER_USER_AGENT {
+		t.Errorf("user agent = %q", agents[0])
+	}
+
+	// A POST that failed may have been processed, so it is not sent again
+	resp, err = client.Post(srv.URL+"/broken", "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if hits["POST /broken"] != 1 {
+		t.Errorf("POST sent %d times", hits["POST /broken"])
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/broken", nil)
+	req.Header.Set("User-Agent", "curl/8.18.0")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 500 || hits["GET /broken"] != 3 {
+		t.Errorf("broken: status %d after %d requests", resp.StatusCode, hits["GET /broken"])
+	}
+	if agents[len(agents)-1] != "curl/8.18.0" {
+		t.Errorf("user agent was overridden: %q", agents[len(agents)-1])
+	}
+}
+
+func TestConfigureHTTPProxy(t *testing.T) {
+	defer ConfigureHTTP(HTTPConfig{CookieFile: "none"})
+	if err := ConfigureHTTP(HTTPConfig{Proxy: "ftp://proxy.example", CookieFile: "none"}); err == nil {
+		t.Error("ftp proxy was accepted")
+	}
+	if err := ConfigureHTTP(HTTPConfig{Proxy: "socks5://127.0.0.1:9050", CookieFile: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ProxyURL(); got != "socks5://127.0.0.1:9050" {
+		t.Errorf("proxy = %q", got)
+	}
+}
+
+func TestCookieJarPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := url.Parse("https://www.example.com/movie/1")
+
+	jar, err := NewCookieJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "cf_clearance", Value: "abc", Domain: "example.com", Path: "/", MaxAge: 3600},
+		{Name: "session", Value: "xyz"},
+	})
+
+	jar, err = NewCookieJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := jar.Cookies(u)
+	if len(cookies) != 1 || cookies[0].Name != "cf_clearance" || cookies[0].Value != "abc" {
+		t.Fatalf("cookies after reload = %v", cookies)
+	}
+	if other, _ := url.Parse("https://cdn.example.com/"); len(jar.Cookies(other)) != 1 {
+		t.Error("domain cookie not sent to subdomain")
+	}
+
+	// Expiring a cookie removes it from the file too
+	jar.SetCookies(u, []*http.Cookie{{Name: "cf_clearance", Domain: "example.com", Path: "/", MaxAge: -1}})
+	jar, _ = NewCookieJar(path)
+	if cookies := jar.Cookies(u); len(cookies) != 0 {
+		t.Errorf("cookies after delete = %v", cookies)
+	}
+}
@@ -1,9 +1,9 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -22,7 +22,7 @@ func GetCacheDir() (string, error) {
 	return cacheDir, nil
 }
 
-func DownloadPoster(url string, title string) (string, error) {
+func DownloadPoster(ctx context.Context, url string, title string) (string, error) {
 	if url == "" {
 		return "", fmt.Errorf("empty url")
 	}
@@ -45,13 +45,12 @@ func DownloadPoster(url string, title string) (string, error) {
 		return fullPath, nil
 	}
 
-	req, err := NewRequest("GET", url)
+	req, err := NewRequest(ctx, "GET", url)
 	if err != nil {
 		return "", err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := NewClient().Do(req)
 	if err != nil {
 		return "", err
 	}
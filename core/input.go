@@ -2,6 +2,7 @@ package core
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -9,11 +10,22 @@ import (
 	"github.com/demonkingswarn/fzf.go"
 )
 
-func Prompt(label string) string {
+// Prompt reads a line from stdin, giving up with ctx's error once ctx is
+// done, such as on Ctrl-C.
+func Prompt(ctx context.Context, label string) (string, error) {
 	fmt.Print(label + ": ")
-	reader := bufio.NewReader(os.Stdin)
-	text, _ := reader.ReadString('\n')
-	return strings.TrimSpace(text)
+	line := make(chan string, 1)
+	go func() {
+		text, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		line <- text
+	}()
+	select {
+	case text := <-line:
+		return strings.TrimSpace(text), nil
+	case <-ctx.Done():
+		fmt.Println()
+		return "", ctx.Err()
+	}
 }
 
 func Select(label string, items []string) int {
@@ -1,228 +1,108 @@
 package core
 
 import (
-	"bufio"
+	"cmp"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
-	"sort"
-	"strconv"
-	"strings"
+
+	"github.com/demonkingswarn/luffy/core/hls"
 )
 
 type StreamQuality struct {
 	URL        string
+	Label      string
 	Resolution string
 	Bandwidth  int
 	Height     int
+	// Codecs, FrameRate and VideoRange come from HLS master playlists.
+	Codecs     []string
+	FrameRate  float64
+	VideoRange string
+	// AudioURL is the playlist of the audio rendition a variant without its
+	// own audio plays with.
+	AudioURL string
 }
 
-func GetM3U8Streams(m3u8URL string, client *http.Client) ([]StreamQuality, error) {
-	req, err := http.NewRequest("GET", m3u8URL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-
+// fetchM3U8 sends req and parses the playlist it returns.
+func fetchM3U8(req *http.Request, client *http.Client) (*hls.Master, *hls.Media, error) {
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to fetch m3u8: %d", resp.StatusCode)
+		return nil, nil, fmt.Errorf("failed to fetch m3u8: %d", resp.StatusCode)
 	}
+	// Relative URIs resolve against where the playlist ended up after redirects
+	return hls.Parse(io.LimitReader(resp.Body, 8<<20), resp.Request.URL.String())
+}
 
-	baseURL, err := url.Parse(m3u8URL)
-	if err != nil {
-		return nil, err
+func variantQuality(master *hls.Master, v *hls.Variant) StreamQuality {
+	q := StreamQuality{
+		URL:        v.URI,
+		Resolution: cmp.Or(v.Resolution(), "Unknown"),
+		Bandwidth:  v.Bandwidth,
+		Height:     v.Height,
+		Codecs:     v.Codecs,
+		FrameRate:  v.FrameRate,
+		VideoRange: v.VideoRange,
 	}
-
-	scanner := bufio.NewScanner(resp.Body)
-
-	var streams []StreamQuality
-	var currentBandwidth int
-	var currentResolution string
-	var currentHeight int
-	var isVariant bool
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
-			isVariant = true
-			currentBandwidth = 0
-			currentResolution = "Unknown"
-			currentHeight = 0
-
-			// Parse BANDWIDTH
-			if strings.Contains(line, "BANDWIDTH=") {
-				parts := strings.Split(line, "BANDWIDTH=")
-				if len(parts) > 1 {
-					val := strings.Split(parts[1], ",")[0]
-					currentBandwidth, _ = strconv.Atoi(val)
-				}
-			}
-
-			// Parse RESOLUTION
-			if strings.Contains(line, "RESOLUTION=") {
-				parts := strings.Split(line, "RESOLUTION=")
-				if len(parts) > 1 {
-					val := strings.Split(parts[1], ",")[0]
-					currentResolution = val
-					resParts := strings.Split(val, "x")
-					if len(resParts) == 2 {
-						_, _ = strconv.Atoi(resParts[0])
-						h, _ := strconv.Atoi(resParts[1])
-						currentHeight = h
-					}
-				}
-			}
-			continue
-		}
-
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		if isVariant && line != "" {
-			isVariant = false
-
-			// Resolve relative URL
-			var streamURL string
-			u, err := url.Parse(line)
-			if err == nil {
-				streamURL = baseURL.ResolveReference(u).String()
-			} else {
-				streamURL = line
-			}
-
-			streams = append(streams, StreamQuality{
-				URL:        streamURL,
-				Resolution: currentResolution,
-				Bandwidth:  currentBandwidth,
-				Height:     currentHeight,
-			})
-		}
+	// A default rendition without a URI means the audio is in the variant
+	if group := master.Group(hls.AUDIO, v.Audio); v.Audio != "" && len(group) > 0 {
+		q.AudioURL = group[0].URI
 	}
+	return q
+}
 
-	// Sort by Height desc, then Bandwidth desc
-	sort.Slice(streams, func(i, j int) bool {
-		if streams[i].Height != streams[j].Height {
-			return streams[i].Height > streams[j].Height
-		}
-		return streams[i].Bandwidth > streams[j].Bandwidth
-	})
-
-	return streams, nil
+// masterQualities lists the variants of a master playlist in its own order.
+func masterQualities(master *hls.Master) []StreamQuality {
+	variants := make([]StreamQuality, len(master.Variants))
+	for i, v := range master.Variants {
+		variants[i] = variantQuality(master, v)
+	}
+	return variants
 }
 
-func GetBestQualityM3U8(m3u8URL string, client *http.Client) (string, error) {
-	req, err := http.NewRequest("GET", m3u8URL, nil)
+// GetM3U8Streams lists the variants of the stream's master playlist, best
+// first, fetching it with the stream's referer and headers. A media playlist
+// has none.
+func GetM3U8Streams(ctx context.Context, stream *Stream, client *http.Client) ([]StreamQuality, error) {
+	req, err := stream.NewRequest(ctx, "GET", stream.URL())
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	master, _, err := fetchM3U8(req, client)
+	if err != nil || master == nil {
+		return nil, err
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	variants := append([]*hls.Variant(nil), master.Variants...)
+	hls.SortVariants(variants)
+	var streams []StreamQuality
+	for _, v := range variants {
+		streams = append(streams, variantQuality(master, v))
+	}
+	return streams, nil
+}
 
-	resp, err := client.Do(req)
+// GetBestQualityM3U8 returns the URL of the best variant of the stream's
+// master playlist, or the stream's own URL when it is a media playlist.
+func GetBestQualityM3U8(ctx context.Context, stream *Stream, client *http.Client) (string, error) {
+	req, err := stream.NewRequest(ctx, "GET", stream.URL())
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("failed to fetch m3u8: %d", resp.StatusCode)
-	}
-
-	baseURL, err := url.Parse(m3u8URL)
+	master, _, err := fetchM3U8(req, client)
 	if err != nil {
 		return "", err
 	}
-
-	scanner := bufio.NewScanner(resp.Body)
-
-	var bestURL string
-	var maxBandwidth int
-	var maxResolution int
-
-	var currentBandwidth int
-	var currentResolution int
-	var isVariant bool
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
-			isVariant = true
-			currentBandwidth = 0
-			currentResolution = 0
-
-			// Parse BANDWIDTH
-			if strings.Contains(line, "BANDWIDTH=") {
-				parts := strings.Split(line, "BANDWIDTH=")
-				if len(parts) > 1 {
-					val := strings.Split(parts[1], ",")[0]
-					currentBandwidth, _ = strconv.Atoi(val)
-				}
-			}
-
-			// Parse RESOLUTION
-			if strings.Contains(line, "RESOLUTION=") {
-				parts := strings.Split(line, "RESOLUTION=")
-				if len(parts) > 1 {
-					val := strings.Split(parts[1], ",")[0]
-					resParts := strings.Split(val, "x")
-					if len(resParts) == 2 {
-						w, _ := strconv.Atoi(resParts[0])
-						h, _ := strconv.Atoi(resParts[1])
-						currentResolution = w * h
-					}
-				}
-			}
-			continue
-		}
-
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		if isVariant && line != "" {
-			// This is the URL line for the variant
-			isVariant = false
-
-			// Simple heuristic: resolution > bandwidth
-			isBetter := false
-			if currentResolution > maxResolution {
-				isBetter = true
-			} else if currentResolution == maxResolution {
-				if currentBandwidth > maxBandwidth {
-					isBetter = true
-				}
-			}
-
-			if isBetter || bestURL == "" {
-				maxResolution = currentResolution
-				maxBandwidth = currentBandwidth
-
-				// Resolve relative URL
-				u, err := url.Parse(line)
-				if err == nil {
-					bestURL = baseURL.ResolveReference(u).String()
-				} else {
-					bestURL = line // Fallback
-				}
-			}
+	if master != nil {
+		if best := master.Best(); best != nil {
+			return best.URI, nil
 		}
 	}
-
-	if bestURL != "" {
-		return bestURL, nil
-	}
-
-	// If no variants found, it might be a simple media playlist or parsing failed
-	// Return original URL
-	return m3u8URL, nil
+	return stream.URL(), nil
 }
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetM3U8Streams(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Referer() != "https://embed.example/" || r.Header.Get("Origin") != "https://embed.example" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nlow.m3u8\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720\nhigh.m3u8\n")
+	}))
+	defer srv.Close()
+
+	stream := &Stream{
+		Variants:  []StreamQuality{{URL: srv.URL + "/master.m3u8"}},
+		Referer:   "https://embed.example/",
+		Headers:   map[string]string{"Origin": "https://embed.example"},
+		Container: ContainerHLS,
+	}
+	variants, err := GetM3U8Streams(context.Background(), stream, NewClient())
+	if err != nil || len(variants) != 2 || variants[0].URL != srv.URL+"/high.m3u8" || variants[1].Height != 360 {
+		t.Errorf("variants = %+v, %v", variants, err)
+	}
+	if best, err := GetBestQualityM3U8(context.Background(), stream, NewClient()); err != nil || best != srv.URL+"/high.m3u8" {
+		t.Errorf("best = %q, %v", best, err)
+	}
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type RefKind string
+
+const (
+	KindTitle   RefKind = "title"
+	KindSeason  RefKind = "season"
+	KindEpisode RefKind = "episode"
+	KindServer  RefKind = "server"
+)
+
+// MediaRef identifies a title, season, episode or server on a provider.
+// ID is the provider-native ID of the item itself; the native IDs of its
+// parents are kept in Native, keyed by the parent's kind (see Child).
+type MediaRef struct {
+	Provider string
+	Kind     RefKind
+	Type     MediaType
+	ID       string
+	Native   map[string]string
+	Season   int
+	Episode  int
+	TMDB     string
+	IMDb     string
+}
+
+// Child derives a ref one level down, remembering this ref's ID under its kind.
+func (r MediaRef) Child(kind RefKind, id string) MediaRef {
+	c := r
+	c.Kind = kind
+	c.ID = id
+	c.Native = make(map[string]string, len(r.Native)+1)
+	for k, v := range r.Native {
+		c.Native[k] = v
+	}
+	if r.ID != "" {
+		c.Native[string(r.Kind)] = r.ID
+	}
+	return c
+}
+
+// ParentID returns the native ID of the ancestor of the given kind.
+func (r MediaRef) ParentID(kind RefKind) string {
+	if r.Kind == kind {
+		return r.ID
+	}
+	return r.Native[string(kind)]
+}
+
+func (r MediaRef) IsZero() bool {
+	return r.Provider == "" && r.ID == ""
+}
+
+// String encodes the ref as "provider:kind?query". Query keys are sorted,
+// so the same ref always produces the same string. An unset ref is "".
+func (r MediaRef) String() string {
+	q := url.Values{}
+	if r.ID != "" {
+		q.Set("id", r.ID)
+	}
+	if r.Type != "" {
+		q.Set("type", string(r.Type))
+	}
+	if r.Season != 0 {
+		q.Set("s", strconv.Itoa(r.Season))
+	}
+	if r.Episode != 0 {
+		q.Set("e", strconv.Itoa(r.Episode))
+	}
+	if r.TMDB != "" {
+		q.Set("tmdb", r.TMDB)
+	}
+	if r.IMDb != "" {
+		q.Set("imdb", r.IMDb)
+	}
+	for k, v := range r.Native {
+		q.Set("n."+k, v)
+	}
+	if r.Provider == "" && r.Kind == "" && len(q) == 0 {
+		return ""
+	}
+	return r.Provider + ":" + string(r.Kind) + "?" + q.Encode()
+}
+
+func ParseMediaRef(s string) (MediaRef, error) {
+	if s == "" {
+		return MediaRef{}, nil
+	}
+	head, query, ok := strings.Cut(s, "?")
+	if !ok {
+		return MediaRef{}, fmt.Errorf("invalid media ref %q", s)
+	}
+	provider, kind, ok := strings.Cut(head, ":")
+	if !ok || provider == "" || kind == "" {
+		return MediaRef{}, fmt.Errorf("invalid media ref %q", s)
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return MediaRef{}, fmt.Errorf("invalid media ref %q: %w", s, err)
+	}
+
+	r := MediaRef{
+		Provider: provider,
+		Kind:     RefKind(kind),
+		Type:     MediaType(q.Get("type")),
+		ID:       q.Get("id"),
+		TMDB:     q.Get("tmdb"),
+		IMDb:     q.Get("imdb"),
+	}
+	if v := q.Get("s"); v != "" {
+		if r.Season, err = strconv.Atoi(v); err != nil {
+			return MediaRef{}, fmt.Errorf("invalid season in media ref %q", s)
+		}
+	}
+	if v := q.Get("e"); v != "" {
+		if r.Episode, err = strconv.Atoi(v); err != nil {
+			return MediaRef{}, fmt.Errorf("invalid episode in media ref %q", s)
+		}
+	}
+	for k := range q {
+		if name, ok := strings.CutPrefix(k, "n."); ok {
+			if r.Native == nil {
+				r.Native = map[string]string{}
+			}
+			r.Native[name] = q.Get(k)
+		}
+	}
+	return r, nil
+}
+
+func (r MediaRef) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *MediaRef) UnmarshalText(text []byte) error {
+	parsed, err := ParseMediaRef(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMediaRefRoundTrip(t *testing.T) {
+	title := MediaRef{Provider: "flixhq", Kind: KindTitle, Type: Series, ID: "tv/watch-dark-19470", TMDB: "70523", IMDb: "tt5753856"}
+	season := title.Child(KindSeason, "39428")
+	season.Season = 2
+	episode := season.Child(KindEpisode, "a|b:c?d&e=f%20g")
+	episode.Episode = 5
+	server := episode.Child(KindServer, "https://embed.example/e/1?x=1&y=%2F")
+
+	for _, ref := range []MediaRef{
+		{},
+		title,
+		season,
+		episode,
+		server,
+		{Provider: "hdrezka", Kind: KindServer, ID: "238", Native: map[string]string{"title": "12:34|5", "translator": "100%"}},
+	} {
+		s := ref.String()
+		parsed, err := ParseMediaRef(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, ref) {
+			t.Errorf("%q parsed as %+v, want %+v", s, parsed, ref)
+		}
+		if again := parsed.String(); again != s {
+			t.Errorf("%q encoded again as %q", s, again)
+		}
+	}
+
+	if server.ParentID(KindTitle) != "tv/watch-dark-19470" || server.ParentID(KindEpisode) != "a|b:c?d&e=f%20g" || server.ParentID(KindServer) != server.ID {
+		t.Errorf("parent IDs lost: %+v", server.Native)
+	}
+	if (MediaRef{}).String() != "" {
+		t.Errorf("zero ref = %q", MediaRef{}.String())
+	}
+
+	// Structs holding refs, set or not, survive JSON
+	type entry struct {
+		Ref    MediaRef
+		Parent MediaRef
+	}
+	data, err := json.Marshal(entry{Ref: server})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got entry
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entry{Ref: server}) {
+		t.Errorf("JSON round trip = %+v", got)
+	}
+
+	for _, bad := range []string{"flixhq", "flixhq:title", ":title?id=1", "flixhq:episode?e=x"} {
+		if _, err := ParseMediaRef(bad); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
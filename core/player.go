@@ -1,10 +1,13 @@
 package core
 
 import (
+	"bufio"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 )
 
@@ -21,7 +24,34 @@ func checkAndroid() bool {
 	return strings.TrimSpace(string(output)) == "Android"
 }
 
-func Play(url, title, referer, userAgent string, subtitles []string, debug bool) error {
+func Play(stream *Stream, title string, debug bool) error {
+	cfg := LoadConfig()
+	android := checkAndroid()
+
+	// Players that cannot send the stream's headers get it through a
+	// localhost proxy that sends them instead
+	sendsHeaders := playerSendsHeaders(cfg.Player, runtime.GOOS, android, stream)
+	var proxy *PlayerProxy
+	if usePlayerProxy(cfg.PlayerProxy, sendsHeaders, stream) {
+		var err error
+		if proxy, err = StartPlayerProxy(NewClient(), stream); err != nil {
+			return err
+		}
+		defer proxy.Close()
+		proxy.Debug = debug
+		stream = proxy.Proxied()
+		if debug {
+			fmt.Printf("Proxying the stream through %s\n", stream.URL())
+		}
+	}
+
+	url := stream.URL()
+	referer := stream.Referer
+	userAgent := stream.UserAgent
+	subtitles := stream.SubtitleURLs()
+	// Subtitles come in order of preference; players also get the languages
+	// so they pick the right one among tracks inside the stream
+	langs := strings.Join(SubtitleLanguages(stream.Subtitles), ",")
 
 	if runtime.GOOS == "windows" {
 		mpv_executable = "mpv.exe"
@@ -35,7 +65,7 @@ func Play(url, title, referer, userAgent string, subtitles []string, debug bool)
 
 	var cmd *exec.Cmd
 
-	if checkAndroid() {
+	if android {
 		fmt.Println("~ Android Detected ~")
 		args := []string{
 			"start",
@@ -57,7 +87,13 @@ func Play(url, title, referer, userAgent string, subtitles []string, debug bool)
 		if debug {
 			fmt.Printf("Starting VLC on Android for %s...\n", title)
 		}
-		return cmd.Run()
+		if err := cmd.Run(); err != nil || proxy == nil {
+			return err
+		}
+		// am returns as soon as VLC starts, so there is no exit to wait for
+		fmt.Print("Press Enter when done watching to stop the stream proxy...")
+		bufio.NewReader(os.Stdin).ReadString('\n')
+		return nil
 	}
 
 	switch runtime.GOOS {
@@ -73,10 +109,13 @@ func Play(url, title, referer, userAgent string, subtitles []string, debug bool)
 		for _, sub := range subtitles {
 			args = append(args, fmt.Sprintf("--mpv-sub-files=%s", sub))
 		}
+		if langs != "" {
+			args = append(args, fmt.Sprintf("--mpv-slang=%s", langs))
+		}
+		args = append(args, mpvHeaderArgs("--mpv-", stream.Headers)...)
 		cmd = exec.Command("iina", args...)
 
 	default:
-		cfg := LoadConfig()
 		if cfg.Player == "vlc" {
 			args := []string{
 				url,
@@ -93,10 +132,22 @@ func Play(url, title, referer, userAgent string, subtitles []string, debug bool)
 					}
 				}
 			}
+			if langs != "" {
+				args = append(args, fmt.Sprintf("--sub-language=%s", langs))
+			}
 
 			cmd = exec.Command(vlc_executable, args...)
 		} else if cfg.Player == "mpc-be" {
-			cmd = exec.Command(mpc_executable, url)
+			args := []string{url}
+			if proxy != nil {
+				// A running mpc-be would take the file over and let this
+				// process exit, taking the proxy down with it
+				args = append(args, "/new")
+			}
+			for _, sub := range subtitles {
+				args = append(args, "/sub", sub)
+			}
+			cmd = exec.Command(mpc_executable, args...)
 		} else {
 			// Default to mpv
 			args := []string{
@@ -110,6 +161,10 @@ func Play(url, title, referer, userAgent string, subtitles []string, debug bool)
 					args = append(args, fmt.Sprintf("--sub-file=%s", sub))
 				}
 			}
+			if langs != "" {
+				args = append(args, fmt.Sprintf("--slang=%s", langs))
+			}
+			args = append(args, mpvHeaderArgs("--", stream.Headers)...)
 
 			cmd = exec.Command(mpv_executable, args...)
 		}
@@ -127,3 +182,32 @@ func Play(url, title, referer, userAgent string, subtitles []string, debug bool)
 	fmt.Printf("Starting player for %s...\n", title)
 	return cmd.Run()
 }
+
+// playerSendsHeaders tells whether the player luffy starts can send the
+// stream's referer, user agent and headers itself. mpc-be and the Android VLC
+// intent send none, and VLC only a referer and user agent.
+func playerSendsHeaders(player, goos string, android bool, stream *Stream) bool {
+	switch {
+	case android:
+		return false
+	case goos == "darwin":
+		// iina hands its --mpv- options on to mpv
+		return true
+	case player == "mpc-be":
+		return false
+	case player == "vlc":
+		return len(stream.Headers) == 0
+	}
+	return true
+}
+
+// mpvHeaderArgs passes each header in its own option, sorted by name: values
+// such as cookies may hold commas, which would split a single
+// --http-header-fields list. The prefix is "--" for mpv and "--mpv-" for iina.
+func mpvHeaderArgs(prefix string, headers map[string]string) []string {
+	var args []string
+	for _, k := range slices.Sorted(maps.Keys(headers)) {
+		args = append(args, fmt.Sprintf("%shttp-header-fields-append=%s: %s", prefix, k, headers[k]))
+	}
+	return args
+}
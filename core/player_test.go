@@ -0,0 +1,42 @@
+package core
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMpvHeaderArgs(t *testing.T) {
+	got := mpvHeaderArgs("--", map[string]string{"Origin": "https://embed.example", "Cookie": "a=1, b=2"})
+	want := []string{"--http-header-fields-append=Cookie: a=1, b=2", "--http-header-fields-append=Origin: https://embed.example"}
+	if !slices.Equal(got, want) {
+		t.Errorf("args = %q", got)
+	}
+	if got := mpvHeaderArgs("--mpv-", map[string]string{"Origin": "https://embed.example"}); !slices.Equal(got, []string{"--mpv-http-header-fields-append=Origin: https://embed.example"}) {
+		t.Errorf("iina args = %q", got)
+	}
+	if got := mpvHeaderArgs("--", nil); got != nil {
+		t.Errorf("no headers: %q", got)
+	}
+}
+
+func TestPlayerSendsHeaders(t *testing.T) {
+	referer := &Stream{Referer: "https://embed.example/"}
+	headers := &Stream{Referer: "https://embed.example/", Headers: map[string]string{"Origin": "https://embed.example"}}
+	for _, tc := range []struct {
+		player, goos string
+		android      bool
+		stream       *Stream
+		want         bool
+	}{
+		{"mpv", "linux", false, headers, true},
+		{"vlc", "linux", false, referer, true},
+		{"vlc", "windows", false, headers, false},
+		{"mpc-be", "windows", false, referer, false},
+		{"mpc-be", "darwin", false, headers, true},
+		{"mpv", "linux", true, referer, false},
+	} {
+		if got := playerSendsHeaders(tc.player, tc.goos, tc.android, tc.stream); got != tc.want {
+			t.Errorf("%s on %s, android %v, %d headers: %v", tc.player, tc.goos, tc.android, len(tc.stream.Headers), got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/demonkingswarn/luffy/core/hls"
+)
+
+// Player proxy modes, see Config.PlayerProxy.
+const (
+	PLAYER_PROXY_AUTO   = "auto"
+	PLAYER_PROXY_ALWAYS = "always"
+	PLAYER_PROXY_NEVER  = "never"
+)
+
+// Headers copied from the player's request to the host and back.
+var (
+	proxyRequestHeaders  = []string{"Range", "If-Range"}
+	proxyResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "Etag"}
+)
+
+// PlayerProxy serves a stream on localhost for players that cannot send the
+// referer, user agent and headers its host expects, such as mpc-be and VLC
+// on Android. Playlists are rewritten so that their variants, segments and
+// keys also go through the proxy.
+type PlayerProxy struct {
+	Client *http.Client
+	Stream *Stream
+	Debug  bool
+
+	// prefix is a random first path element, so that other programs on the
+	// machine cannot use the proxy to reach arbitrary hosts
+	prefix   string
+	listener net.Listener
+	server   *http.Server
+}
+
+// StartPlayerProxy listens on a free localhost port and serves the stream
+// until Close.
+func StartPlayerProxy(client *http.Client, stream *Stream) (*PlayerProxy, error) {
+	token := make([]byte, 12)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("player proxy: %w", err)
+	}
+	p := &PlayerProxy{
+		Client:   client,
+		Stream:   stream,
+		prefix:   hex.EncodeToString(token),
+		listener: listener,
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	go p.server.Serve(listener)
+	return p, nil
+}
+
+// URL returns the proxy URL of a link of the stream. The last path element
+// of the link is kept so that players can still guess the format from it.
+func (p *PlayerProxy) URL(link string) string {
+	name := path.Base(strings.SplitN(strings.SplitN(link, "?", 2)[0], "#", 2)[0])
+	if name == "." || name == "/" || strings.Contains(name, ":") {
+		name = "stream"
+	}
+	return fmt.Sprintf("http://%s/%s/%s/%s", p.listener.Addr(), p.prefix, base64.RawURLEncoding.EncodeToString([]byte(link)), name)
+}
+
+// Proxied returns a copy of the stream whose variant, audio and subtitle URLs
+// go through the proxy.
+func (p *PlayerProxy) Proxied() *Stream {
+	c := *p.Stream
+	c.Variants = append([]StreamQuality(nil), c.Variants...)
+	for i := range c.Variants {
+		c.Variants[i].URL = p.proxyURL(c.Variants[i].URL)
+		c.Variants[i].AudioURL = p.proxyURL(c.Variants[i].AudioURL)
+	}
+	c.Audio = append([]AudioTrack(nil), c.Audio...)
+	for i := range c.Audio {
+		c.Audio[i].URL = p.proxyURL(c.Audio[i].URL)
+	}
+	c.Subtitles = append([]Subtitle(nil), c.Subtitles...)
+	for i := range c.Subtitles {
+		c.Subtitles[i].URL = p.proxyURL(c.Subtitles[i].URL)
+	}
+	return &c
+}
+
+// proxyURL proxies http(s) links and leaves local files, data: URIs and
+// key schemes such as skd:// alone.
+func (p *PlayerProxy) proxyURL(link string) string {
+	if !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+		return link
+	}
+	return p.URL(link)
+}
+
+// Close stops the proxy, cutting off any request still being served.
+func (p *PlayerProxy) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.server.Shutdown(ctx); err != nil {
+		return p.server.Close()
+	}
+	return nil
+}
+
+func (p *PlayerProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/"+p.prefix+"/")
+	encoded, _, _ := strings.Cut(rest, "/")
+	target, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		http.NotFound(w, r)
+		return
+	}
+	link := string(target)
+
+	req, err := p.Stream.NewRequest(r.Context(), r.Method, link)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, h := range proxyRequestHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		if p.Debug && r.Context().Err() == nil {
+			fmt.Printf("Player proxy: %v\n", err)
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if p.Debug && resp.StatusCode >= 400 {
+		fmt.Printf("Player proxy: %s answered %s\n", link, resp.Status)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	if resp.StatusCode == http.StatusOK && isPlaylist(body) {
+		// Relative URIs resolve against where the playlist ended up after redirects
+		var out bytes.Buffer
+		if err := hls.Rewrite(&out, body, resp.Request.URL.String(), p.proxyURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Content-Length", fmt.Sprint(out.Len()))
+		w.WriteHeader(http.StatusOK)
+		w.Write(out.Bytes())
+		return
+	}
+
+	for _, h := range proxyResponseHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, body); err != nil && p.Debug && r.Context().Err() == nil {
+		fmt.Printf("Player proxy: %s: %v\n", link, err)
+	}
+}
+
+// isPlaylist looks at the start of a body for #EXTM3U, as hosts often serve
+// playlists with a wrong extension or content type.
+func isPlaylist(body *bufio.Reader) bool {
+	head, _ := body.Peek(16)
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\ufeff")), " \t\r\n")
+	return bytes.HasPrefix(head, []byte("#EXTM3U"))
+}
+
+// usePlayerProxy tells whether to proxy a stream for a player, given the
+// player_proxy mode and whether the player can send headers itself. Pages
+// are never proxied, as the player hands them to yt-dlp, and luffy's own user
+// agent alone is not worth proxying for.
+func usePlayerProxy(mode string, sendsHeaders bool, stream *Stream) bool {
+	if stream.Container == ContainerPage {
+		return false
+	}
+	switch strings.ToLower(mode) {
+	case PLAYER_PROXY_ALWAYS:
+		return true
+	case PLAYER_PROXY_NEVER, "off", "false":
+		return false
+	}
+	needsHeaders := stream.Referer != "" || (stream.UserAgent != "" && stream.UserAgent != UserAgent()) || len(stream.Headers) > 0
+	return !sendsHeaders && needsHeaders
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlayerProxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Referer() != "https://embed.example/" || r.Header.Get("Origin") != "https://embed.example" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/master.m3u8":
+			http.Redirect(w, r, "/hls/master.m3u8", http.StatusFound)
+		case "/hls/master.m3u8":
+			// Served as text, as some hosts do
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"a\",NAME=\"en\",URI=\"audio.m3u8\"\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO=\"a\"\nv/index.m3u8?token=1\n")
+		case "/hls/v/index.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"/key\"\n#EXTINF:6,\nseg0.jpg\n#EXT-X-ENDLIST\n")
+		case "/hls/v/seg0.jpg":
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("segment data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	stream := &Stream{
+		Variants:  []StreamQuality{{URL: srv.URL + "/master.m3u8"}},
+		Referer:   "https://embed.example/",
+		Headers:   map[string]string{"Origin": "https://embed.example"},
+		Subtitles: []Subtitle{{URL: "/home/user/movie.srt"}},
+		Container: ContainerHLS,
+	}
+	proxy, err := StartPlayerProxy(NewClient(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	proxied := proxy.Proxied()
+	if !strings.HasPrefix(proxied.URL(), "http://127.0.0.1:") || !strings.HasSuffix(proxied.URL(), "/master.m3u8") {
+		t.Errorf("proxied URL = %q", proxied.URL())
+	}
+	if proxied.Subtitles[0].URL != "/home/user/movie.srt" || stream.URL() != srv.URL+"/master.m3u8" {
+		t.Error("local subtitle or original stream was changed")
+	}
+
+	// The player sends no headers at all
+	get := func(link string, header ...string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, link, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	_, master := get(proxied.URL())
+	want := proxy.URL(srv.URL + "/hls/v/index.m3u8?token=1")
+	if !strings.Contains(master, "\n"+want+"\n") || !strings.Contains(master, `URI="`+proxy.URL(srv.URL+"/hls/audio.m3u8")+`"`) {
+		t.Fatalf("master playlist not rewritten:\n%s", master)
+	}
+	_, media := get(want)
+	if !strings.Contains(media, proxy.URL(srv.URL+"/key")) {
+		t.Errorf("key URI not rewritten:\n%s", media)
+	}
+	resp, segment := get(proxy.URL(srv.URL+"/hls/v/seg0.jpg"), "Range", "bytes=8-")
+	if resp.StatusCode != http.StatusPartialContent || segment != "data" {
+		t.Errorf("segment = %d %q", resp.StatusCode, segment)
+	}
+
+	if resp, _ := get(strings.Replace(proxied.URL(), proxy.prefix, "wrong", 1)); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("request without the prefix answered %d", resp.StatusCode)
+	}
+}
+
+func TestUsePlayerProxy(t *testing.T) {
+	withReferer := &Stream{Referer: "https://embed.example/"}
+	for _, tc := range []struct {
+		mode         string
+		sendsHeaders bool
+		stream       *Stream
+		want         bool
+	}{
+		{"auto", false, withReferer, true},
+		{"auto", true, withReferer, false},
+		{"auto", false, &Stream{}, false},
+		{"always", true, &Stream{}, true},
+		{"never", false, withReferer, false},
+		{"auto", false, &Stream{UserAgent: UserAgent()}, false},
+		{"auto", false, &Stream{UserAgent: "Mozilla/5.0 (Embed)"}, true},
+		{"auto", false, &Stream{Referer: "https://www.youtube.com/", Container: ContainerPage}, false},
+		{"always", false, &Stream{Container: ContainerPage}, false},
+	} {
+		if got := usePlayerProxy(tc.mode, tc.sendsHeaders, tc.stream); got != tc.want {
+			t.Errorf("%s, sends headers %v: %v", tc.mode, tc.sendsHeaders, got)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package core
 
+import "context"
+
+// Provider walks a site from a search query down to a playable stream.
+// GetEpisodes lists the episodes of a season ref; given a movie's title ref
+// it lists the servers the movie can be played from instead.
 type Provider interface {
-	Search(query string) ([]SearchResult, error)
-	GetMediaID(url string) (string, error)
-	GetSeasons(mediaID string) ([]Season, error)
-	GetEpisodes(id string, isSeason bool) ([]Episode, error)
-	GetServers(episodeID string) ([]Server, error)
-	GetLink(serverID string) (string, error)
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+	GetMediaID(ctx context.Context, url string) (MediaRef, error)
+	GetSeasons(ctx context.Context, media MediaRef) ([]Season, error)
+	GetEpisodes(ctx context.Context, ref MediaRef) ([]Episode, error)
+	GetServers(ctx context.Context, episode MediaRef) ([]Server, error)
+	GetLink(ctx context.Context, server MediaRef) (*Stream, error)
 }
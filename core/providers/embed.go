@@ -0,0 +1,173 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/demonkingswarn/luffy/core"
+)
+
+// EmbedServer is one embed host of an aggregator. Movie and TV are URL
+// templates; {tmdb}, {imdb}, {season} and {episode} are filled in from the ref.
+// A server without a template for a media type is not offered for it.
+type EmbedServer struct {
+	ID    string
+	Name  string
+	Movie string
+	TV    string
+}
+
+// EmbedSite describes a vidsrc-style aggregator: titles come from TMDB and
+// every server is an embed URL built from the TMDB ID.
+type EmbedSite struct {
+	Name    string
+	BaseURL string
+	// Page is the template of the site's title page, filled with {base},
+	// {type} ("movie" or "tv") and {tmdb}. GetMediaID reads it back from
+	// either an id/type query or a /<type>/<id> path.
+	Page    string
+	Servers []EmbedServer
+}
+
+type EmbedProvider struct {
+	Client  *http.Client
+	BaseURL string
+	Catalog *core.TMDBCatalog
+	Site    EmbedSite
+}
+
+func NewEmbedProvider(client *http.Client, site EmbedSite) *EmbedProvider {
+	return &EmbedProvider{
+		Client:  client,
+		BaseURL: core.SiteURL(site.Name),
+		Catalog: core.NewTMDBCatalog(client),
+		Site:    site,
+	}
+}
+
+// RegisterEmbedSite registers an aggregator as a provider.
+func RegisterEmbedSite(site EmbedSite) {
+	core.RegisterSite(site.Name, site.BaseURL)
+	core.RegisterProvider(core.ProviderInfo{
+		Name: site.Name,
+		Capabilities: core.Capabilities{
+			NeedsDecryption: true,
+			SupportsSeries:  true,
+		},
+		New: func(client *http.Client) core.Provider {
+			return NewEmbedProvider(client, site)
+		},
+	})
+}
+
+func (e *EmbedProvider) Search(ctx context.Context, query string) ([]core.SearchResult, error) {
+	results, err := e.Catalog.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		mediaType := "movie"
+		if results[i].Type == core.Series {
+			mediaType = "tv"
+		}
+		results[i].URL = strings.NewReplacer(
+			"{base}", e.BaseURL,
+			"{type}", mediaType,
+			"{tmdb}", results[i].TMDB,
+		).Replace(e.Site.Page)
+	}
+	return results, nil
+}
+
+func (e *EmbedProvider) GetMediaID(ctx context.Context, urlStr string) (core.MediaRef, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return core.MediaRef{}, err
+	}
+
+	id, mediaType := u.Query().Get("id"), u.Query().Get("type")
+	if id == "" {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) >= 2 {
+			mediaType, id = parts[len(parts)-2], parts[len(parts)-1]
+		}
+	}
+	if _, err := strconv.Atoi(id); err != nil || (mediaType != "movie" && mediaType != "tv") {
+		return core.MediaRef{}, fmt.Errorf("invalid %s url", e.Site.Name)
+	}
+
+	ref := core.MediaRef{Provider: e.Site.Name, Kind: core.KindTitle, Type: core.Movie, ID: id, TMDB: id}
+	if mediaType == "tv" {
+		ref.Type = core.Series
+	}
+	return ref, nil
+}
+
+func (e *EmbedProvider) GetSeasons(ctx context.Context, media core.MediaRef) ([]core.Season, error) {
+	if media.Type == core.Movie {
+		return nil, nil
+	}
+	return e.Catalog.Seasons(ctx, media)
+}
+
+func (e *EmbedProvider) GetEpisodes(ctx context.Context, ref core.MediaRef) ([]core.Episode, error) {
+	if ref.Kind == core.KindSeason {
+		return e.Catalog.Episodes(ctx, ref)
+	}
+
+	// Movies list their servers in place of episodes
+	servers, err := e.GetServers(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	var episodes []core.Episode
+	for _, s := range servers {
+		episodes = append(episodes, core.Episode{Ref: s.Ref, Name: s.Name})
+	}
+	return episodes, nil
+}
+
+func (e *EmbedProvider) GetServers(ctx context.Context, episode core.MediaRef) ([]core.Server, error) {
+	var servers []core.Server
+	for _, s := range e.Site.Servers {
+		if e.template(s, episode.Type) != "" {
+			servers = append(servers, core.Server{Ref: episode.Child(core.KindServer, s.ID), Name: s.Name})
+		}
+	}
+	return servers, nil
+}
+
+func (e *EmbedProvider) GetLink(ctx context.Context, server core.MediaRef) (*core.Stream, error) {
+	if server.TMDB == "" {
+		return nil, fmt.Errorf("invalid server ref")
+	}
+
+	for _, s := range e.Site.Servers {
+		if s.ID != server.ID {
+			continue
+		}
+		tmpl := e.template(s, server.Type)
+		if tmpl == "" {
+			return nil, fmt.Errorf("server %s has no %s link", s.Name, server.Type)
+		}
+		link := strings.NewReplacer(
+			"{tmdb}", server.TMDB,
+			"{imdb}", server.IMDb,
+			"{season}", strconv.Itoa(server.Season),
+			"{episode}", strconv.Itoa(server.Episode),
+		).Replace(tmpl)
+		return core.EmbedStream(link), nil
+	}
+	return nil, fmt.Errorf("unknown server: %s", server.ID)
+}
+
+func (e *EmbedProvider) template(s EmbedServer, mediaType core.MediaType) string {
+	if mediaType == core.Series {
+		return s.TV
+	}
+	return s.Movie
+}
@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -17,25 +18,38 @@ import (
 )
 
 const (
-	HDREZKA_BASE_URL       = "https://hdrezka.website"
-	HDREZKA_AJAX_URL       = "https://hdrezka.website/ajax/get_cdn_series/"
-	HDREZKA_AJAX_MOVIE_URL = "https://hdrezka.website/ajax/get_cdn_movie/"
+	HDREZKA_BASE_URL = "https://hdrezka.website"
 )
 
 type HDRezka struct {
-	Client *http.Client
+	Client  *http.Client
+	BaseURL string
 }
 
 func NewHDRezka(client *http.Client) *HDRezka {
-	return &HDRezka{Client: client}
+	return &HDRezka{Client: client, BaseURL: core.SiteURL("hdrezka")}
 }
 
-func (h *HDRezka) newRequest(method, urlStr string, body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequest(method, urlStr, body)
+func init() {
+	core.RegisterSite("hdrezka", HDREZKA_BASE_URL)
+	core.RegisterProvider(core.ProviderInfo{
+		Name:    "hdrezka",
+		Aliases: []string{"rezka"},
+		Capabilities: core.Capabilities{
+			SupportsSeries: true,
+			Referer:        core.RefererPage,
+		},
+		New: func(client *http.Client) core.Provider {
+			return NewHDRezka(client)
+		},
+	})
+}
+
+func (h *HDRezka) newRequest(ctx context.Context, method, urlStr string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 	req.Header.Set("X-Requested-With", "XMLHttpRequest")
 	if body != nil {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -43,11 +57,11 @@ func (h *HDRezka) newRequest(method, urlStr string, body io.Reader) (*http.Reque
 	return req, nil
 }
 
-func (h *HDRezka) Search(query string) ([]core.SearchResult, error) {
+func (h *HDRezka) Search(ctx context.Context, query string) ([]core.SearchResult, error) {
 	encodedQuery := url.QueryEscape(query)
-	searchURL := fmt.Sprintf("%s/search/?q=%s", HDREZKA_BASE_URL, encodedQuery)
+	searchURL := fmt.Sprintf("%s/search/?q=%s", h.BaseURL, encodedQuery)
 
-	req, _ := h.newRequest("GET", searchURL, nil)
+	req, _ := h.newRequest(ctx, "GET", searchURL, nil)
 	resp, err := h.Client.Do(req)
 	if err != nil {
 		return nil, err
@@ -98,15 +112,15 @@ func (h *HDRezka) Search(query string) ([]core.SearchResult, error) {
 	return results, nil
 }
 
-func (h *HDRezka) GetMediaID(urlStr string) (string, error) {
+func (h *HDRezka) GetMediaID(ctx context.Context, urlStr string) (core.MediaRef, error) {
 	if strings.HasPrefix(urlStr, "/") {
-		urlStr = HDREZKA_BASE_URL + urlStr
+		urlStr = h.BaseURL + urlStr
 	}
-	return urlStr, nil
+	return core.MediaRef{Provider: "hdrezka", Kind: core.KindTitle, ID: urlStr}, nil
 }
 
-func (h *HDRezka) GetSeasons(mediaID string) ([]core.Season, error) {
-	req, _ := h.newRequest("GET", mediaID, nil)
+func (h *HDRezka) GetSeasons(ctx context.Context, media core.MediaRef) ([]core.Season, error) {
+	req, _ := h.newRequest(ctx, "GET", media.ID, nil)
 	resp, err := h.Client.Do(req)
 	if err != nil {
 		return nil, err
@@ -123,26 +137,39 @@ func (h *HDRezka) GetSeasons(mediaID string) ([]core.Season, error) {
 		id := s.AttrOr("data-tab_id", "")
 		name := strings.TrimSpace(s.Text())
 		if id != "" {
-			seasons = append(seasons, core.Season{ID: mediaID + "|" + id, Name: name})
+			ref := media.Child(core.KindSeason, id)
+			ref.Season, _ = strconv.Atoi(id)
+			seasons = append(seasons, core.Season{Ref: ref, Name: name})
 		}
 	})
 
 	if len(seasons) == 0 {
-		seasons = append(seasons, core.Season{ID: mediaID + "|1", Name: "Season 1"})
+		ref := media.Child(core.KindSeason, "1")
+		ref.Season = 1
+		seasons = append(seasons, core.Season{Ref: ref, Name: "Season 1"})
 	}
 
 	return seasons, nil
 }
 
-func (h *HDRezka) GetEpisodes(id string, isSeason bool) ([]core.Episode, error) {
-	parts := strings.Split(id, "|")
-	if len(parts) < 2 {
-		return nil, errors.New("invalid id format")
+func (h *HDRezka) GetEpisodes(ctx context.Context, ref core.MediaRef) ([]core.Episode, error) {
+	if ref.Kind != core.KindSeason {
+		// Movies have no episode list; their translators act as servers
+		servers, err := h.GetServers(ctx, ref.Child(core.KindEpisode, "1"))
+		if err != nil {
+			return nil, err
+		}
+		var episodes []core.Episode
+		for _, srv := range servers {
+			episodes = append(episodes, core.Episode{Ref: srv.Ref, Name: srv.Name})
+		}
+		return episodes, nil
 	}
-	urlStr := parts[0]
-	seasonID := parts[1]
 
-	req, _ := h.newRequest("GET", urlStr, nil)
+	urlStr := ref.ParentID(core.KindTitle)
+	seasonID := ref.ID
+
+	req, _ := h.newRequest(ctx, "GET", urlStr, nil)
 	resp, err := h.Client.Do(req)
 	if err != nil {
 		return nil, err
@@ -163,28 +190,27 @@ func (h *HDRezka) GetEpisodes(id string, isSeason bool) ([]core.Episode, error)
 		if epId == "" {
 			epId = strconv.Itoa(i + 1)
 		}
-		compositeID := fmt.Sprintf("%s|%s|%s", urlStr, seasonID, epId)
-		episodes = append(episodes, core.Episode{ID: compositeID, Name: name})
+		epRef := ref.Child(core.KindEpisode, epId)
+		epRef.Episode, _ = strconv.Atoi(epId)
+		episodes = append(episodes, core.Episode{Ref: epRef, Name: name})
 	})
 
 	if len(episodes) == 0 {
-		compositeID := fmt.Sprintf("%s|%s|%s", urlStr, "1", "1")
-		episodes = append(episodes, core.Episode{ID: compositeID, Name: "Full Movie"})
+		epRef := ref.Child(core.KindEpisode, "1")
+		epRef.Episode = 1
+		episodes = append(episodes, core.Episode{Ref: epRef, Name: "Full Movie"})
 	}
 
 	return episodes, nil
 }
 
-func (h *HDRezka) GetServers(episodeID string) ([]core.Server, error) {
-	parts := strings.Split(episodeID, "|")
-	if len(parts) < 3 {
-		return nil, errors.New("invalid episode id")
+func (h *HDRezka) GetServers(ctx context.Context, episode core.MediaRef) ([]core.Server, error) {
+	urlStr := episode.ParentID(core.KindTitle)
+	if urlStr == "" {
+		return nil, errors.New("invalid episode ref")
 	}
-	urlStr := parts[0]
-	season := parts[1]
-	episode := parts[2]
 
-	req, _ := h.newRequest("GET", urlStr, nil)
+	req, _ := h.newRequest(ctx, "GET", urlStr, nil)
 	resp, err := h.Client.Do(req)
 	if err != nil {
 		return nil, err
@@ -205,8 +231,7 @@ func (h *HDRezka) GetServers(episodeID string) ([]core.Server, error) {
 		}
 
 		if tID != "" {
-			srvID := fmt.Sprintf("%s|%s|%s|%s", urlStr, season, episode, tID)
-			servers = append(servers, core.Server{ID: srvID, Name: name})
+			servers = append(servers, core.Server{Ref: episode.Child(core.KindServer, tID), Name: name})
 		}
 	})
 
@@ -215,16 +240,12 @@ func (h *HDRezka) GetServers(episodeID string) ([]core.Server, error) {
 		re := regexp.MustCompile(`initCDNSeriesEvents\(\d+,\s*(\d+)`)
 		matches := re.FindStringSubmatch(html)
 		if len(matches) > 1 {
-			tID := matches[1]
-			srvID := fmt.Sprintf("%s|%s|%s|%s", urlStr, season, episode, tID)
-			servers = append(servers, core.Server{ID: srvID, Name: "Default"})
+			servers = append(servers, core.Server{Ref: episode.Child(core.KindServer, matches[1]), Name: "Default"})
 		} else {
 			reMovie := regexp.MustCompile(`initCDNMoviesEvents\(\d+,\s*(\d+)`)
 			matchesMovie := reMovie.FindStringSubmatch(html)
 			if len(matchesMovie) > 1 {
-				tID := matchesMovie[1]
-				srvID := fmt.Sprintf("%s|%s|%s|%s", urlStr, season, episode, tID)
-				servers = append(servers, core.Server{ID: srvID, Name: "Default"})
+				servers = append(servers, core.Server{Ref: episode.Child(core.KindServer, matchesMovie[1]), Name: "Default"})
 			}
 		}
 	}
@@ -232,29 +253,31 @@ func (h *HDRezka) GetServers(episodeID string) ([]core.Server, error) {
 	return servers, nil
 }
 
-func (h *HDRezka) GetLink(serverID string) (string, error) {
-	parts := strings.Split(serverID, "|")
-	if len(parts) < 4 {
-		return "", errors.New("invalid server id")
+func (h *HDRezka) GetLink(ctx context.Context, server core.MediaRef) (*core.Stream, error) {
+	urlStr := server.ParentID(core.KindTitle)
+	if urlStr == "" {
+		return nil, errors.New("invalid server ref")
+	}
+	season := server.ParentID(core.KindSeason)
+	if season == "" {
+		season = "1"
 	}
-	urlStr := parts[0]
-	season := parts[1]
-	episode := parts[2]
-	translatorID := parts[3]
+	episode := server.ParentID(core.KindEpisode)
+	translatorID := server.ID
 
 	re := regexp.MustCompile(`\/(\d+)-`)
 	matches := re.FindStringSubmatch(urlStr)
 	if len(matches) < 2 {
-		return "", errors.New("could not extract id from url")
+		return nil, errors.New("could not extract id from url")
 	}
 	id := matches[1]
 
 	action := "get_stream"
-	endpoint := HDREZKA_AJAX_URL
+	endpoint := h.BaseURL + "/ajax/get_cdn_series/"
 
 	if strings.Contains(urlStr, "/films/") {
 		action = "get_movie_stream"
-		endpoint = HDREZKA_AJAX_MOVIE_URL
+		endpoint = h.BaseURL + "/ajax/get_cdn_movie/"
 	}
 
 	vals := url.Values{}
@@ -267,12 +290,12 @@ func (h *HDRezka) GetLink(serverID string) (string, error) {
 		vals.Set("episode", episode)
 	}
 
-	req, _ := h.newRequest("POST", endpoint, strings.NewReader(vals.Encode()))
+	req, _ := h.newRequest(ctx, "POST", endpoint, strings.NewReader(vals.Encode()))
 	req.Header.Set("Referer", urlStr)
 
 	resp, err := h.Client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -284,13 +307,13 @@ func (h *HDRezka) GetLink(serverID string) (string, error) {
 
 	body, _ := io.ReadAll(resp.Body)
 	if err := json.Unmarshal(body, &res); err != nil {
-		return "", fmt.Errorf("json error: %v body: %s", err, string(body))
+		return nil, fmt.Errorf("json error: %v body: %s", err, string(body))
 	}
 
 	if !res.Success {
 		if action == "get_stream" {
 			vals.Set("action", "get_movie_stream")
-			req, _ := h.newRequest("POST", HDREZKA_AJAX_MOVIE_URL, strings.NewReader(vals.Encode()))
+			req, _ := h.newRequest(ctx, "POST", h.BaseURL+"/ajax/get_cdn_movie/", strings.NewReader(vals.Encode()))
 			req.Header.Set("Referer", urlStr)
 			resp2, err := h.Client.Do(req)
 			if err == nil {
@@ -302,10 +325,49 @@ func (h *HDRezka) GetLink(serverID string) (string, error) {
 	}
 
 	if !res.Success {
-		return "", fmt.Errorf("api error: %s", res.Message)
+		return nil, fmt.Errorf("api error: %s", res.Message)
 	}
 
-	return h.Decode(res.URL), nil
+	variants := h.parseStreams(h.Decode(res.URL))
+	if len(variants) == 0 {
+		return nil, errors.New("no streams in response")
+	}
+
+	return &core.Stream{
+		Variants: variants,
+		Referer:  urlStr,
+	}, nil
+}
+
+// parseStreams splits the decoded "[720p]url or url,[1080p]url" list into variants.
+// Each entry may list mirrors separated by " or "; only the first one is kept.
+func (h *HDRezka) parseStreams(list string) []core.StreamQuality {
+	var variants []core.StreamQuality
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		label := "Default"
+		if strings.HasPrefix(s, "[") {
+			end := strings.Index(s, "]")
+			if end <= 1 {
+				continue
+			}
+			label = s[1:end]
+			s = s[end+1:]
+		}
+		if idx := strings.Index(s, " or "); idx != -1 {
+			s = s[:idx]
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		variants = append(variants, core.StreamQuality{
+			URL:    s,
+			Label:  label,
+			Height: core.HeightFromLabel(label),
+		})
+	}
+	return variants
 }
 
 func (h *HDRezka) Decode(data string) string {
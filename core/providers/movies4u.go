@@ -1,11 +1,13 @@
 package providers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -17,27 +19,40 @@ const (
 )
 
 type Movies4u struct {
-	Client *http.Client
+	Client  *http.Client
+	BaseURL string
 }
 
 func NewMovies4u(client *http.Client) *Movies4u {
-	return &Movies4u{Client: client}
+	return &Movies4u{Client: client, BaseURL: core.SiteURL("movies4u")}
 }
 
-func (m *Movies4u) newRequest(method, url string) (*http.Request, error) {
-	req, err := core.NewRequest(method, url)
+func init() {
+	core.RegisterSite("movies4u", MOVIES4U_BASE_URL)
+	core.RegisterProvider(core.ProviderInfo{
+		Name: "movies4u",
+		Capabilities: core.Capabilities{
+			DirectLinks: true,
+		},
+		New: func(client *http.Client) core.Provider {
+			return NewMovies4u(client)
+		},
+	})
+}
+
+func (m *Movies4u) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := core.NewRequest(ctx, method, url)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
-	req.Header.Set("Referer", MOVIES4U_BASE_URL+"/")
+	req.Header.Set("Referer", m.BaseURL+"/")
 	return req, nil
 }
 
-func (m *Movies4u) Search(query string) ([]core.SearchResult, error) {
-	searchURL := fmt.Sprintf("%s/?s=%s", MOVIES4U_BASE_URL, strings.ReplaceAll(query, " ", "+"))
-	req, _ := m.newRequest("GET", searchURL)
+func (m *Movies4u) Search(ctx context.Context, query string) ([]core.SearchResult, error) {
+	searchURL := fmt.Sprintf("%s/?s=%s", m.BaseURL, strings.ReplaceAll(query, " ", "+"))
+	req, _ := m.newRequest(ctx, "GET", searchURL)
 
 	resp, err := m.Client.Do(req)
 	if err != nil {
@@ -77,20 +92,20 @@ func (m *Movies4u) Search(query string) ([]core.SearchResult, error) {
 	return results, nil
 }
 
-func (m *Movies4u) GetMediaID(url string) (string, error) {
-	return url, nil
+func (m *Movies4u) GetMediaID(ctx context.Context, url string) (core.MediaRef, error) {
+	return core.MediaRef{Provider: "movies4u", Kind: core.KindTitle, Type: core.Movie, ID: url}, nil
 }
 
-func (m *Movies4u) GetSeasons(mediaID string) ([]core.Season, error) {
+func (m *Movies4u) GetSeasons(ctx context.Context, media core.MediaRef) ([]core.Season, error) {
 	return nil, nil
 }
 
-func (m *Movies4u) GetEpisodes(id string, isSeason bool) ([]core.Episode, error) {
-	if isSeason {
+func (m *Movies4u) GetEpisodes(ctx context.Context, ref core.MediaRef) ([]core.Episode, error) {
+	if ref.Kind == core.KindSeason {
 		return nil, nil
 	}
 
-	req, _ := m.newRequest("GET", id)
+	req, _ := m.newRequest(ctx, "GET", ref.ID)
 	resp, err := m.Client.Do(req)
 	if err != nil {
 		return nil, err
@@ -102,54 +117,49 @@ func (m *Movies4u) GetEpisodes(id string, isSeason bool) ([]core.Episode, error)
 		return nil, err
 	}
 
+	// Each resolution is a server named after it, best first, so the quality
+	// policy can pick one
 	var episodes []core.Episode
-
-	var bestLink string
-	var bestQuality int // 0: none, 1: 480p, 2: 720p, 3: 1080p
-
+	seen := map[int]bool{}
 	doc.Find("h5").Each(func(i int, sel *goquery.Selection) {
-		text := strings.TrimSpace(sel.Text())
-
-		var quality int
-		if strings.Contains(text, "1080p") {
-			quality = 3
-		} else if strings.Contains(text, "720p") {
-			quality = 2
-		} else if strings.Contains(text, "480p") {
-			quality = 1
+		height := core.HeightFromLabel(sel.Text())
+		if height == 0 || seen[height] {
+			return
 		}
-
-		if quality > 0 {
-			nextP := sel.NextFiltered("p")
-			link := nextP.Find("a").AttrOr("href", "")
-
-			if link != "" && strings.Contains(link, "nexdrive.top") {
-				if quality > bestQuality {
-					bestQuality = quality
-					bestLink = link
-				}
-			}
+		link := sel.NextFiltered("p").Find("a").AttrOr("href", "")
+		if link == "" || !strings.Contains(link, "nexdrive.top") {
+			return
 		}
+		seen[height] = true
+		episodes = append(episodes, core.Episode{
+			Ref:  ref.Child(core.KindServer, link),
+			Name: fmt.Sprintf("%dp", height),
+		})
 	})
-
-	if bestLink == "" {
+	if len(episodes) == 0 {
 		return nil, errors.New("no download links found")
 	}
 
-	episodes = append(episodes, core.Episode{
-		ID:   bestLink,
-		Name: "Full Movie",
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return core.HeightFromLabel(episodes[i].Name) > core.HeightFromLabel(episodes[j].Name)
 	})
-
 	return episodes, nil
 }
 
-func (m *Movies4u) GetServers(episodeID string) ([]core.Server, error) {
+func (m *Movies4u) GetServers(ctx context.Context, episode core.MediaRef) ([]core.Server, error) {
 	return nil, nil
 }
 
-func (m *Movies4u) GetLink(serverID string) (string, error) {
-	req, _ := m.newRequest("GET", serverID)
+func (m *Movies4u) GetLink(ctx context.Context, server core.MediaRef) (*core.Stream, error) {
+	link, err := m.resolveNexdrive(ctx, server.ID)
+	if err != nil {
+		return nil, err
+	}
+	return core.DirectStream(link), nil
+}
+
+func (m *Movies4u) resolveNexdrive(ctx context.Context, url string) (string, error) {
+	req, _ := m.newRequest(ctx, "GET", url)
 	resp, err := m.Client.Do(req)
 	if err != nil {
 		return "", err
@@ -163,19 +173,19 @@ func (m *Movies4u) GetLink(serverID string) (string, error) {
 
 	vCloud := doc.Find("a[href*='vcloud.zip']").AttrOr("href", "")
 	if vCloud != "" {
-		return m.handleVCloud(vCloud)
+		return m.handleVCloud(ctx, vCloud)
 	}
 
 	fastDl := doc.Find("a[href*='fastdl.zip']").AttrOr("href", "")
 	if fastDl != "" {
-		return m.resolveFinalLink(fastDl)
+		return m.resolveFinalLink(ctx, fastDl)
 	}
 
 	return "", errors.New("playable link not found on nexdrive page")
 }
 
-func (m *Movies4u) resolveFinalLink(url string) (string, error) {
-	req, err := m.newRequest("GET", url)
+func (m *Movies4u) resolveFinalLink(ctx context.Context, url string) (string, error) {
+	req, err := m.newRequest(ctx, "GET", url)
 	if err != nil {
 		return "", err
 	}
@@ -196,8 +206,8 @@ func (m *Movies4u) resolveFinalLink(url string) (string, error) {
 	return finalURL, nil
 }
 
-func (m *Movies4u) handleVCloud(url string) (string, error) {
-	req, _ := m.newRequest("GET", url)
+func (m *Movies4u) handleVCloud(ctx context.Context, url string) (string, error) {
+	req, _ := m.newRequest(ctx, "GET", url)
 	resp, err := m.Client.Do(req)
 	if err != nil {
 		return "", err
@@ -217,11 +227,11 @@ func (m *Movies4u) handleVCloud(url string) (string, error) {
 	}
 	hubCloudURL := matches[1]
 
-	return m.resolveHubCloudDownload(hubCloudURL)
+	return m.resolveHubCloudDownload(ctx, hubCloudURL)
 }
 
-func (m *Movies4u) resolveHubCloudDownload(url string) (string, error) {
-	req, _ := m.newRequest("GET", url)
+func (m *Movies4u) resolveHubCloudDownload(ctx context.Context, url string) (string, error) {
+	req, _ := m.newRequest(ctx, "GET", url)
 	resp, err := m.Client.Do(req)
 	if err != nil {
 		return "", err
@@ -252,5 +262,5 @@ func (m *Movies4u) resolveHubCloudDownload(url string) (string, error) {
 		return "", errors.New("no download link found on final hubcloud page")
 	}
 
-	return m.resolveFinalLink(downloadLink)
+	return m.resolveFinalLink(ctx, downloadLink)
 }
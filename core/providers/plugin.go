@@ -0,0 +1,520 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/demonkingswarn/luffy/core"
+)
+
+const (
+	PLUGIN_PREFIX           = "luffy-provider-"
+	PLUGIN_PROTOCOL_VERSION = 1
+	// Plugins get this long to start and answer initialize
+	PLUGIN_START_TIMEOUT = 10 * time.Second
+	// Calls made without a deadline of their own get this one
+	PLUGIN_CALL_TIMEOUT = time.Minute
+)
+
+// FindPlugins lists the luffy-provider-* executables on PATH followed by the
+// extra ones given by path or name, without duplicates.
+func FindPlugins(extra []string) []string {
+	seen := map[string]bool{}
+	var found []string
+	add := func(path string) {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if !seen[path] {
+			seen[path] = true
+			found = append(found, path)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(dir, PLUGIN_PREFIX+"*"))
+		for _, m := range matches {
+			name := filepath.Base(m)
+			if names[name] || !executable(m) {
+				continue
+			}
+			// The first one on PATH wins, as it would in a shell
+			names[name] = true
+			add(m)
+		}
+	}
+	for _, p := range extra {
+		if path, err := exec.LookPath(p); err == nil {
+			add(path)
+		}
+	}
+	return found
+}
+
+func executable(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir() && fi.Mode()&0111 != 0
+}
+
+// LoadPlugins starts each plugin, asks it for its name and capabilities, and
+// registers it as a provider, and as an extractor when it lists extract_hosts.
+// Plugin stderr is copied to stderr when it is not nil. Plugins that fail to
+// start are skipped and reported.
+func LoadPlugins(ctx context.Context, paths []string, stderr io.Writer) []error {
+	var errs []error
+	for _, path := range paths {
+		p := &Plugin{Path: path, Stderr: stderr}
+		startCtx, cancel := context.WithTimeout(ctx, PLUGIN_START_TIMEOUT)
+		err := p.start(startCtx)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+			continue
+		}
+
+		pluginsMu.Lock()
+		plugins = append(plugins, p)
+		pluginsMu.Unlock()
+
+		info := p.info
+		if len(info.ExtractHosts) > 0 {
+			core.RegisterExtractor(p)
+		}
+		if info.ExtractOnly {
+			continue
+		}
+		core.RegisterProvider(core.ProviderInfo{
+			Name:    info.Name,
+			Aliases: info.Aliases,
+			Capabilities: core.Capabilities{
+				NeedsDecryption: info.Capabilities.NeedsDecryption,
+				SupportsSeries:  info.Capabilities.SupportsSeries,
+				DirectLinks:     info.Capabilities.DirectLinks,
+				PreferredServer: info.Capabilities.PreferredServer,
+				Referer:         referers[info.Capabilities.Referer],
+			},
+			New: func(client *http.Client) core.Provider {
+				return p
+			},
+		})
+	}
+	return errs
+}
+
+var (
+	pluginsMu sync.Mutex
+	plugins   []*Plugin
+)
+
+// ClosePlugins stops every plugin started by LoadPlugins.
+func ClosePlugins() {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	for _, p := range plugins {
+		p.Close()
+	}
+	plugins = nil
+}
+
+// Wire format of the plugin protocol: JSON-RPC 2.0, one message per line.
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcResponse struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+type pluginInfo struct {
+	ProtocolVersion int        `json:"protocol_version"`
+	Name            string     `json:"name"`
+	Aliases         []string   `json:"aliases"`
+	Capabilities    pluginCaps `json:"capabilities"`
+	// ExtractHosts are the embed hosts the plugin extracts streams from.
+	ExtractHosts []string `json:"extract_hosts"`
+	// ExtractOnly plugins only provide extractors, not a provider.
+	ExtractOnly bool `json:"extract_only"`
+}
+
+type pluginCaps struct {
+	NeedsDecryption bool   `json:"needs_decryption"`
+	SupportsSeries  bool   `json:"supports_series"`
+	DirectLinks     bool   `json:"direct_links"`
+	PreferredServer string `json:"preferred_server"`
+	Referer         string `json:"referer"`
+}
+
+type pluginRef struct {
+	Provider string            `json:"provider"`
+	Kind     core.RefKind      `json:"kind"`
+	Type     core.MediaType    `json:"type,omitempty"`
+	ID       string            `json:"id"`
+	Native   map[string]string `json:"native,omitempty"`
+	Season   int               `json:"season,omitempty"`
+	Episode  int               `json:"episode,omitempty"`
+	TMDB     string            `json:"tmdb,omitempty"`
+	IMDb     string            `json:"imdb,omitempty"`
+}
+
+func toPluginRef(r core.MediaRef) pluginRef {
+	return pluginRef(r)
+}
+
+func (r pluginRef) ref() core.MediaRef {
+	return core.MediaRef(r)
+}
+
+type pluginResult struct {
+	Title  string         `json:"title"`
+	URL    string         `json:"url"`
+	Type   core.MediaType `json:"type"`
+	Poster string         `json:"poster"`
+	Year   string         `json:"year"`
+	TMDB   string         `json:"tmdb"`
+}
+
+type pluginItem struct {
+	Ref  pluginRef `json:"ref"`
+	Name string    `json:"name"`
+}
+
+type pluginStream struct {
+	Variants []struct {
+		URL        string `json:"url"`
+		Label      string `json:"label"`
+		Resolution string `json:"resolution"`
+		Bandwidth  int    `json:"bandwidth"`
+		Height     int    `json:"height"`
+	} `json:"variants"`
+	Referer   string            `json:"referer"`
+	UserAgent string            `json:"user_agent"`
+	Headers   map[string]string `json:"headers"`
+	Subtitles []struct {
+		URL      string `json:"url"`
+		Language string `json:"language"`
+		Label    string `json:"label"`
+	} `json:"subtitles"`
+	Container core.Container `json:"container"`
+}
+
+// Plugin is an external provider executable. It is started once and serves
+// every call over its stdin and stdout; when it dies, the next call starts
+// it again.
+type Plugin struct {
+	Path   string
+	Stderr io.Writer
+
+	startMu sync.Mutex
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	done    chan struct{}
+	info    pluginInfo
+}
+
+func (p *Plugin) start(ctx context.Context) error {
+	cmd := exec.Command(p.Path)
+	cmd.Stderr = p.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	p.mu.Lock()
+	p.cmd, p.stdin, p.done = cmd, stdin, done
+	p.pending = map[int64]chan rpcResponse{}
+	p.mu.Unlock()
+	go p.read(stdout, done)
+
+	var info pluginInfo
+	err = p.send(ctx, "initialize", map[string]any{
+		"protocol_version": PLUGIN_PROTOCOL_VERSION,
+		"luffy_version":    core.Version,
+	}, &info)
+	switch {
+	case err != nil:
+	case info.Name == "":
+		err = errors.New("plugin did not report a name")
+	case info.ProtocolVersion != PLUGIN_PROTOCOL_VERSION:
+		err = fmt.Errorf("plugin speaks protocol %d, luffy speaks %d", info.ProtocolVersion, PLUGIN_PROTOCOL_VERSION)
+	default:
+		if _, ok := referers[info.Capabilities.Referer]; !ok {
+			err = fmt.Errorf("unknown referer %q", info.Capabilities.Referer)
+		}
+	}
+	if err != nil {
+		p.Close()
+		return err
+	}
+	if p.info.Name != "" && !strings.EqualFold(info.Name, p.info.Name) {
+		p.Close()
+		return fmt.Errorf("plugin renamed itself from %s to %s", p.info.Name, info.Name)
+	}
+	p.info = info
+	return nil
+}
+
+// read hands every response to the call waiting for it, until stdout closes.
+func (p *Plugin) read(stdout io.Reader, done chan struct{}) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp rpcResponse
+		if json.Unmarshal(scanner.Bytes(), &resp) != nil {
+			continue
+		}
+		p.mu.Lock()
+		ch := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+	}
+
+	p.mu.Lock()
+	cmd := p.cmd
+	if p.done == done {
+		p.stdin.Close()
+		p.cmd = nil
+	}
+	p.mu.Unlock()
+	cmd.Wait()
+	close(done)
+}
+
+func (p *Plugin) send(ctx context.Context, method string, params, result any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, PLUGIN_CALL_TIMEOUT)
+		defer cancel()
+	}
+
+	p.mu.Lock()
+	if p.cmd == nil {
+		p.mu.Unlock()
+		return errors.New("plugin is not running")
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan rpcResponse, 1)
+	p.pending[id] = ch
+	done := p.done
+	line, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err == nil {
+		_, err = p.stdin.Write(append(line, '\n'))
+	}
+	if err != nil {
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-done:
+		return errors.New("plugin exited")
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// call sends a request, starting the plugin again if it has exited.
+func (p *Plugin) call(ctx context.Context, method string, params, result any) error {
+	p.startMu.Lock()
+	p.mu.Lock()
+	running := p.cmd != nil
+	p.mu.Unlock()
+	if !running {
+		if err := p.start(ctx); err != nil {
+			p.startMu.Unlock()
+			return fmt.Errorf("%s: %w", p.info.Name, err)
+		}
+	}
+	p.startMu.Unlock()
+	if err := p.send(ctx, method, params, result); err != nil {
+		return fmt.Errorf("%s %s: %w", p.info.Name, method, err)
+	}
+	return nil
+}
+
+// Close stops the plugin by closing its stdin, and kills it if it lingers.
+func (p *Plugin) Close() {
+	p.mu.Lock()
+	cmd, done := p.cmd, p.done
+	if cmd != nil {
+		p.stdin.Close()
+	}
+	p.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+func (p *Plugin) Search(ctx context.Context, query string) ([]core.SearchResult, error) {
+	var list []pluginResult
+	if err := p.call(ctx, "search", map[string]any{"query": query}, &list); err != nil {
+		return nil, err
+	}
+	results := make([]core.SearchResult, 0, len(list))
+	for _, r := range list {
+		results = append(results, core.SearchResult(r))
+	}
+	return results, nil
+}
+
+func (p *Plugin) GetMediaID(ctx context.Context, url string) (core.MediaRef, error) {
+	var ref pluginRef
+	if err := p.call(ctx, "media_id", map[string]any{"url": url}, &ref); err != nil {
+		return core.MediaRef{}, err
+	}
+	return p.own(ref), nil
+}
+
+func (p *Plugin) GetSeasons(ctx context.Context, media core.MediaRef) ([]core.Season, error) {
+	items, err := p.list(ctx, "seasons", media)
+	var seasons []core.Season
+	for _, it := range items {
+		seasons = append(seasons, core.Season{Ref: p.own(it.Ref), Name: it.Name})
+	}
+	return seasons, err
+}
+
+func (p *Plugin) GetEpisodes(ctx context.Context, ref core.MediaRef) ([]core.Episode, error) {
+	items, err := p.list(ctx, "episodes", ref)
+	var episodes []core.Episode
+	for _, it := range items {
+		episodes = append(episodes, core.Episode{Ref: p.own(it.Ref), Name: it.Name})
+	}
+	return episodes, err
+}
+
+func (p *Plugin) GetServers(ctx context.Context, episode core.MediaRef) ([]core.Server, error) {
+	items, err := p.list(ctx, "servers", episode)
+	var servers []core.Server
+	for _, it := range items {
+		servers = append(servers, core.Server{Ref: p.own(it.Ref), Name: it.Name})
+	}
+	return servers, err
+}
+
+func (p *Plugin) list(ctx context.Context, method string, ref core.MediaRef) ([]pluginItem, error) {
+	var items []pluginItem
+	err := p.call(ctx, method, map[string]any{"ref": toPluginRef(ref)}, &items)
+	return items, err
+}
+
+func (p *Plugin) GetLink(ctx context.Context, server core.MediaRef) (*core.Stream, error) {
+	var s pluginStream
+	if err := p.call(ctx, "link", map[string]any{"ref": toPluginRef(server)}, &s); err != nil {
+		return nil, err
+	}
+	return s.stream(p.info.Name+" link", p.info.Capabilities.NeedsDecryption)
+}
+
+// Extract resolves an embed link on one of the plugin's extract_hosts.
+func (p *Plugin) Extract(ctx context.Context, link string, client *http.Client) (*core.Stream, error) {
+	var s pluginStream
+	if err := p.call(ctx, "extract", map[string]any{"url": link}, &s); err != nil {
+		return nil, err
+	}
+	return s.stream(p.info.Name+" extract", false)
+}
+
+func (p *Plugin) Name() string    { return p.info.Name }
+func (p *Plugin) Hosts() []string { return p.info.ExtractHosts }
+
+func (s pluginStream) stream(what string, embed bool) (*core.Stream, error) {
+	if len(s.Variants) == 0 {
+		return nil, fmt.Errorf("%s: no variants", what)
+	}
+
+	stream := &core.Stream{
+		Referer:   s.Referer,
+		UserAgent: s.UserAgent,
+		Headers:   s.Headers,
+		Container: s.Container,
+	}
+	for _, v := range s.Variants {
+		stream.Variants = append(stream.Variants, core.StreamQuality{
+			URL:        v.URL,
+			Label:      v.Label,
+			Resolution: v.Resolution,
+			Bandwidth:  v.Bandwidth,
+			Height:     v.Height,
+		})
+	}
+	for _, sub := range s.Subtitles {
+		stream.Subtitles = append(stream.Subtitles, core.Subtitle(sub))
+	}
+	if stream.Container == "" {
+		stream.Container = core.ContainerFromURL(stream.URL())
+		if embed {
+			stream.Container = core.ContainerEmbed
+		}
+	}
+	return stream, nil
+}
+
+// own makes sure refs coming back from the plugin carry its provider name.
+func (p *Plugin) own(r pluginRef) core.MediaRef {
+	ref := r.ref()
+	ref.Provider = p.info.Name
+	return ref
+}
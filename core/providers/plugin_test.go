@@ -0,0 +1,153 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/demonkingswarn/luffy/core"
+)
+
+func TestMain(m *testing.M) {
+	// The test binary doubles as the plugin under test
+	if os.Getenv("LUFFY_FAKE_PLUGIN") != "" {
+		fakePlugin()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func fakePlugin() {
+	fmt.Fprintln(os.Stderr, "fake plugin started")
+	scanner := bufio.NewScanner(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	for scanner.Scan() {
+		var req struct {
+			ID     int64  `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Query string    `json:"query"`
+				URL   string    `json:"url"`
+				Ref   pluginRef `json:"ref"`
+			} `json:"params"`
+		}
+		json.Unmarshal(scanner.Bytes(), &req)
+		ref := req.Params.Ref
+
+		var result any
+		switch req.Method {
+		case "initialize":
+			result = map[string]any{
+				"protocol_version": 1,
+				"name":             "fake",
+				"capabilities":     map[string]any{"supports_series": true, "direct_links": true},
+				"extract_hosts":    []string{"fakehost.example"},
+			}
+		case "search":
+			result = []map[string]any{{"title": "Fake " + req.Params.Query, "url": "fake://7", "type": "series"}}
+		case "media_id":
+			result = map[string]any{"kind": "title", "id": "7", "type": "series"}
+		case "seasons":
+			result = []map[string]any{{"name": "Season 1", "ref": map[string]any{"kind": "season", "id": "s1", "type": "series", "season": 1}}}
+		case "episodes":
+			result = []map[string]any{{"name": "Pilot", "ref": map[string]any{"kind": "episode", "id": "e1", "type": "series", "season": 1, "episode": 1}}}
+		case "servers":
+			if ref.ID == "crash" {
+				os.Exit(1)
+			}
+			result = []map[string]any{{"name": "Main", "ref": map[string]any{"kind": "server", "id": "main-" + ref.ID}}}
+		case "link":
+			result = map[string]any{"variants": []map[string]any{{"url": "https://cdn.example/" + ref.ID + ".m3u8"}}}
+		case "extract":
+			result = map[string]any{
+				"variants":  []map[string]any{{"url": req.Params.URL + "/index.m3u8", "label": "1080p"}},
+				"referer":   "https://fakehost.example/",
+				"subtitles": []map[string]any{{"url": "https://fakehost.example/en.vtt", "language": "en"}},
+			}
+		default:
+			out.Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": -32601, "message": "method not found"}})
+			continue
+		}
+		out.Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPlugin(t *testing.T) {
+	t.Setenv("LUFFY_FAKE_PLUGIN", "1")
+	var stderr syncBuffer
+	if errs := LoadPlugins(context.Background(), []string{os.Args[0]}, &stderr); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	defer ClosePlugins()
+
+	info, ok := core.LookupProvider("fake")
+	if !ok {
+		t.Fatal("plugin not registered")
+	}
+	if !info.Capabilities.SupportsSeries || !info.Capabilities.DirectLinks {
+		t.Errorf("capabilities = %+v", info.Capabilities)
+	}
+
+	p := info.New(nil)
+	chain, err := core.WalkChain(context.Background(), p, "show", core.Series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.Result.Title != "Fake show" || chain.Media.Provider != "fake" {
+		t.Errorf("result = %+v, media = %+v", chain.Result, chain.Media)
+	}
+	if got := chain.Stream.URL(); got != "https://cdn.example/main-e1.m3u8" {
+		t.Errorf("stream = %q", got)
+	}
+	if chain.Stream.Container != core.ContainerHLS {
+		t.Errorf("container = %q", chain.Stream.Container)
+	}
+
+	// A crashed plugin is started again on the next call
+	if _, err := p.GetServers(context.Background(), core.MediaRef{Kind: core.KindEpisode, ID: "crash"}); err == nil {
+		t.Error("expected an error from the crashed plugin")
+	}
+	servers, err := p.GetServers(context.Background(), core.MediaRef{Kind: core.KindEpisode, ID: "e2"})
+	if err != nil || len(servers) != 1 || servers[0].Ref.Provider != "fake" {
+		t.Errorf("after restart: servers = %+v, err = %v", servers, err)
+	}
+
+	if _, err := p.GetMediaID(context.Background(), "unknown"); err != nil {
+		t.Error(err)
+	}
+
+	stream, err := core.DecryptStream(context.Background(), "https://cdn.fakehost.example/e/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.URL() != "https://cdn.fakehost.example/e/1/index.m3u8" || stream.Referer != "https://fakehost.example/" || len(stream.Subtitles) != 1 {
+		t.Errorf("extracted stream = %+v", stream)
+	}
+	ClosePlugins()
+	if n := strings.Count(stderr.String(), "fake plugin started"); n != 2 {
+		t.Errorf("plugin started %d times, want 2", n)
+	}
+}
@@ -0,0 +1,181 @@
+package providers
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/demonkingswarn/luffy/core"
+	"github.com/demonkingswarn/luffy/internal/fixturetest"
+)
+
+// Fixtures live in testdata/<provider>.json and are refreshed with
+// `luffy dev record <provider> <query> --type <movie|series>`.
+var fixtureCases = []struct {
+	name     string
+	provider func(srv *httptest.Server) core.Provider
+	title    string
+	seasons  int
+	episodes int
+	servers  []string
+	stream   string
+	variants int
+}{
+	{
+		name: "flixhq",
+		provider: func(srv *httptest.Server) core.Provider {
+			return scraper(srv, "flixhq")
+		},
+		title:    "Breaking Bad",
+		seasons:  5,
+		episodes: 7,
+		servers:  []string{"UpCloud", "Vidcloud", "AKCloud"},
+		stream:   "https://rabbitstream.net/v2/embed-4/mK5vtM2Gp1ZT?z=",
+		variants: 1,
+	},
+	{
+		name: "sflix",
+		provider: func(srv *httptest.Server) core.Provider {
+			return scraper(srv, "sflix")
+		},
+		title:    "Breaking Bad",
+		seasons:  5,
+		episodes: 7,
+		servers:  []string{"Vidcloud", "UpCloud", "Voe"},
+		stream:   "https://cloudvidz.net/embed-1/v3/e-1/Xk3hZ9cQ2LpA?z=",
+		variants: 1,
+	},
+	{
+		name: "braflix",
+		provider: func(srv *httptest.Server) core.Provider {
+			return scraper(srv, "braflix")
+		},
+		title:    "Breaking Bad",
+		seasons:  3,
+		episodes: 7,
+		servers:  []string{"UpCloud", "Vidcloud"},
+		stream:   "https://rabbitstream.net/v2/embed-4/Qb7xN1sLr4Zw?z=",
+		variants: 1,
+	},
+	{
+		name: "brocoflix",
+		provider: func(srv *httptest.Server) core.Provider {
+			p := NewBrocoflix(fixturetest.Client(srv))
+			p.Catalog.BaseURL = fixturetest.URL(srv, core.TMDB_BASE_URL)
+			return p
+		},
+		title:    "Breaking Bad",
+		seasons:  6,
+		episodes: 7,
+		servers:  []string{"VidSrc", "MultiEmbed", "VidLink", "EmbedSu"},
+		stream:   "https://vidsrc.xyz/embed/tv/1396/1/1",
+		variants: 1,
+	},
+	{
+		name: "xprime",
+		provider: func(srv *httptest.Server) core.Provider {
+			p := NewXPrime(fixturetest.Client(srv))
+			p.Catalog.BaseURL = fixturetest.URL(srv, core.TMDB_BASE_URL)
+			return p
+		},
+		title:    "Breaking Bad",
+		seasons:  6,
+		episodes: 7,
+		servers:  []string{"VidLink", "VidSrc", "MultiEmbed", "EmbedSu"},
+		stream:   "https://vidlink.pro/tv/1396/1/1",
+		variants: 1,
+	},
+	{
+		name: "hdrezka",
+		provider: func(srv *httptest.Server) core.Provider {
+			p := NewHDRezka(fixturetest.Client(srv))
+			p.BaseURL = fixturetest.URL(srv, HDREZKA_BASE_URL)
+			return p
+		},
+		title:    "Во все тяжкие",
+		seasons:  3,
+		episodes: 7,
+		servers:  []string{"Оригинал (+субтитры)", "LostFilm", "Кубик в Кубе (Premium)"},
+		stream:   "https://prx.ukrtelcdn.net/s_b8e1/ac44d7c7c3a9a4a31f5d39c3ba1e1e9b:2026101718:TjBHQ1BhNVJqOGNBSmZLUA==/4/9/3/7/4/7/kx3bq.mp4:hls:manifest.m3u8",
+		variants: 4,
+	},
+	{
+		name: "movies4u",
+		provider: func(srv *httptest.Server) core.Provider {
+			p := NewMovies4u(fixturetest.Client(srv))
+			p.BaseURL = fixturetest.URL(srv, MOVIES4U_BASE_URL)
+			return p
+		},
+		title:    "Dune: Part Two (2024) WEB-DL [Hindi-English] 480p, 720p & 1080p",
+		servers:  []string{"1080p", "720p", "480p"},
+		stream:   "https://pub-1c0b1d7ed8a64d6a9ac1c2a3f5a3d0e1.r2.dev/Dune.Part.Two.2024.1080p.mkv",
+		variants: 1,
+	},
+	{
+		name: "youtube",
+		provider: func(srv *httptest.Server) core.Provider {
+			// Keep the live base URL so the watch link stays comparable
+			return NewYouTube(fixturetest.Client(srv))
+		},
+		title:    "lofi hip hop radio 📚 beats to relax/study to",
+		servers:  []string{"Watch Video"},
+		stream:   "https://www.youtube.com/watch?v=jfKfPfyJRdk",
+		variants: 1,
+	},
+}
+
+// scraper builds a provider registered from a scraper definition.
+func scraper(srv *httptest.Server, name string) core.Provider {
+	info, _ := core.LookupProvider(name)
+	p := info.New(fixturetest.Client(srv)).(*ScraperProvider)
+	p.BaseURL = fixturetest.URL(srv, p.BaseURL)
+	return p
+}
+
+func TestProviderFixtures(t *testing.T) {
+	for _, tc := range fixtureCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fixture, err := core.LoadFixture(filepath.Join("testdata", tc.name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := fixturetest.NewServer(fixture)
+			defer srv.Close()
+
+			chain, err := core.WalkChain(context.Background(), tc.provider(srv), fixture.Query, fixture.Type)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if chain.Result.Title != tc.title {
+				t.Errorf("result title = %q, want %q", chain.Result.Title, tc.title)
+			}
+			if chain.Media.Provider != tc.name {
+				t.Errorf("media provider = %q, want %q", chain.Media.Provider, tc.name)
+			}
+			if len(chain.Seasons) != tc.seasons {
+				t.Errorf("got %d seasons, want %d", len(chain.Seasons), tc.seasons)
+			}
+			if len(chain.Episodes) != tc.episodes {
+				t.Errorf("got %d episodes, want %d", len(chain.Episodes), tc.episodes)
+			}
+
+			var servers []string
+			for _, s := range chain.Servers {
+				servers = append(servers, s.Name)
+			}
+			if !slices.Equal(servers, tc.servers) {
+				t.Errorf("servers = %q, want %q", servers, tc.servers)
+			}
+
+			if got := chain.Stream.URL(); got != tc.stream {
+				t.Errorf("stream = %q, want %q", got, tc.stream)
+			}
+			if len(chain.Stream.Variants) != tc.variants {
+				t.Errorf("got %d variants, want %d", len(chain.Stream.Variants), tc.variants)
+			}
+		})
+	}
+}
@@ -0,0 +1,586 @@
+package providers
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/demonkingswarn/luffy/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in scraper definitions. A file of the same name in the user's
+// providers directory replaces the built-in one.
+//
+//go:embed scrapers/*.yaml
+var builtinScrapers embed.FS
+
+func init() {
+	entries, err := builtinScrapers.ReadDir("scrapers")
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		data, err := builtinScrapers.ReadFile("scrapers/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		def, err := ParseScraper(data)
+		if err != nil {
+			panic(fmt.Sprintf("scrapers/%s: %v", e.Name(), err))
+		}
+		RegisterScraper(def)
+	}
+}
+
+// ScraperDefinition describes an HTML provider: where each step of the
+// core.Provider walk fetches its page and how the values are read from it.
+//
+// URLs and headers are templates. {base} is the site's base URL, {query} the
+// search query escaped for a query string and {slug} the query with spaces
+// turned into dashes. {id} is the ID of the ref the step works on, and {url}
+// the title URL in media_id.
+type ScraperDefinition struct {
+	Name         string            `yaml:"name"`
+	Aliases      []string          `yaml:"aliases"`
+	BaseURL      string            `yaml:"base_url"`
+	Capabilities ScraperCaps       `yaml:"capabilities"`
+	Headers      map[string]string `yaml:"headers"`
+
+	Search       SearchStep  `yaml:"search"`
+	MediaID      ScraperStep `yaml:"media_id"`
+	Seasons      ScraperStep `yaml:"seasons"`
+	Episodes     ScraperStep `yaml:"episodes"`
+	MovieServers ScraperStep `yaml:"movie_servers"`
+	Servers      ScraperStep `yaml:"servers"`
+	Link         ScraperStep `yaml:"link"`
+}
+
+type ScraperCaps struct {
+	NeedsDecryption bool   `yaml:"needs_decryption"`
+	SupportsSeries  bool   `yaml:"supports_series"`
+	DirectLinks     bool   `yaml:"direct_links"`
+	PreferredServer string `yaml:"preferred_server"`
+	// Referer is embed (the default), embed_origin or page.
+	Referer string `yaml:"referer"`
+}
+
+// ScraperStep fetches one page and reads values out of it. Headers set to an
+// empty string are removed from the request.
+type ScraperStep struct {
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Body    string            `yaml:"body"`
+	Headers map[string]string `yaml:"headers"`
+	// Format is html (the default) or json. Rules read HTML with selector
+	// and attr, and JSON with json paths like data.items.0.link.
+	Format string `yaml:"format"`
+	// HTML names a JSON field holding the markup, for endpoints that wrap it.
+	HTML string `yaml:"html"`
+	// Items selects the entries of a list step: a CSS selector, or a JSON
+	// path to an array. Limit caps how many are read.
+	Items string `yaml:"items"`
+	Limit int    `yaml:"limit"`
+
+	ID   Extract `yaml:"id"`
+	Name Extract `yaml:"name"`
+	// Link reads the stream URL in the link step.
+	Link Extract `yaml:"link"`
+}
+
+type SearchStep struct {
+	ScraperStep `yaml:",inline"`
+	Title       Extract `yaml:"title"`
+	Href        Extract `yaml:"href"`
+	Poster      Extract `yaml:"poster"`
+	Year        Extract `yaml:"year"`
+	Type        Extract `yaml:"type"`
+	// Series lists the values of Type, compared without case, that mean a series.
+	Series []string `yaml:"series"`
+}
+
+// Extract is a list of rules tried in order; the first non-empty value wins.
+// In YAML it is written as a single rule, a list of rules, or a bare string
+// standing for a selector.
+type Extract []Rule
+
+// Rule reads a value from the elements matched by Selector, or from the
+// current item when Selector is empty: the Attr attribute, or the trimmed
+// text. JSON reads a field of a JSON item instead. Match keeps the first
+// submatch of a regexp (or the whole match), and Value is a constant.
+type Rule struct {
+	Selector string `yaml:"selector"`
+	Attr     string `yaml:"attr"`
+	JSON     string `yaml:"json"`
+	Match    string `yaml:"match"`
+	Value    string `yaml:"value"`
+
+	re *regexp.Regexp
+}
+
+func (e *Extract) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var r Rule
+		if err := node.Decode(&r); err != nil {
+			return err
+		}
+		*e = Extract{r}
+		return nil
+	}
+	var rules []Rule
+	if err := node.Decode(&rules); err != nil {
+		return err
+	}
+	*e = rules
+	return nil
+}
+
+func (r *Rule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*r = Rule{Selector: node.Value}
+		return nil
+	}
+	type plain Rule
+	return node.Decode((*plain)(r))
+}
+
+// ParseScraper reads a scraper definition from YAML.
+func ParseScraper(data []byte) (*ScraperDefinition, error) {
+	var def ScraperDefinition
+	if err := yaml.Unmarshal(data, &def); err != nil {
+		return nil, err
+	}
+	if def.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	if def.Search.URL == "" || def.Link.URL == "" {
+		return nil, errors.New("search and link need a url")
+	}
+	if _, ok := referers[def.Capabilities.Referer]; !ok {
+		return nil, fmt.Errorf("unknown referer %q", def.Capabilities.Referer)
+	}
+
+	extracts := []Extract{
+		def.Search.Title, def.Search.Href, def.Search.Poster, def.Search.Year, def.Search.Type,
+	}
+	for _, step := range []*ScraperStep{
+		&def.Search.ScraperStep, &def.MediaID, &def.Seasons, &def.Episodes,
+		&def.MovieServers, &def.Servers, &def.Link,
+	} {
+		extracts = append(extracts, step.ID, step.Name, step.Link)
+	}
+	for _, ex := range extracts {
+		for i := range ex {
+			if ex[i].Match == "" {
+				continue
+			}
+			re, err := regexp.Compile(ex[i].Match)
+			if err != nil {
+				return nil, err
+			}
+			ex[i].re = re
+		}
+	}
+	return &def, nil
+}
+
+var referers = map[string]core.RefererPolicy{
+	"":             core.RefererEmbed,
+	"embed":        core.RefererEmbed,
+	"embed_origin": core.RefererEmbedOrigin,
+	"page":         core.RefererPage,
+}
+
+// RegisterScraper registers a scraper definition as a provider, replacing any
+// provider of the same name.
+func RegisterScraper(def *ScraperDefinition) {
+	core.RegisterSite(def.Name, def.BaseURL)
+	core.RegisterProvider(core.ProviderInfo{
+		Name:    def.Name,
+		Aliases: def.Aliases,
+		Capabilities: core.Capabilities{
+			NeedsDecryption: def.Capabilities.NeedsDecryption,
+			SupportsSeries:  def.Capabilities.SupportsSeries,
+			DirectLinks:     def.Capabilities.DirectLinks,
+			PreferredServer: def.Capabilities.PreferredServer,
+			Referer:         referers[def.Capabilities.Referer],
+		},
+		New: func(client *http.Client) core.Provider {
+			return NewScraperProvider(client, def)
+		},
+	})
+}
+
+// LoadScrapers registers every *.yaml definition in dir. A missing directory
+// is not an error; broken files are skipped and reported.
+func LoadScrapers(dir string) []error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			var def *ScraperDefinition
+			if def, err = ParseScraper(data); err == nil {
+				RegisterScraper(def)
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(file), err))
+	}
+	return errs
+}
+
+type ScraperProvider struct {
+	Client  *http.Client
+	BaseURL string
+	Def     *ScraperDefinition
+}
+
+func NewScraperProvider(client *http.Client, def *ScraperDefinition) *ScraperProvider {
+	return &ScraperProvider{Client: client, BaseURL: core.SiteURL(def.Name), Def: def}
+}
+
+// scope is what rules read from: an HTML selection, a decoded JSON value or
+// a plain string.
+type scope struct {
+	sel  *goquery.Selection
+	data any
+	text string
+}
+
+func (s *ScraperProvider) fetch(ctx context.Context, step *ScraperStep, vars map[string]string) (scope, error) {
+	if step.URL == "" {
+		return scope{}, errors.New("step has no url")
+	}
+	fill := s.replacer(vars)
+
+	method := step.Method
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if step.Body != "" {
+		body = strings.NewReader(fill.Replace(step.Body))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fill.Replace(step.URL), body)
+	if err != nil {
+		return scope{}, err
+	}
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req.Header.Set("Referer", s.BaseURL+"/")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, headers := range []map[string]string{s.Def.Headers, step.Headers} {
+		for k, v := range headers {
+			if v == "" {
+				req.Header.Del(k)
+			} else {
+				req.Header.Set(k, fill.Replace(v))
+			}
+		}
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return scope{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return scope{}, fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+
+	if step.Format != "json" && step.HTML == "" {
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		if err != nil {
+			return scope{}, err
+		}
+		return scope{sel: doc.Selection}, nil
+	}
+
+	var data any
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return scope{}, err
+	}
+	if step.HTML == "" {
+		return scope{data: data}, nil
+	}
+	markup, _ := jsonPath(data, step.HTML).(string)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(markup))
+	if err != nil {
+		return scope{}, err
+	}
+	return scope{sel: doc.Selection}, nil
+}
+
+func (s *ScraperProvider) replacer(vars map[string]string) *strings.Replacer {
+	pairs := []string{"{base}", s.BaseURL}
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// items lists the entries a list step selects from the page.
+func (step *ScraperStep) items(page scope) []scope {
+	var list []scope
+	if page.sel != nil {
+		page.sel.Find(step.Items).Each(func(_ int, sel *goquery.Selection) {
+			list = append(list, scope{sel: sel})
+		})
+	} else {
+		arr, _ := jsonPath(page.data, step.Items).([]any)
+		for _, v := range arr {
+			list = append(list, scope{data: v})
+		}
+	}
+	if step.Limit > 0 && len(list) > step.Limit {
+		list = list[:step.Limit]
+	}
+	return list
+}
+
+func (e Extract) eval(sc scope) string {
+	for _, r := range e {
+		if v := r.eval(sc); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (r Rule) eval(sc scope) string {
+	if r.Value != "" {
+		return r.Value
+	}
+
+	var values []string
+	switch {
+	case sc.sel != nil:
+		nodes := sc.sel
+		if r.Selector != "" {
+			nodes = sc.sel.Find(r.Selector)
+		}
+		nodes.Each(func(_ int, n *goquery.Selection) {
+			if r.Attr != "" {
+				values = append(values, strings.TrimSpace(n.AttrOr(r.Attr, "")))
+			} else {
+				values = append(values, strings.TrimSpace(n.Text()))
+			}
+		})
+	case sc.data != nil:
+		switch v := jsonPath(sc.data, r.JSON).(type) {
+		case string:
+			values = append(values, v)
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		}
+	default:
+		values = append(values, sc.text)
+	}
+
+	for _, v := range values {
+		if r.re != nil {
+			m := r.re.FindStringSubmatch(v)
+			switch {
+			case m == nil:
+				v = ""
+			case len(m) > 1:
+				v = m[1]
+			default:
+				v = m[0]
+			}
+		}
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// jsonPath walks a dotted path of object keys and array indexes.
+func jsonPath(v any, path string) any {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func (s *ScraperProvider) absolute(link string) string {
+	if link == "" || strings.Contains(link, "://") {
+		return link
+	}
+	if strings.HasPrefix(link, "//") {
+		return "https:" + link
+	}
+	if !strings.HasPrefix(link, "/") {
+		link = "/" + link
+	}
+	return s.BaseURL + link
+}
+
+func (s *ScraperProvider) Search(ctx context.Context, query string) ([]core.SearchResult, error) {
+	step := &s.Def.Search
+	page, err := s.fetch(ctx, &step.ScraperStep, map[string]string{
+		"query": url.QueryEscape(query),
+		"slug":  url.PathEscape(strings.ReplaceAll(query, " ", "-")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []core.SearchResult
+	for _, item := range step.items(page) {
+		title := step.Title.eval(item)
+		href := step.Href.eval(item)
+		if title == "" || href == "" {
+			continue
+		}
+
+		mediaType := core.Movie
+		typeStr := step.Type.eval(item)
+		for _, series := range step.Series {
+			if strings.EqualFold(typeStr, series) {
+				mediaType = core.Series
+			}
+		}
+
+		results = append(results, core.SearchResult{
+			Title:  title,
+			URL:    s.absolute(href),
+			Type:   mediaType,
+			Poster: s.absolute(step.Poster.eval(item)),
+			Year:   step.Year.eval(item),
+		})
+	}
+
+	if len(results) == 0 {
+		return nil, errors.New("no results")
+	}
+	return results, nil
+}
+
+func (s *ScraperProvider) GetMediaID(ctx context.Context, link string) (core.MediaRef, error) {
+	// Without a url the ID is read from the title URL itself
+	page := scope{text: link}
+	if s.Def.MediaID.URL != "" {
+		var err error
+		if page, err = s.fetch(ctx, &s.Def.MediaID, map[string]string{"url": link}); err != nil {
+			return core.MediaRef{}, err
+		}
+	}
+
+	id := s.Def.MediaID.ID.eval(page)
+	if id == "" {
+		return core.MediaRef{}, fmt.Errorf("could not find media ID")
+	}
+	return core.MediaRef{Provider: s.Def.Name, Kind: core.KindTitle, ID: id}, nil
+}
+
+// list runs a list step for ref and returns the ID and name of every entry.
+func (s *ScraperProvider) list(ctx context.Context, step *ScraperStep, ref core.MediaRef) ([][2]string, error) {
+	page, err := s.fetch(ctx, step, map[string]string{"id": ref.ID})
+	if err != nil {
+		return nil, err
+	}
+	var entries [][2]string
+	for _, item := range step.items(page) {
+		if id := step.ID.eval(item); id != "" {
+			entries = append(entries, [2]string{id, step.Name.eval(item)})
+		}
+	}
+	return entries, nil
+}
+
+func (s *ScraperProvider) GetSeasons(ctx context.Context, media core.MediaRef) ([]core.Season, error) {
+	entries, err := s.list(ctx, &s.Def.Seasons, media)
+	if err != nil {
+		return nil, err
+	}
+	var seasons []core.Season
+	for _, e := range entries {
+		ref := media.Child(core.KindSeason, e[0])
+		ref.Season = len(seasons) + 1
+		seasons = append(seasons, core.Season{Ref: ref, Name: e[1]})
+	}
+	return seasons, nil
+}
+
+func (s *ScraperProvider) GetEpisodes(ctx context.Context, ref core.MediaRef) ([]core.Episode, error) {
+	// Movies list their servers in place of episodes
+	step, kind := &s.Def.MovieServers, core.KindServer
+	if ref.Kind == core.KindSeason {
+		step, kind = &s.Def.Episodes, core.KindEpisode
+	}
+
+	entries, err := s.list(ctx, step, ref)
+	if err != nil {
+		return nil, err
+	}
+	var episodes []core.Episode
+	for _, e := range entries {
+		child := ref.Child(kind, e[0])
+		if kind == core.KindEpisode {
+			child.Episode = len(episodes) + 1
+		}
+		episodes = append(episodes, core.Episode{Ref: child, Name: e[1]})
+	}
+	return episodes, nil
+}
+
+func (s *ScraperProvider) GetServers(ctx context.Context, episode core.MediaRef) ([]core.Server, error) {
+	entries, err := s.list(ctx, &s.Def.Servers, episode)
+	if err != nil {
+		return nil, err
+	}
+	var servers []core.Server
+	for _, e := range entries {
+		servers = append(servers, core.Server{Ref: episode.Child(core.KindServer, e[0]), Name: e[1]})
+	}
+	return servers, nil
+}
+
+func (s *ScraperProvider) GetLink(ctx context.Context, server core.MediaRef) (*core.Stream, error) {
+	page, err := s.fetch(ctx, &s.Def.Link, map[string]string{"id": server.ID})
+	if err != nil {
+		return nil, err
+	}
+	link := s.Def.Link.Link.eval(page)
+	if link == "" {
+		return nil, errors.New("no link found")
+	}
+	if s.Def.Capabilities.DirectLinks {
+		return core.DirectStream(link), nil
+	}
+	return core.EmbedStream(link), nil
+}
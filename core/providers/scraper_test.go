@@ -0,0 +1,78 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/demonkingswarn/luffy/core"
+)
+
+const jsonScraper = `
+name: jsontest
+base_url: https://jsontest.example
+search:
+  url: "{base}/api/search?q={query}"
+  format: json
+  items: data.results
+  title: {json: name}
+  href: {json: path}
+  year: {json: released, match: '^(\d{4})'}
+  type: {json: kind}
+  series: [show]
+link:
+  url: "{base}/api/link/{id}"
+  format: json
+  link: {json: sources.0.file}
+`
+
+func TestLoadScrapers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/search":
+			fmt.Fprintf(w, `{"data":{"results":[{"name":%q,"path":"/show/7","released":"2008-01-20","kind":"show"}]}}`, r.URL.Query().Get("q"))
+		case "/api/link/9":
+			fmt.Fprint(w, `{"sources":[{"file":"https://cdn.example/9.m3u8"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "jsontest.yaml"), []byte(jsonScraper), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("name: broken\n"), 0644)
+
+	errs := LoadScrapers(dir)
+	if len(errs) != 1 {
+		t.Fatalf("got errors %v, want one for broken.yaml", errs)
+	}
+
+	info, ok := core.LookupProvider("jsontest")
+	if !ok {
+		t.Fatal("jsontest not registered")
+	}
+	p := info.New(srv.Client()).(*ScraperProvider)
+	p.BaseURL = srv.URL
+
+	results, err := p.Search(context.Background(), "breaking bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := core.SearchResult{Title: "breaking bad", URL: srv.URL + "/show/7", Type: core.Series, Year: "2008"}
+	if len(results) != 1 || results[0] != want {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+
+	stream, err := p.GetLink(context.Background(), core.MediaRef{Kind: core.KindServer, ID: "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stream.URL(); got != "https://cdn.example/9.m3u8" {
+		t.Errorf("link = %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,32 +14,40 @@ import (
 )
 
 const (
-	YOUTUBE_BASE_URL   = "https://www.youtube.com"
-	YOUTUBE_SEARCH_URL = YOUTUBE_BASE_URL + "/results"
+	YOUTUBE_BASE_URL = "https://www.youtube.com"
 )
 
 type YouTube struct {
-	Client *http.Client
+	Client  *http.Client
+	BaseURL string
 }
 
 func NewYouTube(client *http.Client) *YouTube {
-	return &YouTube{Client: client}
+	return &YouTube{Client: client, BaseURL: core.SiteURL("youtube")}
 }
 
-func (y *YouTube) newRequest(method, url string) (*http.Request, error) {
-	req, err := core.NewRequest(method, url)
-	if err != nil {
-		return nil, err
-	}
+func init() {
+	core.RegisterSite("youtube", YOUTUBE_BASE_URL)
+	core.RegisterProvider(core.ProviderInfo{
+		Name:    "youtube",
+		Aliases: []string{"yt"},
+		Capabilities: core.Capabilities{
+			DirectLinks: true,
+		},
+		New: func(client *http.Client) core.Provider {
+			return NewYouTube(client)
+		},
+	})
+}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	return req, nil
+func (y *YouTube) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	return core.NewRequest(ctx, method, url)
 }
 
-func (y *YouTube) Search(query string) ([]core.SearchResult, error) {
+func (y *YouTube) Search(ctx context.Context, query string) ([]core.SearchResult, error) {
 	v := url.Values{}
 	v.Set("search_query", query)
-	req, _ := y.newRequest("GET", YOUTUBE_SEARCH_URL+"?"+v.Encode())
+	req, _ := y.newRequest(ctx, "GET", y.BaseURL+"/results?"+v.Encode())
 
 	resp, err := y.Client.Do(req)
 	if err != nil {
@@ -102,7 +111,7 @@ func (y *YouTube) Search(query string) ([]core.SearchResult, error) {
 				if videoId != "" {
 					results = append(results, core.SearchResult{
 						Title:  title,
-						URL:    YOUTUBE_BASE_URL + "/watch?v=" + videoId,
+						URL:    y.BaseURL + "/watch?v=" + videoId,
 						Type:   core.Movie, // Treat as movie for simplicity
 						Poster: poster,
 					})
@@ -118,34 +127,41 @@ func (y *YouTube) Search(query string) ([]core.SearchResult, error) {
 	return results, nil
 }
 
-func (y *YouTube) GetMediaID(urlStr string) (string, error) {
+func (y *YouTube) GetMediaID(ctx context.Context, urlStr string) (core.MediaRef, error) {
 	u, err := url.Parse(urlStr)
 	if err != nil {
-		return "", err
+		return core.MediaRef{}, err
 	}
 	q := u.Query()
 	if v := q.Get("v"); v != "" {
-		return v, nil
+		return core.MediaRef{Provider: "youtube", Kind: core.KindTitle, Type: core.Movie, ID: v}, nil
 	}
 	// Handle shortened urls like youtu.be/ID
 	if u.Host == "youtu.be" {
-		return strings.TrimPrefix(u.Path, "/"), nil
+		return core.MediaRef{Provider: "youtube", Kind: core.KindTitle, Type: core.Movie, ID: strings.TrimPrefix(u.Path, "/")}, nil
 	}
-	return "", fmt.Errorf("could not extract video ID")
+	return core.MediaRef{}, fmt.Errorf("could not extract video ID")
 }
 
-func (y *YouTube) GetSeasons(mediaID string) ([]core.Season, error) {
-	return []core.Season{{ID: "1", Name: "Video"}}, nil
+func (y *YouTube) GetSeasons(ctx context.Context, media core.MediaRef) ([]core.Season, error) {
+	return []core.Season{{Ref: media.Child(core.KindSeason, "1"), Name: "Video"}}, nil
 }
 
-func (y *YouTube) GetEpisodes(id string, isSeason bool) ([]core.Episode, error) {
-	return []core.Episode{{ID: id, Name: "Watch Video"}}, nil
+func (y *YouTube) GetEpisodes(ctx context.Context, ref core.MediaRef) ([]core.Episode, error) {
+	videoID := ref.ParentID(core.KindTitle)
+	if ref.Kind == core.KindSeason {
+		return []core.Episode{{Ref: ref.Child(core.KindEpisode, videoID), Name: "Watch Video"}}, nil
+	}
+	return []core.Episode{{Ref: ref.Child(core.KindServer, videoID), Name: "Watch Video"}}, nil
 }
 
-func (y *YouTube) GetServers(episodeID string) ([]core.Server, error) {
-	return []core.Server{{ID: episodeID, Name: "YouTube"}}, nil
+func (y *YouTube) GetServers(ctx context.Context, episode core.MediaRef) ([]core.Server, error) {
+	return []core.Server{{Ref: episode.Child(core.KindServer, episode.ID), Name: "YouTube"}}, nil
 }
 
-func (y *YouTube) GetLink(serverID string) (string, error) {
-	return YOUTUBE_BASE_URL + "/watch?v=" + serverID, nil
+func (y *YouTube) GetLink(ctx context.Context, server core.MediaRef) (*core.Stream, error) {
+	return &core.Stream{
+		Variants:  []core.StreamQuality{{URL: y.BaseURL + "/watch?v=" + server.ID}},
+		Container: core.ContainerPage,
+	}, nil
 }
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Quality is a policy for picking a variant without asking: "best", "worst",
+// a resolution such as "1080p" (or the closest one), "<=720p" (the best up to
+// 720p) or "max_bitrate". The zero value, "ask", leaves the choice to the user.
+type Quality struct {
+	Mode   string
+	Height int
+}
+
+const (
+	QUALITY_ASK         = ""
+	QUALITY_BEST        = "best"
+	QUALITY_WORST       = "worst"
+	QUALITY_EXACT       = "exact"
+	QUALITY_AT_MOST     = "at_most"
+	QUALITY_MAX_BITRATE = "max_bitrate"
+)
+
+// ParseQuality reads a quality config value or --quality flag.
+func ParseQuality(value string) (Quality, error) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	switch v {
+	case "", "ask", "choose":
+		return Quality{}, nil
+	case "best", "highest":
+		return Quality{Mode: QUALITY_BEST}, nil
+	case "worst", "lowest":
+		return Quality{Mode: QUALITY_WORST}, nil
+	case "max_bitrate", "max-bitrate":
+		return Quality{Mode: QUALITY_MAX_BITRATE}, nil
+	}
+
+	mode := QUALITY_EXACT
+	if rest, ok := strings.CutPrefix(v, "<="); ok {
+		mode, v = QUALITY_AT_MOST, strings.TrimSpace(rest)
+	}
+	switch v {
+	case "4k", "uhd":
+		v = "2160"
+	case "2k":
+		v = "1440"
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(v, "p"))
+	if err != nil || height <= 0 {
+		return Quality{}, fmt.Errorf("unknown quality %q (use best, worst, 1080p, <=720p or max_bitrate)", value)
+	}
+	return Quality{Mode: mode, Height: height}, nil
+}
+
+func (q Quality) Ask() bool {
+	return q.Mode == QUALITY_ASK
+}
+
+func (q Quality) String() string {
+	switch q.Mode {
+	case QUALITY_ASK:
+		return "ask"
+	case QUALITY_EXACT:
+		return fmt.Sprintf("%dp", q.Height)
+	case QUALITY_AT_MOST:
+		return fmt.Sprintf("<=%dp", q.Height)
+	}
+	return q.Mode
+}
+
+// VariantHeight is the vertical resolution a variant is sold as: its height,
+// or failing that the one in its resolution or label. Letterboxed resolutions
+// such as 1920x800 count as the 16:9 height of their width, 1080p.
+func VariantHeight(v StreamQuality) int {
+	height := v.Height
+	if w, h, ok := strings.Cut(strings.ToLower(v.Resolution), "x"); ok {
+		width, _ := strconv.Atoi(w)
+		if height == 0 {
+			height, _ = strconv.Atoi(h)
+		}
+		height = max(height, width*9/16)
+	}
+	if height == 0 {
+		height = HeightFromLabel(v.Label)
+	}
+	return height
+}
+
+// Order returns the indexes of variants from most to least wanted, or nil
+// when the user is to be asked. Variants of unknown height come last, except
+// for max_bitrate, which only looks at the bandwidth.
+func (q Quality) Order(variants []StreamQuality) []int {
+	if q.Ask() || len(variants) == 0 {
+		return nil
+	}
+	heights := make([]int, len(variants))
+	for i, v := range variants {
+		heights[i] = VariantHeight(v)
+	}
+
+	// rank is lower for the variants the policy wants more
+	rank := func(i int) int {
+		h := heights[i]
+		switch q.Mode {
+		case QUALITY_WORST:
+			return h
+		case QUALITY_EXACT:
+			if h > q.Height {
+				// Too high is better than too low only when nothing is lower
+				return 1<<20 + h - q.Height
+			}
+			return q.Height - h
+		case QUALITY_AT_MOST:
+			if h > q.Height {
+				return 1<<20 + h
+			}
+		}
+		return -h
+	}
+	order := make([]int, len(variants))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if q.Mode == QUALITY_MAX_BITRATE && variants[i].Bandwidth != variants[j].Bandwidth {
+			return variants[i].Bandwidth > variants[j].Bandwidth
+		}
+		if (heights[i] == 0) != (heights[j] == 0) {
+			return heights[j] == 0
+		}
+		if ri, rj := rank(i), rank(j); ri != rj {
+			return ri < rj
+		}
+		if q.Mode == QUALITY_WORST {
+			return variants[i].Bandwidth < variants[j].Bandwidth
+		}
+		return variants[i].Bandwidth > variants[j].Bandwidth
+	})
+	return order
+}
+
+// Pick returns the index of the variant the policy wants, or -1 when the user
+// is to be asked.
+func (q Quality) Pick(variants []StreamQuality) int {
+	order := q.Order(variants)
+	if len(order) == 0 {
+		return -1
+	}
+	return order[0]
+}
+
+// pickOrBest returns the variant pick picks, or the best one when pick is nil
+// or leaves the choice to the user.
+func pickOrBest(pick func(variants []StreamQuality) int, variants []StreamQuality) StreamQuality {
+	i := -1
+	if pick != nil {
+		i = pick(variants)
+	}
+	if i < 0 || i >= len(variants) {
+		i = Quality{Mode: QUALITY_BEST}.Pick(variants)
+	}
+	return variants[i]
+}
+
+// RankServers orders servers named after a resolution, such as movies4u's
+// "480p" and "1080p" links, by the policy. Servers without a resolution in
+// their name keep their place after them.
+func (q Quality) RankServers(servers []Server) []Server {
+	variants := make([]StreamQuality, len(servers))
+	named := 0
+	for i, s := range servers {
+		variants[i] = StreamQuality{Label: s.Name}
+		if VariantHeight(variants[i]) > 0 {
+			named++
+		}
+	}
+	order := q.Order(variants)
+	if named < 2 || order == nil {
+		return servers
+	}
+	ranked := make([]Server, len(servers))
+	for i, idx := range order {
+		ranked[i] = servers[idx]
+	}
+	return ranked
+}
@@ -0,0 +1,67 @@
+package core
+
+import "testing"
+
+func TestQualityPick(t *testing.T) {
+	m3u8 := []StreamQuality{
+		{URL: "2160", Resolution: "3840x1600", Bandwidth: 12000000},
+		{URL: "1080", Resolution: "1920x800", Bandwidth: 5000000},
+		{URL: "1080hi", Resolution: "1920x800", Bandwidth: 8000000},
+		{URL: "720", Resolution: "1280x534", Bandwidth: 2500000},
+		{URL: "360", Resolution: "640x266", Bandwidth: 800000},
+	}
+	hdrezka := []StreamQuality{
+		{URL: "360", Label: "360p", Height: 360},
+		{URL: "720", Label: "720p", Height: 720},
+		{URL: "1080u", Label: "1080p Ultra", Height: 1080},
+		{URL: "auto", Label: "Default"},
+	}
+	for _, tc := range []struct {
+		quality  string
+		variants []StreamQuality
+		want     string
+	}{
+		{"best", m3u8, "2160"},
+		{"worst", m3u8, "360"},
+		{"1080p", m3u8, "1080hi"},
+		{"<=720p", m3u8, "720"},
+		{"<=1440p", m3u8, "1080hi"},
+		{"max_bitrate", m3u8, "2160"},
+		{"best", hdrezka, "1080u"},
+		{"worst", hdrezka, "360"},
+		{"480p", hdrezka, "360"},
+		{"<=240p", hdrezka, "360"},
+		{"4k", hdrezka, "1080u"},
+		{"max_bitrate", hdrezka, "1080u"},
+	} {
+		q, err := ParseQuality(tc.quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i := q.Pick(tc.variants); i < 0 || tc.variants[i].URL != tc.want {
+			t.Errorf("%s picked %d, want %s", tc.quality, i, tc.want)
+		}
+	}
+
+	if q, _ := ParseQuality("ask"); q.Pick(m3u8) != -1 {
+		t.Error("ask picked a variant")
+	}
+	if _, err := ParseQuality("hd"); err == nil {
+		t.Error("unknown quality was accepted")
+	}
+}
+
+func TestQualityRankServers(t *testing.T) {
+	servers := []Server{{Name: "1080p"}, {Name: "720p"}, {Name: "480p"}}
+	q, _ := ParseQuality("<=720p")
+	ranked := q.RankServers(servers)
+	if ranked[0].Name != "720p" || ranked[1].Name != "480p" || ranked[2].Name != "1080p" {
+		t.Errorf("ranked = %v", ranked)
+	}
+
+	// Servers that are not resolutions keep the provider's order
+	hosts := []Server{{Name: "UpCloud"}, {Name: "Vidcloud"}}
+	if ranked := q.RankServers(hosts); ranked[0].Name != "UpCloud" {
+		t.Errorf("ranked = %v", ranked)
+	}
+}
@@ -0,0 +1,263 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rabbitFlavour is one family of rabbitstream/megacloud-style embeds: where
+// its sources endpoint is and which player script holds the key.
+type rabbitFlavour struct {
+	path    *regexp.Regexp
+	sources string
+	script  string
+}
+
+var rabbitFlavours = []rabbitFlavour{
+	{regexp.MustCompile(`^/v2/embed-4/([^/?#]+)`), "/ajax/v2/embed-4/getSources?id=%s", "/js/player/prod/e4-player.min.js"},
+	{regexp.MustCompile(`^/embed-4/([^/?#]+)`), "/ajax/embed-4/getSources?id=%s", "/js/player/prod/e4-player.min.js"},
+	{regexp.MustCompile(`^/embed-2/e-1/([^/?#]+)`), "/embed-2/ajax/e-1/getSources?id=%s", "/js/player/a/prod/e1-player.min.js"},
+	{regexp.MustCompile(`^/embed-1/v3/e-1/([^/?#]+)`), "/embed-1/v3/e-1/getSources?id=%s", "/js/player/m/v3/e1-player.min.js"},
+}
+
+func init() {
+	RegisterExtractor(&HostExtractor{
+		ExtractorName: "rabbitstream",
+		HostPatterns: []string{
+			"rabbitstream.net", "megacloud.tv", "megacloud.blog", "megacloud.club",
+			"cloudvidz.net", "dokicloud.one", "rapid-cloud.co", "videostr.net",
+		},
+		Func: DecryptRabbitStream,
+	})
+}
+
+// DecryptRabbitStream resolves rabbitstream/megacloud embeds locally: it
+// fetches the encrypted sources, reads the key out of the player script and
+// decrypts them with AES.
+func DecryptRabbitStream(ctx context.Context, embedLink string, client *http.Client) (*Stream, error) {
+	u, err := url.Parse(embedLink)
+	if err != nil {
+		return nil, err
+	}
+	origin := u.Scheme + "://" + u.Host
+
+	var flavour *rabbitFlavour
+	var id string
+	for i := range rabbitFlavours {
+		if m := rabbitFlavours[i].path.FindStringSubmatch(u.Path); m != nil {
+			flavour, id = &rabbitFlavours[i], m[1]
+			break
+		}
+	}
+	if flavour == nil {
+		return nil, fmt.Errorf("unknown embed path: %s", u.Path)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", origin+fmt.Sprintf(flavour.sources, url.QueryEscape(id)), nil)
+	req.Header.Set("Referer", embedLink)
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("getSources returned status %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Sources json.RawMessage  `json:"sources"`
+		Tracks  []DecryptedTrack `json:"tracks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	var sources []DecryptedSource
+	var encrypted string
+	// Encrypted sources come as a string, plain ones as a list
+	if json.Unmarshal(data.Sources, &encrypted) == nil {
+		key, err := rabbitKey(ctx, origin+flavour.script, client)
+		if err != nil {
+			return nil, err
+		}
+		secret, cipherText, err := splitSecret(encrypted, key)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := decryptOpenSSL(cipherText, secret)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(plain, &sources); err != nil {
+			return nil, fmt.Errorf("decrypted sources: %w", err)
+		}
+	} else if err := json.Unmarshal(data.Sources, &sources); err != nil {
+		return nil, fmt.Errorf("sources: %w", err)
+	}
+
+	var variants []StreamQuality
+	for _, s := range sources {
+		if s.File != "" {
+			variants = append(variants, StreamQuality{URL: s.File, Label: s.Label})
+		}
+	}
+	if len(variants) == 0 {
+		return nil, errors.New("no sources found")
+	}
+
+	return &Stream{
+		Variants:  variants,
+		Referer:   origin + "/",
+		Subtitles: subtitlesFromTracks(data.Tracks),
+		Container: ContainerFromURL(variants[0].URL),
+	}, nil
+}
+
+var (
+	rabbitKeysMu sync.Mutex
+	rabbitKeys   = map[string][][2]int{}
+)
+
+// case 0x1d:a=b,c=d; names the offset and length of one part of the secret
+var rabbitCaseRe = regexp.MustCompile(`case\s*0x[0-9a-fA-F]+:\s*\w+\s*=\s*(\w+)\s*,\s*\w+\s*=\s*(\w+);`)
+
+// rabbitKey reads the (offset, length) pairs locating the secret inside the
+// encrypted sources out of the player script. Keys are cached per script.
+func rabbitKey(ctx context.Context, scriptURL string, client *http.Client) ([][2]int, error) {
+	rabbitKeysMu.Lock()
+	key, ok := rabbitKeys[scriptURL]
+	rabbitKeysMu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", scriptURL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("player script returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err = parseRabbitKey(string(body))
+	if err != nil {
+		return nil, err
+	}
+	rabbitKeysMu.Lock()
+	rabbitKeys[scriptURL] = key
+	rabbitKeysMu.Unlock()
+	return key, nil
+}
+
+func parseRabbitKey(script string) ([][2]int, error) {
+	// Values are either literals or variables assigned a literal elsewhere
+	value := func(name string) (int, bool) {
+		if n, ok := parseNumber(name); ok {
+			return n, true
+		}
+		m := regexp.MustCompile(`[,;{\s]` + regexp.QuoteMeta(name) + `\s*=\s*(0x[0-9a-fA-F]+|\d+)\b`).FindStringSubmatch(script)
+		if m == nil {
+			return 0, false
+		}
+		return parseNumber(m[1])
+	}
+
+	var key [][2]int
+	for _, m := range rabbitCaseRe.FindAllStringSubmatch(script, -1) {
+		if strings.Contains(m[0], "partKey") {
+			continue
+		}
+		offset, ok1 := value(m[1])
+		length, ok2 := value(m[2])
+		if ok1 && ok2 {
+			key = append(key, [2]int{offset, length})
+		}
+	}
+	if len(key) == 0 {
+		return nil, errors.New("no key found in player script")
+	}
+	return key, nil
+}
+
+// splitSecret cuts the secret out of the encrypted sources. Each pair is an
+// offset into what is left of the string once the earlier parts are removed.
+func splitSecret(encrypted string, key [][2]int) (secret, rest string, err error) {
+	var b strings.Builder
+	rest = encrypted
+	for _, k := range key {
+		offset, length := k[0], k[1]
+		if offset < 0 || length < 0 || offset+length > len(rest) {
+			return "", "", errors.New("key does not fit the encrypted sources")
+		}
+		b.WriteString(rest[offset : offset+length])
+		rest = rest[:offset] + rest[offset+length:]
+	}
+	return b.String(), rest, nil
+}
+
+// decryptOpenSSL decrypts base64 data in OpenSSL's "Salted__" format, as
+// produced by CryptoJS.AES.encrypt with a passphrase.
+func decryptOpenSSL(data, passphrase string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < 16 || string(raw[:8]) != "Salted__" {
+		return nil, errors.New("encrypted sources are not salted")
+	}
+	salt, raw := raw[8:16], raw[16:]
+	if len(raw) == 0 || len(raw)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted sources have a bad length")
+	}
+
+	// EVP_BytesToKey with MD5, for a 32 byte key and a 16 byte IV
+	var derived, prev []byte
+	for len(derived) < 48 {
+		h := md5.Sum(append(append(prev, passphrase...), salt...))
+		prev = h[:]
+		derived = append(derived, prev...)
+	}
+
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(raw))
+	cipher.NewCBCDecrypter(block, derived[32:48]).CryptBlocks(plain, raw)
+
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("wrong key for encrypted sources")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+func parseNumber(s string) (int, bool) {
+	base := 10
+	if hex, ok := strings.CutPrefix(s, "0x"); ok {
+		s, base = hex, 16
+	}
+	n, err := strconv.ParseInt(s, base, 64)
+	return int(n), err == nil
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// encryptOpenSSL is the counterpart of decryptOpenSSL, as CryptoJS does it.
+func encryptOpenSSL(plain []byte, passphrase string) string {
+	salt := []byte("12345678")
+	var derived, prev []byte
+	for len(derived) < 48 {
+		h := md5.Sum(append(append(prev, passphrase...), salt...))
+		prev = h[:]
+		derived = append(derived, prev...)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	plain = append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, _ := aes.NewCipher(derived[:32])
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, derived[32:48]).CryptBlocks(out, plain)
+	return base64.StdEncoding.EncodeToString(append(append([]byte("Salted__"), salt...), out...))
+}
+
+func TestDecryptRabbitStream(t *testing.T) {
+	sources, _ := json.Marshal([]DecryptedSource{{File: "https://cdn.example/hls/master.m3u8", Type: "hls"}})
+	first, second := "k3Y9", "pQ7wZ"
+	encrypted := encryptOpenSSL(sources, first+second)
+	// The secret parts are spliced in where the player script says
+	encrypted = encrypted[:16] + second + encrypted[16:]
+	encrypted = encrypted[:3] + first + encrypted[3:]
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ajax/v2/embed-4/getSources":
+			if r.URL.Query().Get("id") != "mK5vtM2Gp1ZT" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"sources":   encrypted,
+				"encrypted": true,
+				"tracks": []DecryptedTrack{
+					{File: "https://cdn.example/en.vtt", Kind: "captions", Label: "English"},
+					{File: "https://cdn.example/thumbs.vtt", Kind: "thumbnails"},
+				},
+			})
+		case "/js/player/prod/e4-player.min.js":
+			fmt.Fprint(w, `var b=0x3,f=5;switch(x){case 0x0:a=b,c=0x4;break;case 0x1:d=0x10,e=f;break;case 0x2:g=partKey,h=0x1;}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	stream, err := DecryptRabbitStream(context.Background(), srv.URL+"/v2/embed-4/mK5vtM2Gp1ZT?z=", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.URL() != "https://cdn.example/hls/master.m3u8" || stream.Container != ContainerHLS {
+		t.Errorf("stream = %+v", stream)
+	}
+	if stream.Referer != srv.URL+"/" {
+		t.Errorf("referer = %q", stream.Referer)
+	}
+	if len(stream.Subtitles) != 1 || stream.Subtitles[0].Language != "en" {
+		t.Errorf("subtitles = %+v", stream.Subtitles)
+	}
+}
+
+func TestRemoteDecoderSwitch(t *testing.T) {
+	ConfigureDecoder(false)
+	if _, err := DecryptStream(context.Background(), "https://unknown.example/e/1", http.DefaultClient); err != ErrDecoderDisabled {
+		t.Errorf("err = %v, want ErrDecoderDisabled", err)
+	}
+}
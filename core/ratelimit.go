@@ -0,0 +1,217 @@
+package core
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostLimit caps the requests sent to one provider or host: Rate requests per
+// second with bursts of up to Burst, and at most Concurrency at a time. Zero
+// values mean no limit.
+type HostLimit struct {
+	Rate        float64 `yaml:"rate"`
+	Burst       int     `yaml:"burst"`
+	Concurrency int     `yaml:"concurrency"`
+}
+
+// MAX_RETRY_AFTER is the longest Retry-After luffy waits for; a server asking
+// for more gets its 429 passed on.
+const MAX_RETRY_AFTER = time.Minute
+
+// hostLimiter is a token bucket with a concurrency budget for one key.
+type hostLimiter struct {
+	limit HostLimit
+	sem   chan struct{}
+
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newHostLimiter(limit HostLimit) *hostLimiter {
+	if limit.Rate > 0 && limit.Burst < 1 {
+		limit.Burst = int(math.Max(1, math.Ceil(limit.Rate)))
+	}
+	l := &hostLimiter{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+	if limit.Concurrency > 0 {
+		l.sem = make(chan struct{}, limit.Concurrency)
+	}
+	return l
+}
+
+// wait takes a token, sleeping until one is free and the host is not paused.
+func (l *hostLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	var delay time.Duration
+	if l.limit.Rate > 0 {
+		l.tokens = math.Min(float64(l.limit.Burst), l.tokens+now.Sub(l.last).Seconds()*l.limit.Rate)
+		l.last = now
+		// Take the token now, even when it is only free later, so waiters queue up
+		l.tokens--
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens / l.limit.Rate * float64(time.Second))
+		}
+	}
+	if pause := l.pausedUntil.Sub(now); pause > delay {
+		delay = pause
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		if l.limit.Rate > 0 {
+			l.mu.Lock()
+			l.tokens++
+			l.mu.Unlock()
+		}
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (l *hostLimiter) pause(until time.Time) {
+	l.mu.Lock()
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	l.mu.Unlock()
+}
+
+// limitTransport holds requests back to stay within the limit of the site or
+// host they go to. Limits are keyed by site name (see RegisterSite), which
+// covers its mirrors, or by host pattern; "default" applies to every other
+// host, each with its own bucket.
+type limitTransport struct {
+	base   http.RoundTripper
+	limits map[string]HostLimit
+
+	mu       sync.Mutex
+	limiters map[string]*hostLimiter
+}
+
+func newLimitTransport(base http.RoundTripper, limits map[string]HostLimit) *limitTransport {
+	return &limitTransport{base: base, limits: limits, limiters: map[string]*hostLimiter{}}
+}
+
+func (t *limitTransport) limiter(req *http.Request) *hostLimiter {
+	host := strings.ToLower(req.URL.Hostname())
+	key, limit, ok := "", HostLimit{}, false
+	if name, _, _, found := siteForURL(req.URL.String()); found {
+		if l, listed := t.limits[name]; listed {
+			key, limit, ok = name, l, true
+		}
+	}
+	if !ok {
+		// The most specific host pattern wins
+		for pattern, l := range t.limits {
+			if strings.Contains(pattern, ".") && len(pattern) > len(key) && MatchHost(pattern, host) {
+				key, limit, ok = pattern, l, true
+			}
+		}
+	}
+	if !ok {
+		if limit, ok = t.limits["default"]; !ok {
+			return nil
+		}
+		key = "host:" + host
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, found := t.limiters[key]
+	if !found {
+		l = newHostLimiter(limit)
+		t.limiters[key] = l
+	}
+	return l
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := t.limiter(req)
+	if l == nil {
+		return t.base.RoundTrip(req)
+	}
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	release := func() {
+		if l.sem != nil {
+			<-l.sem
+		}
+	}
+	if err := l.wait(req.Context()); err != nil {
+		release()
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// The request holds its slot until the body has been read
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// pause holds back every request to the host of req until the given time.
+func (t *limitTransport) pause(req *http.Request, until time.Time) {
+	if l := t.limiter(req); l != nil {
+		l.pause(until)
+	}
+}
+
+// releaseBody frees the slot of a request once its body has been read to
+// the end, failed, or been closed, whichever comes first.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// retryAfter reads the Retry-After header, in seconds or as a date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
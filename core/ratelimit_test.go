@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostLimits(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	RegisterSite("limittest", srv.URL)
+	err := ConfigureHTTP(HTTPConfig{
+		CookieFile: "none",
+		Limits:     map[string]HostLimit{"limittest": {Rate: 20, Burst: 2, Concurrency: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureHTTP(HTTPConfig{CookieFile: "none"})
+	client := NewClient()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL + "/ajax/episode/servers/1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	// Two go at once, the other four wait 50ms each for a token
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("6 requests took %v, faster than 20/s with a burst of 2", elapsed)
+	}
+	if peak.Load() > 2 {
+		t.Errorf("%d requests in flight, want at most 2", peak.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if err := ConfigureHTTP(HTTPConfig{Retries: 1, RetryBackoff: time.Millisecond, CookieFile: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureHTTP(HTTPConfig{CookieFile: "none"})
+
+	var hits []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, time.Now())
+		switch {
+		case r.URL.Path == "/banned":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case len(hits) == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	client := NewClient()
+
+	resp, err := client.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || len(hits) != 2 {
+		t.Fatalf("status %d after %d requests", resp.StatusCode, len(hits))
+	}
+	if wait := hits[1].Sub(hits[0]); wait < time.Second {
+		t.Errorf("retried after %v, before Retry-After", wait)
+	}
+
+	// Waiting an hour is not worth it, the 429 is passed on
+	resp, err = client.Get(srv.URL + "/banned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || len(hits) != 3 {
+		t.Errorf("banned: status %d after %d requests", resp.StatusCode, len(hits)-2)
+	}
+}
+
+type bodyTransport string
+
+func (b bodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(string(b))), Request: req}, nil
+}
+
+func TestLimitSlotFreedOnEOF(t *testing.T) {
+	tr := newLimitTransport(bodyTransport("not found"), map[string]HostLimit{"default": {Concurrency: 1}})
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		req, _ := http.NewRequestWithContext(ctx, "GET", "https://slots.example/", nil)
+		resp, err := tr.RoundTrip(req)
+		cancel()
+		if err != nil {
+			t.Fatal("slot still held by a read but unclosed body:", err)
+		}
+		// Read to the end without closing
+		io.ReadAll(resp.Body)
+	}
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RefererPolicy tells the CLI which referer to hand to the player and downloader.
+type RefererPolicy int
+
+const (
+	// RefererEmbed uses the link returned by GetLink, or the referer reported by the extractor.
+	RefererEmbed RefererPolicy = iota
+	// RefererEmbedOrigin uses scheme://host/ of the link returned by GetLink.
+	RefererEmbedOrigin
+	// RefererPage uses the provider page the title was selected from.
+	RefererPage
+)
+
+type Capabilities struct {
+	// NeedsDecryption means GetLink returns an embed page that has to go through DecryptStream.
+	NeedsDecryption bool
+	// SupportsSeries means the provider can return series and walk seasons/episodes.
+	SupportsSeries bool
+	// DirectLinks means GetLink returns a final media URL, so no HLS variant probing is done.
+	DirectLinks bool
+	// PreferredServer is matched against server names; empty means take the first server.
+	PreferredServer string
+	Referer         RefererPolicy
+}
+
+type ProviderInfo struct {
+	Name         string
+	Aliases      []string
+	Capabilities Capabilities
+	New          func(client *http.Client) Provider
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderInfo{}
+)
+
+// RegisterProvider makes a provider available to the CLI under its name and aliases.
+// Registering the same name twice replaces the earlier entry.
+func RegisterProvider(info ProviderInfo) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[strings.ToLower(info.Name)] = info
+}
+
+// LookupProvider finds a registered provider by name or alias, ignoring case.
+func LookupProvider(name string) (ProviderInfo, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	if info, ok := providers[strings.ToLower(name)]; ok {
+		return info, true
+	}
+	for _, info := range providers {
+		for _, alias := range info.Aliases {
+			if strings.EqualFold(alias, name) {
+				return info, true
+			}
+		}
+	}
+	return ProviderInfo{}, false
+}
+
+// RegisteredProviders returns all registered providers sorted by name.
+func RegisteredProviders() []ProviderInfo {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	list := make([]ProviderInfo, 0, len(providers))
+	for _, info := range providers {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
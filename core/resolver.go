@@ -0,0 +1,490 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FailureKind classifies why a resolution attempt did not produce a stream.
+type FailureKind string
+
+const (
+	// FailLookup means an alternative provider could not find the title, season or episode.
+	FailLookup FailureKind = "lookup"
+	// FailServers means listing the servers of the title or episode failed.
+	FailServers FailureKind = "servers"
+	// FailLink means GetLink failed for a server.
+	FailLink FailureKind = "link"
+	// FailDecrypt means the embed returned by GetLink could not be extracted.
+	FailDecrypt FailureKind = "decrypt"
+	// FailValidate means a stream was returned but did not pass validation.
+	FailValidate FailureKind = "validate"
+	// FailTimeout means a stage ran into its deadline.
+	FailTimeout FailureKind = "timeout"
+)
+
+// Failover configures how the resolver moves on after a failed attempt.
+type Failover struct {
+	// Providers are tried, in order, once every server of the selected
+	// provider failed. None are by default.
+	Providers []string `yaml:"providers"`
+	// RetryOn lists the failures that move on to the next server or provider;
+	// any other failure ends the resolution.
+	RetryOn []FailureKind `yaml:"retry_on"`
+}
+
+func DefaultFailover() Failover {
+	return Failover{
+		RetryOn: []FailureKind{FailLookup, FailServers, FailLink, FailDecrypt, FailValidate, FailTimeout},
+	}
+}
+
+func (f Failover) Retryable(kind FailureKind) bool {
+	for _, k := range f.RetryOn {
+		if strings.EqualFold(string(k), string(kind)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Attempt is one step of a resolution, successful or not.
+type Attempt struct {
+	Provider string
+	Server   string
+	Failure  FailureKind
+	Err      error
+	Duration time.Duration
+}
+
+func (a Attempt) String() string {
+	name := a.Provider
+	if a.Server != "" {
+		name += "/" + a.Server
+	}
+	if a.Err == nil {
+		return fmt.Sprintf("%s: ok (%s)", name, a.Duration.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s: %s: %v", name, a.Failure, a.Err)
+}
+
+// ResolveError is returned when every server and provider failed.
+type ResolveError struct {
+	Attempts []Attempt
+}
+
+func (e *ResolveError) Error() string {
+	if len(e.Attempts) == 0 {
+		return "no servers to try"
+	}
+	last := e.Attempts[len(e.Attempts)-1]
+	return fmt.Sprintf("no working stream after %d attempts, last: %s", len(e.Attempts), last)
+}
+
+// Target describes what is being resolved independently of any provider,
+// so that alternative providers can look the same title up.
+type Target struct {
+	Title   string
+	Year    string
+	Type    MediaType
+	TMDB    string
+	Season  int
+	Episode int
+	// Sources are the hits a federated search already found for this title.
+	Sources []Source
+}
+
+// Candidate is a provider together with the ref to list servers from:
+// an episode ref for series, the title ref for movies.
+type Candidate struct {
+	Info     ProviderInfo
+	Provider Provider
+	Ref      MediaRef
+	// PageURL is the provider page of the title, used as referer by RefererPage providers.
+	PageURL string
+}
+
+// Resolution is the stream that validated and where it came from.
+type Resolution struct {
+	Stream   *Stream
+	Info     ProviderInfo
+	Server   Server
+	PageURL  string
+	Attempts []Attempt
+}
+
+// Resolver walks servers and then alternative providers until a stream
+// validates. Servers and providers that failed earlier in a batch, such as
+// ones whose streams did not validate, are tried after the others.
+type Resolver struct {
+	Client   *http.Client
+	Timeouts Timeouts
+	Failover Failover
+	// Validate rejects streams that are not worth handing to the player,
+	// such as Validator.Validate. When nil, any stream with at least one
+	// variant is accepted.
+	Validate func(ctx context.Context, stream *Stream) error
+	// Report is called after every attempt.
+	Report func(Attempt)
+	// ServerPreference ranks servers by name; when empty the provider's
+	// PreferredServer is used.
+	ServerPreference []string
+	// Choose lets the user pick the first server to try on the selected
+	// provider. It returns an index into servers, or -1 to keep the ranking.
+	Choose func(provider string, servers []Server) int
+	// Quality orders servers named after a resolution, such as movies4u's.
+	Quality Quality
+
+	titles map[string]*titleLookup
+	// failures counts the failed attempts of each provider and server over
+	// the batch, so that later episodes try the ones that worked first
+	failures map[string]int
+}
+
+type titleLookup struct {
+	provider Provider
+	pageURL  string
+	media    MediaRef
+	err      error
+	episodes map[int][]Episode
+}
+
+// Resolve tries the servers of the primary candidate first, then the same
+// title on the failover providers.
+func (r *Resolver) Resolve(ctx context.Context, primary Candidate, target Target) (*Resolution, error) {
+	res := &Resolution{}
+
+	done, err := r.tryCandidate(ctx, primary, res, true)
+	if done || err != nil {
+		return res, err
+	}
+
+	alternatives := r.alternatives(primary.Info, target)
+	sort.SliceStable(alternatives, func(i, j int) bool {
+		return r.failures[alternatives[i].Name] < r.failures[alternatives[j].Name]
+	})
+	for _, info := range alternatives {
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		start := time.Now()
+		cand, err := r.lookup(ctx, info, target)
+		if err != nil {
+			if stop := r.record(res, Attempt{Provider: info.Name, Failure: classify(FailLookup, err), Err: err, Duration: time.Since(start)}); stop {
+				return res, err
+			}
+			continue
+		}
+		done, err := r.tryCandidate(ctx, cand, res, false)
+		if done || err != nil {
+			return res, err
+		}
+	}
+
+	return res, &ResolveError{Attempts: res.Attempts}
+}
+
+// tryCandidate walks the servers of one provider. It reports done once a
+// stream validated, and returns an error when a failure is not retryable.
+func (r *Resolver) tryCandidate(ctx context.Context, cand Candidate, res *Resolution, primary bool) (bool, error) {
+	start := time.Now()
+	metaCtx, cancel := r.Timeouts.WithStage(ctx, StageMetadata)
+	servers, err := ListServers(metaCtx, cand.Provider, cand.Ref)
+	cancel()
+	if err == nil && len(servers) == 0 {
+		err = errors.New("no servers found")
+	}
+	if err != nil {
+		if stop := r.record(res, Attempt{Provider: cand.Info.Name, Failure: classify(FailServers, err), Err: err, Duration: time.Since(start)}); stop {
+			return false, err
+		}
+		return false, nil
+	}
+
+	prefs := r.ServerPreference
+	if len(prefs) == 0 && cand.Info.Capabilities.PreferredServer != "" {
+		prefs = []string{cand.Info.Capabilities.PreferredServer}
+	}
+	servers = r.Quality.RankServers(RankServers(servers, prefs))
+	sort.SliceStable(servers, func(i, j int) bool {
+		return r.failures[cand.Info.Name+"/"+servers[i].Name] < r.failures[cand.Info.Name+"/"+servers[j].Name]
+	})
+	if primary && r.Choose != nil && len(servers) > 1 {
+		if i := r.Choose(cand.Info.Name, servers); i > 0 && i < len(servers) {
+			servers = append([]Server{servers[i]}, append(servers[:i:i], servers[i+1:]...)...)
+		}
+	}
+
+	for _, server := range servers {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		start := time.Now()
+		stream, kind, err := r.resolveServer(ctx, cand, server)
+		attempt := Attempt{Provider: cand.Info.Name, Server: server.Name, Failure: kind, Err: err, Duration: time.Since(start)}
+		if err == nil {
+			r.record(res, attempt)
+			res.Stream = stream
+			res.Info = cand.Info
+			res.Server = server
+			res.PageURL = cand.PageURL
+			return true, nil
+		}
+		if stop := r.record(res, attempt); stop {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (r *Resolver) resolveServer(ctx context.Context, cand Candidate, server Server) (*Stream, FailureKind, error) {
+	caps := cand.Info.Capabilities
+
+	linkCtx, cancel := r.Timeouts.WithStage(ctx, StageLink)
+	stream, err := cand.Provider.GetLink(linkCtx, server.Ref)
+	cancel()
+	if err != nil {
+		return nil, classify(FailLink, err), err
+	}
+	if stream == nil || len(stream.Variants) == 0 {
+		return nil, FailLink, errors.New("no link returned")
+	}
+
+	if stream.NeedsExtraction() || (stream.Container == "" && caps.NeedsDecryption) {
+		embed := stream.URL()
+		decryptCtx, cancel := r.Timeouts.WithStage(ctx, StageDecrypt)
+		extracted, err := DecryptStream(decryptCtx, embed, r.Client)
+		cancel()
+		if err != nil {
+			return nil, classify(FailDecrypt, err), err
+		}
+
+		if caps.Referer == RefererEmbedOrigin {
+			// Use the main URL of the embed link as referrer
+			if parsedURL, err := url.Parse(embed); err == nil {
+				extracted.Referer = fmt.Sprintf("%s://%s/", parsedURL.Scheme, parsedURL.Host)
+			} else {
+				extracted.Referer = embed
+			}
+		} else if extracted.Referer == "" {
+			extracted.Referer = embed
+		}
+		stream = extracted
+	}
+
+	if len(stream.Variants) == 0 || stream.URL() == "" {
+		return nil, FailValidate, errors.New("no playable stream")
+	}
+	if stream.Referer == "" {
+		if caps.Referer == RefererPage {
+			stream.Referer = cand.PageURL
+		} else {
+			stream.Referer = stream.URL()
+		}
+	}
+
+	if r.Validate != nil {
+		probeCtx, cancel := r.Timeouts.WithStage(ctx, StageProbe)
+		err := r.Validate(probeCtx, stream)
+		cancel()
+		if err != nil {
+			return nil, classify(FailValidate, err), err
+		}
+	}
+	return stream, "", nil
+}
+
+// record stores and reports an attempt and tells whether resolution has to stop.
+func (r *Resolver) record(res *Resolution, a Attempt) bool {
+	res.Attempts = append(res.Attempts, a)
+	if r.Report != nil {
+		r.Report(a)
+	}
+	if a.Err == nil {
+		return false
+	}
+	if !errors.Is(a.Err, context.Canceled) {
+		if r.failures == nil {
+			r.failures = map[string]int{}
+		}
+		r.failures[a.Provider]++
+		if a.Server != "" {
+			r.failures[a.Provider+"/"+a.Server]++
+		}
+	}
+	if errors.Is(a.Err, context.Canceled) {
+		return true
+	}
+	return !r.Failover.Retryable(a.Failure)
+}
+
+// alternatives lists the providers to fall back to: those a federated search
+// already found the title on, then the configured failover providers.
+func (r *Resolver) alternatives(primary ProviderInfo, target Target) []ProviderInfo {
+	var list []ProviderInfo
+	seen := map[string]bool{primary.Name: true}
+	add := func(info ProviderInfo) {
+		if seen[info.Name] {
+			return
+		}
+		if target.Type == Series && !info.Capabilities.SupportsSeries {
+			return
+		}
+		seen[info.Name] = true
+		list = append(list, info)
+	}
+
+	for _, src := range target.Sources {
+		add(src.Provider)
+	}
+	for _, name := range r.Failover.Providers {
+		if info, ok := LookupProvider(name); ok {
+			add(info)
+		}
+	}
+	return list
+}
+
+// lookup finds the target on another provider. Titles and episode lists are
+// cached, so resolving a batch of episodes searches each provider only once.
+func (r *Resolver) lookup(ctx context.Context, info ProviderInfo, target Target) (Candidate, error) {
+	if r.titles == nil {
+		r.titles = map[string]*titleLookup{}
+	}
+	t, ok := r.titles[info.Name]
+	if !ok {
+		t = r.lookupTitle(ctx, info, target)
+		if !errors.Is(t.err, context.Canceled) {
+			r.titles[info.Name] = t
+		}
+	}
+	if t.err != nil {
+		return Candidate{}, t.err
+	}
+
+	cand := Candidate{Info: info, Provider: t.provider, Ref: t.media, PageURL: t.pageURL}
+	if target.Type != Series {
+		return cand, nil
+	}
+
+	episodes, ok := t.episodes[target.Season]
+	if !ok {
+		metaCtx, cancel := r.Timeouts.WithStage(ctx, StageMetadata)
+		var err error
+		episodes, err = listEpisodes(metaCtx, t.provider, t.media, target.Season)
+		cancel()
+		if err != nil {
+			return Candidate{}, err
+		}
+		t.episodes[target.Season] = episodes
+	}
+	for i, ep := range episodes {
+		if ep.Ref.Episode == target.Episode || (ep.Ref.Episode == 0 && i+1 == target.Episode) {
+			cand.Ref = ep.Ref
+			return cand, nil
+		}
+	}
+	return Candidate{}, fmt.Errorf("episode %d of season %d not found", target.Episode, target.Season)
+}
+
+func (r *Resolver) lookupTitle(ctx context.Context, info ProviderInfo, target Target) *titleLookup {
+	t := &titleLookup{provider: info.New(r.Client), episodes: map[int][]Episode{}}
+
+	var hit *SearchResult
+	for _, src := range target.Sources {
+		if src.Provider.Name == info.Name {
+			result := src.Result
+			hit = &result
+			break
+		}
+	}
+	if hit == nil {
+		searchCtx, cancel := r.Timeouts.WithStage(ctx, StageSearch)
+		results, err := t.provider.Search(searchCtx, target.Title)
+		cancel()
+		if err != nil {
+			t.err = err
+			return t
+		}
+		for i := range results {
+			if target.Matches(results[i]) {
+				hit = &results[i]
+				break
+			}
+		}
+		if hit == nil {
+			t.err = fmt.Errorf("%q not found", target.Title)
+			return t
+		}
+	}
+
+	metaCtx, cancel := r.Timeouts.WithStage(ctx, StageMetadata)
+	media, err := t.provider.GetMediaID(metaCtx, hit.URL)
+	cancel()
+	if err != nil {
+		t.err = err
+		return t
+	}
+	if media.Type == "" {
+		media.Type = hit.Type
+	}
+	t.media = media
+	t.pageURL = hit.URL
+	return t
+}
+
+// Matches tells whether a search result is the same title, using the same
+// rules as the federated search merge.
+func (t Target) Matches(r SearchResult) bool {
+	if r.Type != t.Type || normalizeTitle(r.Title) != normalizeTitle(t.Title) {
+		return false
+	}
+	if t.TMDB != "" && r.TMDB != "" && t.TMDB != r.TMDB {
+		return false
+	}
+	year, ryear := normalizeYear(t.Year), normalizeYear(r.Year)
+	return year == "" || ryear == "" || year == ryear
+}
+
+func listEpisodes(ctx context.Context, p Provider, media MediaRef, season int) ([]Episode, error) {
+	seasons, err := p.GetSeasons(ctx, media)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range seasons {
+		if s.Ref.Season == season {
+			return p.GetEpisodes(ctx, s.Ref)
+		}
+	}
+	return nil, fmt.Errorf("season %d not found", season)
+}
+
+// ListServers lists the servers of an episode ref, or of a movie's title ref,
+// for which providers return the servers from GetEpisodes.
+func ListServers(ctx context.Context, p Provider, ref MediaRef) ([]Server, error) {
+	if ref.Kind != KindTitle {
+		return p.GetServers(ctx, ref)
+	}
+	episodes, err := p.GetEpisodes(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]Server, 0, len(episodes))
+	for _, e := range episodes {
+		servers = append(servers, Server{Ref: e.Ref, Name: e.Name})
+	}
+	return servers, nil
+}
+
+func classify(kind FailureKind, err error) FailureKind {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FailTimeout
+	}
+	return kind
+}
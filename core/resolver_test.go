@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeProvider serves one title whose episodes all have the same servers.
+// GetLink fails for the servers listed in failing.
+type fakeProvider struct {
+	name     string
+	results  []SearchResult
+	seasons  int
+	episodes int
+	servers  []string
+	failing  map[string]error
+	links    []string
+}
+
+func (p *fakeProvider) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	return p.results, nil
+}
+
+func (p *fakeProvider) GetMediaID(ctx context.Context, url string) (MediaRef, error) {
+	return MediaRef{Provider: p.name, Kind: KindTitle, ID: url}, nil
+}
+
+func (p *fakeProvider) GetSeasons(ctx context.Context, media MediaRef) ([]Season, error) {
+	var seasons []Season
+	for s := 1; s <= p.seasons; s++ {
+		ref := media.Child(KindSeason, fmt.Sprint(s))
+		ref.Season = s
+		seasons = append(seasons, Season{Ref: ref, Name: fmt.Sprintf("Season %d", s)})
+	}
+	return seasons, nil
+}
+
+func (p *fakeProvider) GetEpisodes(ctx context.Context, ref MediaRef) ([]Episode, error) {
+	var episodes []Episode
+	for e := 1; e <= p.episodes; e++ {
+		ep := ref.Child(KindEpisode, fmt.Sprint(e))
+		ep.Episode = e
+		episodes = append(episodes, Episode{Ref: ep, Name: fmt.Sprintf("Episode %d", e)})
+	}
+	return episodes, nil
+}
+
+func (p *fakeProvider) GetServers(ctx context.Context, episode MediaRef) ([]Server, error) {
+	var servers []Server
+	for _, name := range p.servers {
+		servers = append(servers, Server{Ref: episode.Child(KindServer, name), Name: name})
+	}
+	return servers, nil
+}
+
+func (p *fakeProvider) GetLink(ctx context.Context, server MediaRef) (*Stream, error) {
+	p.links = append(p.links, server.ID)
+	if err := p.failing[server.ID]; err != nil {
+		return nil, err
+	}
+	link := fmt.Sprintf("https://%s.example/s%de%d/%s.m3u8", p.name, server.Season, server.Episode, server.ID)
+	return &Stream{Variants: []StreamQuality{{URL: link}}, Container: ContainerHLS}, nil
+}
+
+func fakeCandidate(p *fakeProvider, season, episode int) Candidate {
+	ref := MediaRef{Provider: p.name, Kind: KindEpisode, ID: fmt.Sprint(episode), Season: season, Episode: episode}
+	return Candidate{Info: ProviderInfo{Name: p.name, Capabilities: Capabilities{SupportsSeries: true}}, Provider: p, Ref: ref}
+}
+
+func TestResolverNextServer(t *testing.T) {
+	p := &fakeProvider{name: "fake", servers: []string{"upcloud", "vidcloud"}, failing: map[string]error{"upcloud": errors.New("404")}}
+	r := &Resolver{Failover: DefaultFailover()}
+
+	res, err := r.Resolve(context.Background(), fakeCandidate(p, 1, 1), Target{Type: Series, Season: 1, Episode: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Server.Name != "vidcloud" || len(res.Attempts) != 2 || res.Attempts[0].Failure != FailLink {
+		t.Errorf("server %q after %v", res.Server.Name, res.Attempts)
+	}
+}
+
+func TestResolverStopsOnNonRetryable(t *testing.T) {
+	p := &fakeProvider{name: "fake", servers: []string{"upcloud", "vidcloud"}, failing: map[string]error{"upcloud": errors.New("404")}}
+	r := &Resolver{Failover: Failover{RetryOn: []FailureKind{FailDecrypt}}}
+
+	res, err := r.Resolve(context.Background(), fakeCandidate(p, 1, 1), Target{Type: Series, Season: 1, Episode: 1})
+	if err == nil || len(res.Attempts) != 1 || len(p.links) != 1 {
+		t.Errorf("err %v after %v, links %v", err, res.Attempts, p.links)
+	}
+
+	// A validation failure is retried only when retry_on lists it
+	p = &fakeProvider{name: "fake", servers: []string{"upcloud", "vidcloud"}}
+	r.Validate = func(ctx context.Context, s *Stream) error { return errors.New("403") }
+	if res, err := r.Resolve(context.Background(), fakeCandidate(p, 1, 1), Target{Type: Series}); err == nil || res.Attempts[0].Failure != FailValidate || len(res.Attempts) != 1 {
+		t.Errorf("validate failure: err %v after %v", err, res.Attempts)
+	}
+}
+
+func TestResolverFailsOverToAlternative(t *testing.T) {
+	primary := &fakeProvider{name: "fake", servers: []string{"upcloud"}, failing: map[string]error{"upcloud": errors.New("404")}}
+	alt := &fakeProvider{
+		name: "fakealt",
+		results: []SearchResult{
+			{Title: "Dark", Year: "1990", Type: Series, URL: "dark-1990"},
+			{Title: "Dark Matter", Year: "2017", Type: Series, URL: "dark-matter"},
+			{Title: "The Dark", Year: "2017", Type: Movie, URL: "dark-movie"},
+			{Title: "Dark", Year: "2017", Type: Series, URL: "dark-2017"},
+		},
+		seasons:  3,
+		episodes: 8,
+		servers:  []string{"megacloud"},
+	}
+	RegisterProvider(ProviderInfo{
+		Name:         "fakealt",
+		Capabilities: Capabilities{SupportsSeries: true},
+		New:          func(*http.Client) Provider { return alt },
+	})
+	// Movie-only providers are skipped for series
+	RegisterProvider(ProviderInfo{Name: "fakemovies", New: func(*http.Client) Provider { t.Error("movie provider looked up"); return alt }})
+
+	r := &Resolver{Failover: Failover{Providers: []string{"fakemovies", "fakealt"}, RetryOn: DefaultFailover().RetryOn}}
+	target := Target{Title: "Dark", Year: "2017", Type: Series, Season: 2, Episode: 5}
+	res, err := r.Resolve(context.Background(), fakeCandidate(primary, 2, 5), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Info.Name != "fakealt" || res.PageURL != "dark-2017" || !strings.HasSuffix(res.Stream.URL(), "/s2e5/megacloud.m3u8") {
+		t.Errorf("resolved %s %s to %s", res.Info.Name, res.PageURL, res.Stream.URL())
+	}
+
+	// An episode the alternative does not have is a lookup failure
+	target.Episode = 9
+	res, err = r.Resolve(context.Background(), fakeCandidate(primary, 2, 9), target)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || res.Attempts[len(res.Attempts)-1].Failure != FailLookup {
+		t.Errorf("missing episode: err %v after %v", err, res.Attempts)
+	}
+}
+
+func TestResolverDemotesFailedServers(t *testing.T) {
+	p := &fakeProvider{name: "fake", servers: []string{"upcloud", "vidcloud", "akcloud"}}
+	r := &Resolver{
+		Failover:         DefaultFailover(),
+		ServerPreference: []string{"upcloud", "vidcloud"},
+		Validate: func(ctx context.Context, s *Stream) error {
+			if strings.Contains(s.URL(), "upcloud") {
+				return errors.New("segment 0: bad status: 403 Forbidden")
+			}
+			return nil
+		},
+	}
+
+	for episode := 1; episode <= 3; episode++ {
+		p.links = nil
+		res, err := r.Resolve(context.Background(), fakeCandidate(p, 1, episode), Target{Type: Series, Season: 1, Episode: episode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Server.Name != "vidcloud" {
+			t.Errorf("episode %d resolved on %s", episode, res.Server.Name)
+		}
+		// Only the first episode tries upcloud first; later ones try it last
+		want := "vidcloud"
+		if episode == 1 {
+			want = "upcloud"
+		}
+		if p.links[0] != want {
+			t.Errorf("episode %d tried %v", episode, p.links)
+		}
+	}
+}
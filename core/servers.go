@@ -0,0 +1,33 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+// RankServers orders servers by the first preference their name contains,
+// ignoring case. Servers matching no preference keep the provider's order
+// and come last. Preferences work the same for embed hosts such as
+// "upcloud" and for hdrezka translator names.
+func RankServers(servers []Server, prefs []string) []Server {
+	ranked := make([]Server, len(servers))
+	copy(ranked, servers)
+	if len(prefs) == 0 {
+		return ranked
+	}
+
+	rank := func(s Server) int {
+		name := strings.ToLower(s.Name)
+		for i, p := range prefs {
+			p = strings.ToLower(strings.TrimSpace(p))
+			if p != "" && strings.Contains(name, p) {
+				return i
+			}
+		}
+		return len(prefs)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return rank(ranked[i]) < rank(ranked[j])
+	})
+	return ranked
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Site is a host luffy talks to, with mirrors to fall back on when it is down.
+type Site struct {
+	BaseURL string   `yaml:"base_url"`
+	Mirrors []string `yaml:"mirrors"`
+}
+
+func init() {
+	RegisterSite("tmdb", TMDB_BASE_URL)
+	RegisterSite("decoder", DECODER)
+}
+
+var (
+	sitesMu       sync.RWMutex
+	siteDefaults  = map[string]string{}
+	siteOverrides = map[string]Site{}
+	siteActive    = map[string]string{}
+)
+
+// RegisterSite declares the built-in base URL of a site. Providers register
+// theirs under the provider name.
+func RegisterSite(name, baseURL string) {
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+	siteDefaults[strings.ToLower(name)] = strings.TrimRight(baseURL, "/")
+}
+
+// ConfigureSites applies the sites section of the config on top of the defaults.
+func ConfigureSites(sites map[string]Site) {
+	sitesMu.Lock()
+	defer sitesMu.Unlock()
+	siteOverrides = map[string]Site{}
+	for name, s := range sites {
+		siteOverrides[strings.ToLower(name)] = s
+	}
+	siteActive = map[string]string{}
+}
+
+// LookupSite returns the base URL and mirrors of a site. The built-in URL is
+// overridden by the config, which is overridden by LUFFY_<NAME>_URL and
+// LUFFY_<NAME>_MIRRORS (comma-separated) in the environment.
+func LookupSite(name string) Site {
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+	return lookupSite(strings.ToLower(name))
+}
+
+func lookupSite(name string) Site {
+	site := Site{BaseURL: siteDefaults[name]}
+	if o, ok := siteOverrides[name]; ok {
+		if o.BaseURL != "" {
+			site.BaseURL = o.BaseURL
+		}
+		if o.Mirrors != nil {
+			site.Mirrors = o.Mirrors
+		}
+	}
+
+	env := "LUFFY_" + strings.ToUpper(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name))
+	if v := os.Getenv(env + "_URL"); v != "" {
+		site.BaseURL = v
+	}
+	if v := os.Getenv(env + "_MIRRORS"); v != "" {
+		site.Mirrors = strings.Split(v, ",")
+	}
+
+	site.BaseURL = strings.TrimRight(strings.TrimSpace(site.BaseURL), "/")
+	var mirrors []string
+	for _, m := range site.Mirrors {
+		if m = strings.TrimRight(strings.TrimSpace(m), "/"); m != "" && m != site.BaseURL {
+			mirrors = append(mirrors, m)
+		}
+	}
+	site.Mirrors = mirrors
+	return site
+}
+
+// SiteURL returns the base URL to build links for a site with.
+func SiteURL(name string) string {
+	return LookupSite(name).BaseURL
+}
+
+// candidates lists the site's URLs in the order to try them: the mirror that
+// worked last, then the base URL, then the other mirrors.
+func (s Site) candidates(active string) []string {
+	list := []string{}
+	if active != "" {
+		list = append(list, active)
+	}
+	for _, u := range append([]string{s.BaseURL}, s.Mirrors...) {
+		if u != "" && u != active {
+			list = append(list, u)
+		}
+	}
+	return list
+}
+
+// siteForURL finds the site whose base URL or one of its mirrors prefixes link.
+func siteForURL(link string) (name string, site Site, rest string, ok bool) {
+	sitesMu.RLock()
+	defer sitesMu.RUnlock()
+
+	names := make([]string, 0, len(siteDefaults)+len(siteOverrides))
+	for n := range siteDefaults {
+		names = append(names, n)
+	}
+	for n := range siteOverrides {
+		if _, dup := siteDefaults[n]; !dup {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		s := lookupSite(n)
+		for _, base := range append([]string{s.BaseURL}, s.Mirrors...) {
+			if base == "" {
+				continue
+			}
+			if after, found := strings.CutPrefix(link, base); found && (after == "" || strings.ContainsAny(after[:1], "/?#")) {
+				return n, s, after, true
+			}
+		}
+	}
+	return "", Site{}, "", false
+}
+
+// mirrorTransport sends requests for a registered site to its mirrors when the
+// current host fails with a DNS, TLS or 5xx error, and sticks to the first
+// mirror that answers.
+type mirrorTransport struct {
+	base http.RoundTripper
+}
+
+func (t *mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, site, rest, ok := siteForURL(req.URL.String())
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	sitesMu.RLock()
+	active := siteActive[name]
+	sitesMu.RUnlock()
+	candidates := site.candidates(active)
+	if len(candidates) < 2 || (req.Body != nil && req.GetBody == nil) {
+		return t.base.RoundTrip(req)
+	}
+	if req.Body != nil {
+		// Every attempt reads its own copy from GetBody
+		defer req.Body.Close()
+	}
+
+	for i, base := range candidates {
+		r, err := rebase(req, base+rest)
+		if err != nil {
+			return nil, err
+		}
+		last := i == len(candidates)-1
+
+		resp, err := t.base.RoundTrip(r)
+		if err != nil {
+			if last || !mirrorable(req.Context(), err) {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode >= 500 && !last {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+		if resp.StatusCode < 500 && base != active {
+			sitesMu.Lock()
+			siteActive[name] = base
+			sitesMu.Unlock()
+		}
+		return resp, nil
+	}
+	return nil, errors.New("no hosts to try for " + name)
+}
+
+func rebase(req *http.Request, link string) (*http.Request, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.URL = u
+	r.Host = ""
+	if req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// mirrorable tells whether err means the host itself is unreachable or broken.
+func mirrorable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &dnsErr) ||
+		errors.As(err, &recordErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
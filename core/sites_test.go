@@ -0,0 +1,74 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMirrorRotation(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer down.Close()
+	// A TLS server the client does not trust stands in for a broken certificate
+	badTLS := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "should not be reached")
+	}))
+	defer badTLS.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer up.Close()
+
+	RegisterSite("mirrortest", down.URL)
+	ConfigureSites(map[string]Site{
+		"mirrortest": {Mirrors: []string{badTLS.URL, up.URL}},
+	})
+	defer ConfigureSites(nil)
+
+	client := NewClient()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(down.URL + "/ajax/list?id=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "/ajax/list?id=1" {
+			t.Fatalf("attempt %d: got %q from %s", i, body, resp.Request.URL)
+		}
+	}
+
+	sitesMu.RLock()
+	active := siteActive["mirrortest"]
+	sitesMu.RUnlock()
+	if active != up.URL {
+		t.Errorf("active mirror = %q, want %q", active, up.URL)
+	}
+}
+
+func TestSiteOverrides(t *testing.T) {
+	RegisterSite("overridetest", "https://example.org/")
+	defer ConfigureSites(nil)
+
+	if got := SiteURL("overridetest"); got != "https://example.org" {
+		t.Errorf("default = %q", got)
+	}
+
+	ConfigureSites(map[string]Site{"overridetest": {BaseURL: "https://example.net"}})
+	if got := SiteURL("overridetest"); got != "https://example.net" {
+		t.Errorf("config override = %q", got)
+	}
+
+	t.Setenv("LUFFY_OVERRIDETEST_URL", "https://example.com")
+	t.Setenv("LUFFY_OVERRIDETEST_MIRRORS", "https://a.example.com, https://b.example.com/")
+	site := LookupSite("overridetest")
+	if site.BaseURL != "https://example.com" {
+		t.Errorf("env override = %q", site.BaseURL)
+	}
+	if len(site.Mirrors) != 2 || site.Mirrors[1] != "https://b.example.com" {
+		t.Errorf("env mirrors = %q", site.Mirrors)
+	}
+}
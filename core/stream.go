@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Container string
+
+const (
+	ContainerHLS Container = "hls"
+	ContainerMP4 Container = "mp4"
+	// ContainerEmbed is an embed page that still has to go through an extractor.
+	ContainerEmbed Container = "embed"
+	// ContainerPage is a web page the player resolves by itself (e.g. mpv via yt-dlp).
+	ContainerPage Container = "page"
+)
+
+type Subtitle struct {
+	URL      string
+	Language string
+	Label    string
+}
+
+type AudioTrack struct {
+	URL      string
+	Language string
+	Label    string
+}
+
+// Stream is what providers and extractors hand back for a single title or episode.
+// Variants are ordered as the source listed them; the CLI picks one before playing.
+type Stream struct {
+	Variants  []StreamQuality
+	Referer   string
+	UserAgent string
+	Headers   map[string]string
+	Subtitles []Subtitle
+	Audio     []AudioTrack
+	Container Container
+	Expires   time.Time
+}
+
+// EmbedStream wraps an embed link that needs an extractor before it can be played.
+func EmbedStream(link string) *Stream {
+	return &Stream{
+		Variants:  []StreamQuality{{URL: link}},
+		Container: ContainerEmbed,
+	}
+}
+
+// DirectStream wraps a single playable URL, guessing the container from its extension.
+func DirectStream(link string) *Stream {
+	return &Stream{
+		Variants:  []StreamQuality{{URL: link}},
+		Container: ContainerFromURL(link),
+	}
+}
+
+// URL returns the first variant, which is the only one once a quality has been picked.
+func (s *Stream) URL() string {
+	if len(s.Variants) == 0 {
+		return ""
+	}
+	return s.Variants[0].URL
+}
+
+func (s *Stream) NeedsExtraction() bool {
+	return s.Container == ContainerEmbed
+}
+
+func (s *Stream) Expired() bool {
+	return !s.Expires.IsZero() && time.Now().After(s.Expires)
+}
+
+func (s *Stream) SubtitleURLs() []string {
+	var urls []string
+	for _, sub := range s.Subtitles {
+		if sub.URL != "" {
+			urls = append(urls, sub.URL)
+		}
+	}
+	return urls
+}
+
+// NewRequest builds a request for a URL of the stream, such as a playlist or
+// segment, with the referer, user agent and headers the host expects.
+func (s *Stream) NewRequest(ctx context.Context, method, link string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Referer != "" {
+		req.Header.Set("Referer", s.Referer)
+	}
+	if s.UserAgent != "" {
+		req.Header.Set("User-Agent", s.UserAgent)
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// WithVariant returns a copy of the stream narrowed down to a single variant.
+func (s *Stream) WithVariant(v StreamQuality) *Stream {
+	c := *s
+	c.Variants = []StreamQuality{v}
+	if c.Container == "" || c.Container == ContainerEmbed {
+		c.Container = ContainerFromURL(v.URL)
+	}
+	return &c
+}
+
+func ContainerFromURL(link string) Container {
+	lower := strings.ToLower(link)
+	if i := strings.IndexAny(lower, "?#"); i != -1 {
+		lower = lower[:i]
+	}
+	switch {
+	case strings.Contains(lower, ".m3u8"):
+		return ContainerHLS
+	case strings.HasSuffix(lower, ".mp4"):
+		return ContainerMP4
+	}
+	return ""
+}
+
+var heightLabelRe = regexp.MustCompile(`(\d{3,4})[pP]`)
+
+// HeightFromLabel reads the vertical resolution out of labels such as "720p" or "1080p Ultra".
+func HeightFromLabel(label string) int {
+	m := heightLabelRe.FindStringSubmatch(label)
+	if len(m) < 2 {
+		return 0
+	}
+	h, _ := strconv.Atoi(m[1])
+	return h
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// subtitleLanguages maps language names, in English and in the language
+// itself, and ISO 639-2 codes to ISO 639-1 codes.
+var subtitleLanguages = map[string]string{
+	"english": "en", "eng": "en",
+	"spanish": "es", "español": "es", "espanol": "es", "castellano": "es", "spa": "es",
+	"french": "fr", "français": "fr", "francais": "fr", "fre": "fr", "fra": "fr",
+	"german": "de", "deutsch": "de", "ger": "de", "deu": "de",
+	"italian": "it", "italiano": "it", "ita": "it",
+	"portuguese": "pt", "português": "pt", "portugues": "pt", "por": "pt",
+	"dutch": "nl", "nederlands": "nl", "dut": "nl", "nld": "nl",
+	"russian": "ru", "русский": "ru", "rus": "ru",
+	"ukrainian": "uk", "українська": "uk", "ukr": "uk",
+	"polish": "pl", "polski": "pl", "pol": "pl",
+	"czech": "cs", "čeština": "cs", "cze": "cs", "ces": "cs",
+	"slovak": "sk", "slovenčina": "sk", "slo": "sk", "slk": "sk",
+	"hungarian": "hu", "magyar": "hu", "hun": "hu",
+	"romanian": "ro", "română": "ro", "rum": "ro", "ron": "ro",
+	"bulgarian": "bg", "български": "bg", "bul": "bg",
+	"croatian": "hr", "hrvatski": "hr", "hrv": "hr",
+	"serbian": "sr", "srpski": "sr", "srp": "sr",
+	"slovenian": "sl", "slovenščina": "sl", "slv": "sl",
+	"greek": "el", "ελληνικά": "el", "gre": "el", "ell": "el",
+	"turkish": "tr", "türkçe": "tr", "tur": "tr",
+	"swedish": "sv", "svenska": "sv", "swe": "sv",
+	"norwegian": "no", "norsk": "no", "nor": "no",
+	"danish": "da", "dansk": "da", "dan": "da",
+	"finnish": "fi", "suomi": "fi", "fin": "fi",
+	"icelandic": "is", "íslenska": "is", "ice": "is", "isl": "is",
+	"estonian": "et", "eesti": "et", "est": "et",
+	"latvian": "lv", "latviešu": "lv", "lav": "lv",
+	"lithuanian": "lt", "lietuvių": "lt", "lit": "lt",
+	"arabic": "ar", "العربية": "ar", "ara": "ar",
+	"hebrew": "he", "עברית": "he", "heb": "he",
+	"persian": "fa", "farsi": "fa", "فارسی": "fa", "per": "fa", "fas": "fa",
+	"hindi": "hi", "हिन्दी": "hi", "hin": "hi",
+	"bengali": "bn", "bangla": "bn", "বাংলা": "bn", "ben": "bn",
+	"tamil": "ta", "தமிழ்": "ta", "tam": "ta",
+	"telugu": "te", "తెలుగు": "te", "tel": "te",
+	"malayalam": "ml", "മലയാളം": "ml", "mal": "ml",
+	"urdu": "ur", "اردو": "ur", "urd": "ur",
+	"chinese": "zh", "中文": "zh", "简体中文": "zh", "繁體中文": "zh", "mandarin": "zh", "cantonese": "zh", "chi": "zh", "zho": "zh",
+	"japanese": "ja", "日本語": "ja", "jpn": "ja",
+	"korean": "ko", "한국어": "ko", "kor": "ko",
+	"thai": "th", "ไทย": "th", "tha": "th",
+	"vietnamese": "vi", "tiếng việt": "vi", "vie": "vi",
+	"indonesian": "id", "bahasa indonesia": "id", "ind": "id",
+	"malay": "ms", "bahasa melayu": "ms", "may": "ms", "msa": "ms",
+	"filipino": "tl", "tagalog": "tl", "fil": "tl", "tgl": "tl",
+}
+
+var isoLanguages = func() map[string]bool {
+	codes := map[string]bool{}
+	for _, code := range subtitleLanguages {
+		codes[code] = true
+	}
+	return codes
+}()
+
+// SubtitleLanguage guesses the ISO 639-1 code of a subtitle track from its
+// label ("English", "Español (Latinoamérica)", "pt-BR", "eng"), or "" when
+// the label names no known language.
+func SubtitleLanguage(label string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" {
+		return ""
+	}
+	if code, ok := subtitleLanguages[label]; ok {
+		return code
+	}
+	// A code on its own, possibly with a region: "en", "pt-br", "zh_hans"
+	if base, _, _ := strings.Cut(strings.ReplaceAll(label, "_", "-"), "-"); isoLanguages[base] {
+		return base
+	}
+
+	words := strings.FieldsFunc(label, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsMark(r)
+	})
+	for i := range words {
+		// Two-word names first, e.g. "bahasa indonesia"
+		if i+1 < len(words) {
+			if code, ok := subtitleLanguages[words[i]+" "+words[i+1]]; ok {
+				return code
+			}
+		}
+		// Short codes only count at the start ("eng sdh"), not as any word
+		if len(words[i]) <= 3 && i > 0 {
+			continue
+		}
+		if code, ok := subtitleLanguages[words[i]]; ok {
+			return code
+		}
+	}
+	return ""
+}
+
+// subtitlesFromTracks turns the text tracks of a player config into
+// subtitles, skipping thumbnails and chapters.
+func subtitlesFromTracks(tracks []DecryptedTrack) []Subtitle {
+	var subs []Subtitle
+	for _, track := range tracks {
+		if track.File == "" || (track.Kind != "" && track.Kind != "captions" && track.Kind != "subtitles") {
+			continue
+		}
+		subs = append(subs, Subtitle{URL: track.File, Language: SubtitleLanguage(track.Label), Label: track.Label})
+	}
+	return subs
+}
+
+// Lang returns the ISO 639-1 code of the subtitle, reading the label when
+// the source did not say.
+func (s Subtitle) Lang() string {
+	if lang := SubtitleLanguage(s.Language); lang != "" {
+		return lang
+	}
+	return SubtitleLanguage(s.Label)
+}
+
+// SelectSubtitles keeps the subtitles in the preferred languages, in order of
+// preference. Preferences are codes or language names; "all" stands for every
+// remaining track and "none" for no subtitles at all. When no track is in a
+// preferred language, tracks whose language is unknown are kept, as they may
+// well be in one.
+func SelectSubtitles(subs []Subtitle, prefs []string) []Subtitle {
+	var selected []Subtitle
+	used := make([]bool, len(subs))
+	matched := false
+	for _, pref := range prefs {
+		pref = strings.ToLower(strings.TrimSpace(pref))
+		switch pref {
+		case "none", "off":
+			return nil
+		case "all":
+			for i, sub := range subs {
+				if !used[i] {
+					selected = append(selected, sub)
+					used[i] = true
+				}
+			}
+			continue
+		}
+		want := SubtitleLanguage(pref)
+		if want == "" {
+			want = pref
+		}
+		for i, sub := range subs {
+			if !used[i] && sub.Lang() == want {
+				selected = append(selected, sub)
+				used[i] = true
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		for i, sub := range subs {
+			if !used[i] && sub.Lang() == "" {
+				selected = append(selected, sub)
+			}
+		}
+	}
+	return selected
+}
+
+// SubtitleFilename names a downloaded subtitle after the video so players
+// pick it up with its language: name.en.vtt, then name.2.en.vtt for the
+// second English track. n counts the tracks of the same language from 1.
+func SubtitleFilename(name string, sub Subtitle, n int) string {
+	ext := strings.ToLower(path.Ext(strings.SplitN(strings.SplitN(sub.URL, "?", 2)[0], "#", 2)[0]))
+	switch ext {
+	case ".srt", ".vtt", ".ass", ".ssa":
+	default:
+		ext = ".vtt"
+	}
+	lang := sub.Lang()
+	if lang == "" {
+		lang = "und"
+	}
+	if n > 1 {
+		return fmt.Sprintf("%s.%d.%s%s", name, n, lang, ext)
+	}
+	return name + "." + lang + ext
+}
+
+// SubtitleLanguages lists the languages of the subtitles in order, once each.
+func SubtitleLanguages(subs []Subtitle) []string {
+	var langs []string
+	for _, sub := range subs {
+		if lang := sub.Lang(); lang != "" && !slices.Contains(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSubtitleLanguage(t *testing.T) {
+	cases := map[string]string{
+		"English":                 "en",
+		"English - SDH":           "en",
+		"English (SDH)":           "en",
+		"eng sdh":                 "en",
+		"pt-br":                   "pt",
+		"zh_Hans":                 "zh",
+		"SDH eng":                 "",
+		"eng":                     "en",
+		"Eng [CC]":                "en",
+		"Español (Latinoamérica)": "es",
+		"Portuguese (Brazil)":     "pt",
+		"pt-BR":                   "pt",
+		"FR":                      "fr",
+		"Bahasa Indonesia":        "id",
+		"日本語":                     "ja",
+		"Русский":                 "ru",
+		"Chinese - Simplified":    "zh",
+		"Subs by Dan":             "",
+		"Track 1":                 "",
+		"":                        "",
+	}
+	for label, want := range cases {
+		if got := SubtitleLanguage(label); got != want {
+			t.Errorf("SubtitleLanguage(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
+
+func TestSelectSubtitles(t *testing.T) {
+	subs := []Subtitle{
+		{URL: "en.vtt", Label: "English"},
+		{URL: "fr.vtt", Label: "French"},
+		{URL: "es.vtt", Language: "es"},
+		{URL: "en-sdh.vtt", Label: "English SDH"},
+		{URL: "x.vtt", Label: "Track 5"},
+	}
+	urls := func(subs []Subtitle) []string {
+		var out []string
+		for _, s := range subs {
+			out = append(out, s.URL)
+		}
+		return out
+	}
+
+	cases := []struct {
+		prefs []string
+		want  []string
+	}{
+		{[]string{"en"}, []string{"en.vtt", "en-sdh.vtt"}},
+		{[]string{"Spanish", "en"}, []string{"es.vtt", "en.vtt", "en-sdh.vtt"}},
+		{[]string{"fr", "all"}, []string{"fr.vtt", "en.vtt", "es.vtt", "en-sdh.vtt", "x.vtt"}},
+		{[]string{"de"}, []string{"x.vtt"}},
+		{[]string{"de", "all"}, []string{"en.vtt", "fr.vtt", "es.vtt", "en-sdh.vtt", "x.vtt"}},
+		{[]string{"en", "all"}, []string{"en.vtt", "en-sdh.vtt", "fr.vtt", "es.vtt", "x.vtt"}},
+		{[]string{"en", "none"}, nil},
+		{nil, []string{"x.vtt"}},
+	}
+	for _, c := range cases {
+		if got := urls(SelectSubtitles(subs, c.prefs)); !slices.Equal(got, c.want) {
+			t.Errorf("SelectSubtitles(%v) = %v, want %v", c.prefs, got, c.want)
+		}
+	}
+}
+
+func TestSubtitleFilename(t *testing.T) {
+	cases := []struct {
+		sub  Subtitle
+		n    int
+		want string
+	}{
+		{Subtitle{URL: "https://cdn.example/a.vtt?token=1", Label: "English"}, 1, "Movie.en.vtt"},
+		{Subtitle{URL: "https://cdn.example/b.srt", Label: "English SDH"}, 2, "Movie.2.en.srt"},
+		{Subtitle{URL: "https://cdn.example/sub?id=3", Language: "es"}, 1, "Movie.es.vtt"},
+		{Subtitle{URL: "https://cdn.example/c.vtt", Label: "Track 1"}, 1, "Movie.und.vtt"},
+		{Subtitle{URL: "https://cdn.example/d.ASS#t=0", Label: "pt-BR"}, 1, "Movie.pt.ass"},
+		{Subtitle{URL: "https://cdn.example/e.vtt", Label: "English (SDH)"}, 3, "Movie.3.en.vtt"},
+		{Subtitle{URL: "https://cdn.example/f.txt", Label: "eng sdh"}, 1, "Movie.en.vtt"},
+	}
+	for _, c := range cases {
+		if got := SubtitleFilename("Movie", c.sub, c.n); got != c.want {
+			t.Errorf("SubtitleFilename(%+v, %d) = %q, want %q", c.sub, c.n, got, c.want)
+		}
+	}
+}
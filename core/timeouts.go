@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Stage string
+
+const (
+	StageSearch   Stage = "search"
+	StageMetadata Stage = "metadata"
+	StageLink     Stage = "link"
+	StageDecrypt  Stage = "decrypt"
+	StageProbe    Stage = "probe"
+)
+
+// Timeouts holds the deadline for each stage of resolving a stream.
+// A zero or negative value disables the deadline for that stage.
+type Timeouts struct {
+	Search   time.Duration `yaml:"search"`
+	Metadata time.Duration `yaml:"metadata"`
+	Link     time.Duration `yaml:"link"`
+	Decrypt  time.Duration `yaml:"decrypt"`
+	Probe    time.Duration `yaml:"probe"`
+}
+
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Search:   20 * time.Second,
+		Metadata: 20 * time.Second,
+		Link:     20 * time.Second,
+		Decrypt:  30 * time.Second,
+		Probe:    15 * time.Second,
+	}
+}
+
+// UnmarshalYAML reads each deadline as a duration such as 20s, or as a bare
+// number of seconds, so that "search: 0" disables a deadline as documented.
+// Stages left out keep their current value.
+func (t *Timeouts) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: timeouts must be a mapping", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		d, err := parseSeconds(value.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: timeouts.%s: %w", value.Line, key, err)
+		}
+		switch Stage(key) {
+		case StageSearch:
+			t.Search = d
+		case StageMetadata:
+			t.Metadata = d
+		case StageLink:
+			t.Link = d
+		case StageDecrypt:
+			t.Decrypt = d
+		case StageProbe:
+			t.Probe = d
+		}
+	}
+	return nil
+}
+
+// parseSeconds reads a duration, taking a bare number as seconds.
+func parseSeconds(value string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (t Timeouts) For(stage Stage) time.Duration {
+	switch stage {
+	case StageSearch:
+		return t.Search
+	case StageMetadata:
+		return t.Metadata
+	case StageLink:
+		return t.Link
+	case StageDecrypt:
+		return t.Decrypt
+	case StageProbe:
+		return t.Probe
+	}
+	return 0
+}
+
+// WithStage derives a context carrying the deadline configured for stage.
+func (t Timeouts) WithStage(parent context.Context, stage Stage) (context.Context, context.CancelFunc) {
+	d := t.For(stage)
+	if d <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, d)
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTimeoutsYAML(t *testing.T) {
+	cfg := defaultConfig()
+	data := "player: vlc\ntimeouts:\n  search: 0\n  link: 45\n  decrypt: 1m30s\n"
+	if err := yaml.Unmarshal([]byte(data), cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultTimeouts()
+	want.Search, want.Link, want.Decrypt = 0, 45*time.Second, 90*time.Second
+	if cfg.Timeouts != want || cfg.Player != "vlc" {
+		t.Errorf("timeouts = %+v, player %q", cfg.Timeouts, cfg.Player)
+	}
+
+	if err := yaml.Unmarshal([]byte("timeouts:\n  search: soon\n"), defaultConfig()); err == nil {
+		t.Error("bad duration was accepted")
+	}
+}
+
+func TestHTTPConfigYAML(t *testing.T) {
+	cfg := defaultConfig()
+	data := "http:\n  connect_timeout: 5\n  read_timeout: 1m\n  retry_backoff: 0.25\n  retries: 4\n"
+	if err := yaml.Unmarshal([]byte(data), cfg); err != nil {
+		t.Fatal(err)
+	}
+	h := cfg.HTTP
+	if h.ConnectTimeout != 5*time.Second || h.ReadTimeout != time.Minute || h.RetryBackoff != 250*time.Millisecond || h.Retries != 4 {
+		t.Errorf("http = %+v", h)
+	}
+	// Sections left out keep their defaults
+	if h.Limits["default"] != DefaultHTTPConfig().Limits["default"] || h.Cache.TTL["search"] != 30*time.Minute {
+		t.Errorf("defaults lost: %+v", h)
+	}
+
+	if err := yaml.Unmarshal([]byte("http:\n  read_timeout: soon\n"), defaultConfig()); err == nil {
+		t.Error("bad duration was accepted")
+	}
+}
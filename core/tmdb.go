@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// TMDBCatalog looks titles, seasons and episodes up on TMDB, for providers
+// that only need a TMDB ID to build their links.
+type TMDBCatalog struct {
+	Client  *http.Client
+	BaseURL string
+	APIKey  string
+	// MaxPages bounds how many pages of search results are read.
+	MaxPages int
+}
+
+func NewTMDBCatalog(client *http.Client) *TMDBCatalog {
+	return &TMDBCatalog{
+		Client:   client,
+		BaseURL:  SiteURL("tmdb"),
+		APIKey:   TMDB_API_KEY,
+		MaxPages: 3,
+	}
+}
+
+func (c *TMDBCatalog) get(ctx context.Context, path string, params url.Values, v any) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("api_key", c.APIKey)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tmdb %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Search returns the movies and shows matching query. The results carry the
+// TMDB ID but no URL; providers fill that in with their own page links.
+func (c *TMDBCatalog) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	var results []SearchResult
+	for page := 1; c.MaxPages <= 0 || page <= c.MaxPages; page++ {
+		var data TmdbSearchResult
+		params := url.Values{}
+		params.Set("query", query)
+		params.Set("page", strconv.Itoa(page))
+		if err := c.get(ctx, "/search/multi", params, &data); err != nil {
+			if page > 1 {
+				// Keep what the earlier pages found
+				break
+			}
+			return nil, err
+		}
+
+		for _, item := range data.Results {
+			if item.MediaType != "movie" && item.MediaType != "tv" {
+				continue
+			}
+
+			r := SearchResult{
+				Title: item.Title,
+				Type:  Movie,
+				TMDB:  strconv.Itoa(item.ID),
+			}
+			if item.MediaType == "tv" {
+				r.Title = item.Name
+				r.Type = Series
+			}
+			if item.PosterPath != "" {
+				r.Poster = "https://image.tmdb.org/t/p/w500" + item.PosterPath
+			}
+			if len(item.ReleaseDate) >= 4 {
+				r.Year = item.ReleaseDate[:4]
+			} else if len(item.FirstAirDate) >= 4 {
+				r.Year = item.FirstAirDate[:4]
+			}
+			results = append(results, r)
+		}
+
+		if page >= data.TotalPages {
+			break
+		}
+	}
+	return results, nil
+}
+
+// Seasons lists the seasons of a show ref carrying a TMDB ID. Specials
+// (season 0) come last, so season N stays the N-th entry.
+func (c *TMDBCatalog) Seasons(ctx context.Context, media MediaRef) ([]Season, error) {
+	if media.TMDB == "" {
+		return nil, fmt.Errorf("no tmdb id for %s", media)
+	}
+
+	var details TmdbShowDetails
+	if err := c.get(ctx, "/tv/"+media.TMDB, nil, &details); err != nil {
+		return nil, err
+	}
+
+	list := details.Seasons
+	sort.SliceStable(list, func(i, j int) bool {
+		if (list[i].SeasonNumber == 0) != (list[j].SeasonNumber == 0) {
+			return list[j].SeasonNumber == 0
+		}
+		return list[i].SeasonNumber < list[j].SeasonNumber
+	})
+
+	var seasons []Season
+	for _, s := range list {
+		ref := media.Child(KindSeason, strconv.Itoa(s.SeasonNumber))
+		ref.Season = s.SeasonNumber
+		seasons = append(seasons, Season{Ref: ref, Name: s.Name})
+	}
+	return seasons, nil
+}
+
+// Episodes lists the episodes of a season ref created by Seasons.
+func (c *TMDBCatalog) Episodes(ctx context.Context, season MediaRef) ([]Episode, error) {
+	var data TmdbSeasonDetails
+	path := fmt.Sprintf("/tv/%s/season/%d", season.TMDB, season.Season)
+	if err := c.get(ctx, path, nil, &data); err != nil {
+		return nil, err
+	}
+
+	var episodes []Episode
+	for _, ep := range data.Episodes {
+		ref := season.Child(KindEpisode, strconv.Itoa(ep.EpisodeNumber))
+		ref.Episode = ep.EpisodeNumber
+		episodes = append(episodes, Episode{
+			Ref:  ref,
+			Name: fmt.Sprintf("Episode %d: %s", ep.EpisodeNumber, ep.Name),
+		})
+	}
+	return episodes, nil
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTMDBCatalog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search/multi":
+			page := r.URL.Query().Get("page")
+			fmt.Fprintf(w, `{"page":%s,"total_pages":5,"results":[{"id":%s,"media_type":"tv","name":"Show %s","first_air_date":"2008-01-20"}]}`, page, page, page)
+		case "/tv/7":
+			fmt.Fprint(w, `{"seasons":[{"season_number":0,"name":"Specials"},{"season_number":2,"name":"Season 2"},{"season_number":1,"name":"Season 1"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewTMDBCatalog(srv.Client())
+	c.BaseURL = srv.URL
+	c.MaxPages = 2
+
+	results, err := c.Search(context.Background(), "show")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].Title != "Show 2" || results[1].Year != "2008" || results[1].Type != Series {
+		t.Errorf("search results = %+v", results)
+	}
+
+	seasons, err := c.Seasons(context.Background(), MediaRef{Kind: KindTitle, Type: Series, ID: "7", TMDB: "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, s := range seasons {
+		got = append(got, s.Ref.Season)
+	}
+	if fmt.Sprint(got) != "[1 2 0]" {
+		t.Errorf("season order = %v, want [1 2 0]", got)
+	}
+}
@@ -12,16 +12,15 @@ const (
 )
 
 const (
-	FLIXHQ_BASE_URL   = "https://flixhq.to"
-	FLIXHQ_SEARCH_URL = FLIXHQ_BASE_URL + "/search"
-	FLIXHQ_AJAX_URL   = FLIXHQ_BASE_URL + "/ajax"
-	DECODER           = "https://dec.eatmynerds.live"
-	TMDB_API_KEY      = "653bb8af90162bd98fc7ee32bcbbfb3d"
-	TMDB_BASE_URL     = "https://api.themoviedb.org/3"
+	DECODER       = "https://dec.eatmynerds.live"
+	TMDB_API_KEY  = "653bb8af90162bd98fc7ee32bcbbfb3d"
+	TMDB_BASE_URL = "https://api.themoviedb.org/3"
 )
 
 type TmdbSearchResult struct {
-	Results []struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+	Results    []struct {
 		ID           int    `json:"id"`
 		MediaType    string `json:"media_type"`
 		Title        string `json:"title"`
@@ -58,19 +57,20 @@ type SearchResult struct {
 	Type   MediaType
 	Poster string
 	Year   string
+	TMDB   string
 }
 
 type Season struct {
-	ID   string
+	Ref  MediaRef
 	Name string
 }
 
 type Episode struct {
-	ID   string
+	Ref  MediaRef
 	Name string
 }
 
 type Server struct {
-	ID   string
+	Ref  MediaRef
 	Name string
 }
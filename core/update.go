@@ -31,10 +31,10 @@ func checkUpdate() error {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
+	// GitHub asks API clients to name themselves
 	req.Header.Set("User-Agent", updateUserAgent)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := NewClient().Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to fetch latest release: %w", err)
 	}
@@ -98,7 +98,7 @@ func getDownloadURL(version string) string {
 
 // downloadUpdate downloads a URL to a local file with validation
 func downloadUpdate(url, filepath string) error {
-	resp, err := http.Get(url)
+	resp, err := NewClient().Get(url)
 	if err != nil {
 		return fmt.Errorf("download failed: %w", err)
 	}
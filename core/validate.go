@@ -0,0 +1,302 @@
+package core
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/demonkingswarn/luffy/core/hls"
+)
+
+const (
+	// VALIDATE_SEGMENTS is how many segments of an HLS stream are fetched by default.
+	VALIDATE_SEGMENTS = 2
+	// VALIDATE_SAMPLE is how much of each segment, or of a file, is read.
+	VALIDATE_SAMPLE = 1 << 20
+)
+
+// ValidationConfig configures the check streams go through before they are
+// handed to the player or downloader.
+type ValidationConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Segments is how many HLS segments are fetched.
+	Segments int `yaml:"segments"`
+	// MinSpeed rejects streams that download slower than this many kbit/s;
+	// 0 accepts any speed.
+	MinSpeed int64 `yaml:"min_speed"`
+}
+
+func DefaultValidationConfig() ValidationConfig {
+	return ValidationConfig{Segments: VALIDATE_SEGMENTS}
+}
+
+// StreamCheck is what validating a stream found.
+type StreamCheck struct {
+	URL string
+	// Variant is the variant of a master playlist that was checked.
+	Variant *StreamQuality
+	// Segments is how many segments were fetched, 0 for a progressive file.
+	Segments int
+	// Format is the container the media sniffed as, such as mpegts or mp4,
+	// or "encrypted".
+	Format  string
+	Bytes   int64
+	Elapsed time.Duration
+}
+
+// Speed is the measured throughput in bit/s.
+func (c StreamCheck) Speed() int64 {
+	if c.Elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(c.Bytes*8) / c.Elapsed.Seconds())
+}
+
+func (c StreamCheck) String() string {
+	var parts []string
+	if c.Variant != nil {
+		parts = append(parts, cmp.Or(c.Variant.Label, c.Variant.Resolution))
+	}
+	if c.Segments > 0 {
+		parts = append(parts, fmt.Sprintf("%d segments", c.Segments))
+	}
+	if c.Format != "" {
+		parts = append(parts, c.Format)
+	}
+	if c.Bytes == 0 && c.Elapsed == 0 {
+		return strings.Join(parts, ", ")
+	}
+	speed := fmt.Sprintf("%s in %s, %s", formatBytes(c.Bytes), c.Elapsed.Round(time.Millisecond), formatBitrate(c.Speed()))
+	if c.Variant != nil && c.Variant.Bandwidth > 0 {
+		speed += fmt.Sprintf(" for a %s stream", formatBitrate(int64(c.Variant.Bandwidth)))
+	}
+	return strings.Join(append(parts, speed), ", ")
+}
+
+func formatBitrate(bps int64) string {
+	if bps >= 1000000 {
+		return fmt.Sprintf("%.1f Mbit/s", float64(bps)/1000000)
+	}
+	return fmt.Sprintf("%d kbit/s", bps/1000)
+}
+
+func formatBytes(n int64) string {
+	if n >= 1<<20 {
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d KiB", n>>10)
+}
+
+// Validator fetches the start of a stream with its referer and headers, the
+// way the player will, to catch dead links, error pages served in place of
+// playlists or segments, and hosts too slow to play from.
+type Validator struct {
+	Client *http.Client
+	Config ValidationConfig
+	// Pick returns the index of the variant that will be played, as the
+	// quality policy picks it, or -1 when the user is yet to be asked. The
+	// best variant is checked then, and when Pick is nil.
+	Pick func(variants []StreamQuality) int
+	// Report is called after every check, whether the stream passed or not.
+	Report func(check StreamCheck, err error)
+}
+
+// Validate checks a stream; it fits Resolver.Validate.
+func (v *Validator) Validate(ctx context.Context, stream *Stream) error {
+	check, err := v.Check(ctx, stream)
+	if v.Report != nil {
+		v.Report(check, err)
+	}
+	return err
+}
+
+// Check fetches the playlist and first segments of an HLS stream, or the
+// first VALIDATE_SAMPLE bytes of a file, and measures the throughput. Of
+// several variants, only the one Pick picks is checked. Web pages are let
+// through unchecked, as the player resolves them itself.
+func (v *Validator) Check(ctx context.Context, stream *Stream) (StreamCheck, error) {
+	if stream.Container == ContainerPage {
+		return StreamCheck{URL: stream.URL(), Format: string(ContainerPage)}, nil
+	}
+	var check StreamCheck
+	if len(stream.Variants) > 1 {
+		q := pickOrBest(v.Pick, stream.Variants)
+		check.Variant = &q
+		stream = stream.WithVariant(q)
+	}
+	check.URL = stream.URL()
+	var err error
+	if stream.Container == ContainerHLS {
+		err = v.checkHLS(ctx, stream, &check)
+	} else {
+		err = v.checkFile(ctx, stream, &check)
+	}
+	if err == nil && v.Config.MinSpeed > 0 && check.Speed() < v.Config.MinSpeed*1000 {
+		err = fmt.Errorf("too slow: %s, below min_speed", formatBitrate(check.Speed()))
+	}
+	return check, err
+}
+
+func (v *Validator) checkHLS(ctx context.Context, stream *Stream, check *StreamCheck) error {
+	d := &HLSDownloader{Client: v.Client, Stream: stream}
+	media, master, err := d.media(ctx, stream.URL())
+	if err != nil {
+		return fmt.Errorf("playlist: %w", err)
+	}
+	if master != nil {
+		q := pickOrBest(v.Pick, masterQualities(master))
+		check.Variant = &q
+		if media, _, err = d.media(ctx, q.URL); err != nil {
+			return fmt.Errorf("variant playlist: %w", err)
+		}
+		if media == nil {
+			return fmt.Errorf("variant %s is not a media playlist", q.URL)
+		}
+	}
+	if len(media.Segments) == 0 {
+		return errors.New("playlist has no segments")
+	}
+
+	segments := v.Config.Segments
+	if segments <= 0 {
+		segments = VALIDATE_SEGMENTS
+	}
+	keys := &hlsKeys{d: d, keys: map[string][]byte{}}
+	for _, seg := range media.Segments[:min(segments, len(media.Segments))] {
+		encrypted := seg.Key != nil
+		if encrypted && seg.Key.Method == "AES-128" {
+			if _, err := keys.key(ctx, seg.Key.URI); err != nil {
+				return err
+			}
+		}
+		if seg.Map != nil && check.Segments == 0 {
+			if _, err := v.sample(ctx, stream, seg.Map.URI, seg.Map.ByteRange, check); err != nil {
+				return fmt.Errorf("init section: %w", err)
+			}
+		}
+		data, err := v.sample(ctx, stream, seg.URI, seg.ByteRange, check)
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", seg.Sequence, err)
+		}
+		check.Segments++
+
+		// Encrypted segments look like noise until decrypted
+		format := "encrypted"
+		if !encrypted {
+			if format, err = mediaFormat(data); err != nil {
+				return fmt.Errorf("segment %d: %w", seg.Sequence, err)
+			}
+		}
+		if check.Format == "" {
+			check.Format = format
+		}
+	}
+	return nil
+}
+
+func (v *Validator) checkFile(ctx context.Context, stream *Stream, check *StreamCheck) error {
+	data, err := v.sample(ctx, stream, stream.URL(), &hls.ByteRange{Length: VALIDATE_SAMPLE}, check)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimPrefix(data, []byte("\ufeff")), []byte("#EXTM3U")) {
+		// A playlist behind a link with no .m3u8 in it
+		*check = StreamCheck{URL: check.URL, Variant: check.Variant}
+		return v.checkHLS(ctx, stream, check)
+	}
+	check.Format, err = mediaFormat(data)
+	return err
+}
+
+// sample reads up to VALIDATE_SAMPLE bytes of a segment or file, adding them
+// and the time taken to the check. Statuses, sizes and content types that
+// show an error page in place of media are errors.
+func (v *Validator) sample(ctx context.Context, stream *Stream, link string, br *hls.ByteRange, check *StreamCheck) ([]byte, error) {
+	req, err := stream.NewRequest(ctx, "GET", link)
+	if err != nil {
+		return nil, err
+	}
+	if br != nil {
+		length := min(br.Length, VALIDATE_SAMPLE)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", br.Offset, br.Offset+length-1))
+	}
+
+	start := time.Now()
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	if typ, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); typ == "text/html" || typ == "application/json" {
+		return nil, fmt.Errorf("got a %s page", typ)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, VALIDATE_SAMPLE))
+	check.Bytes += int64(len(data))
+	check.Elapsed += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty response")
+	}
+	return data, nil
+}
+
+// mediaFormat sniffs the container of unencrypted media, failing on web
+// pages. Formats it does not know are let through as "unknown".
+func mediaFormat(data []byte) (string, error) {
+	if format := sniffMedia(data); format != "" {
+		return format, nil
+	}
+	if text := bytes.TrimSpace(data[:min(len(data), 512)]); bytes.HasPrefix(text, []byte("<")) || bytes.HasPrefix(text, []byte("{")) {
+		return "", errors.New("got a web page instead of media")
+	}
+	return "unknown", nil
+}
+
+// sniffMedia names the container data starts with, or returns "" when it
+// does not look like media. MPEG-TS behind a fake image header, which some
+// hosts use to pass segments off as pictures, counts as MPEG-TS.
+func sniffMedia(data []byte) string {
+	if len(data) >= 8 {
+		switch string(data[4:8]) {
+		case "ftyp", "styp", "moof", "sidx", "moov", "free":
+			return "mp4"
+		}
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("\x1a\x45\xdf\xa3")):
+		return "matroska"
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return "id3"
+	case len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0:
+		return "mpeg audio"
+	}
+	// Three sync bytes a packet apart make it MPEG-TS
+	for i := 0; i < min(len(data), 64<<10); i++ {
+		if data[i] != 0x47 {
+			continue
+		}
+		if i+2*188 >= len(data) {
+			if i == 0 && len(data) >= 188 {
+				return "mpegts"
+			}
+			break
+		}
+		if data[i+188] == 0x47 && data[i+2*188] == 0x47 {
+			return "mpegts"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidator(t *testing.T) {
+	packet := append([]byte{0x47}, bytes.Repeat([]byte{0xff}, 187)...)
+	ts := bytes.Repeat(packet, 20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Referer() != "https://embed.example/" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/good/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nlow.m3u8\n"+
+				"#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720\nhigh.m3u8\n")
+		case "/good/high.m3u8", "/dead/index.m3u8", "/html/index.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:6,\nseg0.jpg\n#EXTINF:6,\nseg1.jpg\n#EXTINF:6,\nseg2.jpg\n#EXT-X-ENDLIST\n")
+		case "/good/seg0.jpg", "/good/seg1.jpg":
+			// Segments behind a fake PNG header
+			w.Write(append([]byte("\x89PNG\r\n\x1a\n"), ts...))
+		case "/good/seg2.jpg":
+			t.Error("fetched more segments than configured")
+		case "/html/seg0.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			fmt.Fprint(w, "\n<!DOCTYPE html><html><body>Video not found</body></html>")
+		case "/watch":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<!DOCTYPE html><html><body>A video page</body></html>")
+		case "/movie":
+			// A progressive file without an extension
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("\x00\x00\x00\x20ftypisom"+strings.Repeat("x", 4096)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	v := &Validator{Client: NewClient(), Config: DefaultValidationConfig()}
+	check := func(link string) (StreamCheck, error) {
+		stream := &Stream{Variants: []StreamQuality{{URL: srv.URL + link}}, Referer: "https://embed.example/", Container: ContainerFromURL(link)}
+		return v.Check(context.Background(), stream)
+	}
+
+	c, err := check("/good/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Variant == nil || c.Variant.Bandwidth != 2500000 || c.Segments != 2 || c.Format != "mpegts" || c.Bytes == 0 || c.Speed() == 0 {
+		t.Errorf("check = %+v", c)
+	}
+
+	if _, err := check("/dead/index.m3u8"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("dead segment: %v", err)
+	}
+	if _, err := check("/html/index.m3u8"); err == nil || !strings.Contains(err.Error(), "web page") {
+		t.Errorf("error page segment: %v", err)
+	}
+	if _, err := check("/gone.m3u8"); err == nil {
+		t.Error("missing playlist passed")
+	}
+
+	c, err = check("/movie")
+	if err != nil || c.Format != "mp4" {
+		t.Errorf("progressive file: %+v, %v", c, err)
+	}
+
+	// Only the variant the quality policy picks is checked, and low.m3u8 is dead
+	if _, err := check("/good/master.m3u8"); err != nil {
+		t.Errorf("best variant: %v", err)
+	}
+	q, _ := ParseQuality("<=480p")
+	v.Pick = q.Pick
+	if c, err := check("/good/master.m3u8"); err == nil || c.Variant == nil || c.Variant.Height != 360 {
+		t.Errorf("<=480p checked %+v: %v", c.Variant, err)
+	}
+	files := &Stream{
+		Variants: []StreamQuality{
+			{URL: srv.URL + "/gone.mp4", Label: "360p"},
+			{URL: srv.URL + "/movie", Label: "1080p"},
+		},
+		Referer: "https://embed.example/",
+	}
+	if _, err := v.Check(context.Background(), files); err == nil {
+		t.Error("dead 360p file passed")
+	}
+	v.Pick = nil
+	if c, err := v.Check(context.Background(), files); err != nil || c.URL != srv.URL+"/movie" {
+		t.Errorf("best file: %+v, %v", c, err)
+	}
+
+	v.Config.MinSpeed = 1 << 40
+	if _, err := check("/movie"); err == nil || !strings.Contains(err.Error(), "too slow") {
+		t.Errorf("min_speed: %v", err)
+	}
+
+	// Pages are left to the player, whatever min_speed says
+	page := &Stream{Variants: []StreamQuality{{URL: srv.URL + "/watch"}}, Referer: "https://embed.example/", Container: ContainerPage}
+	if c, err := v.Check(context.Background(), page); err != nil || c.String() != "page" {
+		t.Errorf("page: %q, %v", c, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Package fixturetest replays fixtures recorded with "luffy dev record", so
+// that provider tests run against recorded pages instead of the live sites.
+package fixturetest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/demonkingswarn/luffy/core"
+)
+
+// NewServer serves the exchanges of a fixture. Recorded URLs are served
+// under /<host>/<path>, so every host a provider talked to lives on one server;
+// see URL and Client.
+func NewServer(f *core.Fixture) *httptest.Server {
+	byKey := map[string]core.Exchange{}
+	for _, ex := range f.Exchanges {
+		u, err := url.Parse(ex.URL)
+		if err != nil {
+			continue
+		}
+		key := replayKey(ex.Method, u.Host+u.EscapedPath(), u.RawQuery, ex.RequestBody)
+		if _, ok := byKey[key]; !ok {
+			byKey[key] = ex
+		}
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		key := replayKey(req.Method, strings.TrimPrefix(req.URL.EscapedPath(), "/"), req.URL.RawQuery, string(body))
+		ex, ok := byKey[key]
+		if !ok {
+			http.Error(w, "no recorded response for "+key, http.StatusNotFound)
+			return
+		}
+		if ex.ContentType != "" {
+			w.Header().Set("Content-Type", ex.ContentType)
+		}
+		if ex.Location != "" {
+			w.Header().Set("Location", ex.Location)
+		}
+		w.WriteHeader(ex.Status)
+		io.WriteString(w, ex.Body)
+	}))
+}
+
+func replayKey(method, hostPath, query, body string) string {
+	key := method + " " + hostPath
+	if query != "" {
+		key += "?" + query
+	}
+	if body != "" {
+		key += " " + body
+	}
+	return key
+}
+
+// URL maps a live base URL such as https://flixhq.to onto the replay server.
+func URL(srv *httptest.Server, base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return srv.URL
+	}
+	return srv.URL + "/" + u.Host + u.EscapedPath()
+}
+
+// Client returns a client that sends requests for any host to the replay
+// server, for links that are absolute in the recorded pages.
+func Client(srv *httptest.Server) *http.Client {
+	target, _ := url.Parse(srv.URL)
+	return &http.Client{Transport: &replayTransport{target: target, base: srv.Client().Transport}}
+}
+
+type replayTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.target.Host {
+		req = req.Clone(req.Context())
+		req.URL.Path = "/" + req.URL.Host + req.URL.Path
+		req.URL.RawPath = ""
+		req.URL.Scheme = t.target.Scheme
+		req.URL.Host = t.target.Host
+		req.Host = t.target.Host
+	}
+	return t.base.RoundTrip(req)
+}
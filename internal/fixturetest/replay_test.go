@@ -0,0 +1,80 @@
+package fixturetest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/demonkingswarn/luffy/core"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new?x=1", http.StatusFound)
+		case "/new":
+			io.WriteString(w, "new:"+r.URL.Query().Get("x"))
+		case "/post":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"echo":"`+string(body)+`"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	recorder := &core.Recorder{Transport: live.Client().Transport}
+	client := &http.Client{Transport: recorder}
+	get(t, client, "GET", live.URL+"/old", "")
+	get(t, client, "POST", live.URL+"/post", "a=1")
+	live.Close()
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := (&core.Fixture{Provider: "test", Exchanges: recorder.Exchanges()}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := core.LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(fixture)
+	defer srv.Close()
+	replay := Client(srv)
+
+	// The live server is gone, so these can only be answered from the fixture
+	if got := get(t, replay, "GET", live.URL+"/old", ""); got != "new:1" {
+		t.Errorf("redirect replay = %q, want %q", got, "new:1")
+	}
+	if got := get(t, replay, "POST", live.URL+"/post", "a=1"); got != `{"echo":"a=1"}` {
+		t.Errorf("post replay = %q", got)
+	}
+
+	resp, err := replay.Post(live.URL+"/post", "text/plain", strings.NewReader("a=2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unrecorded request got status %d, want 404", resp.StatusCode)
+	}
+}
+
+func get(t *testing.T, client *http.Client, method, url, body string) string {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return string(data)
+}